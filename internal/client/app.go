@@ -2,71 +2,234 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/screamsoul/go-metrics-tpl/internal/client/collectors"
 	"github.com/screamsoul/go-metrics-tpl/internal/client/grpcmetric"
 	"github.com/screamsoul/go-metrics-tpl/internal/client/restymetric"
+	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/interceptors"
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
 	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
 	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
 	"github.com/screamsoul/go-metrics-tpl/pkg/backoff"
+	"github.com/screamsoul/go-metrics-tpl/pkg/idempotency"
 	"github.com/screamsoul/go-metrics-tpl/pkg/logging"
+	"github.com/screamsoul/go-metrics-tpl/pkg/signature"
+	"github.com/screamsoul/go-metrics-tpl/pkg/spool"
 	"github.com/screamsoul/go-metrics-tpl/pkg/utils"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
+const (
+	queueDepthMetric     = "QueueDepth"     // количество пакетов в очереди отправки
+	droppedBatchesMetric = "DroppedBatches" // количество пакетов, отброшенных при заполненной очереди
+)
+
+// batch a part of the report sent by a single request, the key is kept across the retries of the batch.
+type batch struct {
+	key     string
+	metrics []metrics.Metrics
+}
+
+// splitBatches splits the report into batches of at most maxSize metrics, zero size means no limit.
+func splitBatches(metricsList []metrics.Metrics, maxSize int) []batch {
+	if maxSize <= 0 {
+		maxSize = len(metricsList)
+	}
+	var batches []batch
+	for i := 0; i < len(metricsList); i += maxSize {
+		end := min(i+maxSize, len(metricsList))
+		batches = append(batches, batch{key: idempotency.NewKey(), metrics: metricsList[i:end]})
+	}
+	return batches
+}
+
+// producer takes the collected metrics every reportInterval and puts them into the queue in batches.
+// A batch that does not fit into the full queue is dropped and its counter deltas are restored,
+// the depth of the queue and the number of dropped batches are reported with the metrics.
+func producer(
+	ctx context.Context,
+	metricRepo repositories.CollectionMetric,
+	queue chan<- batch,
+	reportInterval time.Duration,
+	maxBatchSize int,
+) {
+	logger := logging.GetLogger()
+	var dropped int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reportInterval):
+		}
+
+		// the counters are sent as the deltas since the previous take
+		metricsList, err := metricRepo.Take(ctx)
+		if err != nil {
+			logger.Error("take metrics error", zap.Error(err))
+			continue
+		}
+
+		depth := float64(len(queue))
+		metricsList = append(metricsList, metrics.Metrics{ID: queueDepthMetric, MType: metrics.Gauge, Value: &depth})
+		if dropped > 0 {
+			droppedDelta := dropped
+			metricsList = append(metricsList, metrics.Metrics{ID: droppedBatchesMetric, MType: metrics.Counter, Delta: &droppedDelta})
+			dropped = 0
+		}
+
+		for _, b := range splitBatches(metricsList, maxBatchSize) {
+			select {
+			case queue <- b:
+			default:
+				dropped++
+				metricRepo.Restore(ctx, b.metrics)
+				logger.Warn("report queue is full, batch dropped", zap.Int("queue_depth", len(queue)), zap.Int("batch_size", len(b.metrics)))
+			}
+		}
+	}
+}
+
+// spoolRecord a batch kept in the spool, the key is stored with the metrics,
+// so the server recognizes a batch resent after a restart.
+type spoolRecord struct {
+	Key     string            `json:"key"`
+	Metrics []metrics.Metrics `json:"metrics"`
+}
+
+// deferBatch puts the undelivered batch into the spool, without the spool
+// the counter deltas of the batch are restored to be sent with the next report.
+func deferBatch(ctx context.Context, metricRepo repositories.CollectionMetric, sp *spool.Spool, b batch) {
+	if sp != nil {
+		payload, err := json.Marshal(spoolRecord{Key: b.key, Metrics: b.metrics})
+		if err == nil {
+			err = sp.Append(payload)
+		}
+		if err == nil {
+			return
+		}
+		logging.GetLogger().Error("spool batch error", zap.Error(err))
+	}
+	metricRepo.Restore(ctx, b.metrics)
+}
+
+// sender sends the batches of the queue, an undelivered batch is deferred by deferBatch.
+// While the spool is not empty the new batches are spooled behind the old ones to keep the order.
 func sender(
 	ctx context.Context,
 	metricRepo repositories.CollectionMetric,
+	queue <-chan batch,
 	backoffIntervals []time.Duration,
 	metricClient MetricsClient,
-	reportInterval time.Duration,
+	sp *spool.Spool,
 ) {
 	logger := logging.GetLogger()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			metricsList, err := metricRepo.List(ctx)
-			if err != nil {
-				panic(err)
+		case b := <-queue:
+			if sp != nil && sp.Len() > 0 {
+				deferBatch(ctx, metricRepo, sp, b)
+				continue
 			}
 
+			// the retries of the batch carry the same key, so the server applies it at most once
+			batchCtx := idempotency.WithKey(ctx, b.key)
 			sendMetric := func() error {
-				return metricClient.SendMetric(ctx, metricsList)
+				return metricClient.SendMetric(batchCtx, b.metrics)
 			}
 
 			if err := backoff.RetryWithBackoff(backoffIntervals, IsTemporaryNetworkError, sendMetric); err != nil {
 				logger.Error("send metric error", zap.Error(err))
+				deferBatch(ctx, metricRepo, sp, b)
 			}
 		}
-
-		time.Sleep(reportInterval)
 	}
 }
 
-func updater(
+// replayer resends the spooled batches in order, a batch is removed from the spool once
+// the server has accepted or rejected it. The spool is retried every retryInterval.
+func replayer(
 	ctx context.Context,
-	metricRepo repositories.CollectionMetric,
-	pollInterval time.Duration,
+	sp *spool.Spool,
+	backoffIntervals []time.Duration,
+	metricClient MetricsClient,
+	retryInterval time.Duration,
 ) {
+	logger := logging.GetLogger()
 	for {
+		payload, pos, ok, err := sp.Peek()
+		if err != nil {
+			logger.Error("read spool error", zap.Error(err))
+		}
+		if err == nil && ok {
+			err = replay(ctx, payload, backoffIntervals, metricClient)
+			if err == nil || errors.Is(err, spool.ErrCorrupted) || IsRejectedError(err) {
+				if err != nil {
+					logger.Error("spooled batch rejected", zap.Error(err))
+				}
+				if err = sp.Commit(pos); err != nil {
+					logger.Error("commit spool error", zap.Error(err))
+				}
+				continue
+			}
+			logger.Warn("replay spooled batch error", zap.Int("spooled", sp.Len()), zap.Error(err))
+		}
+
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			metricRepo.Update()
-			metricRepo.UpdateRuntime()
-			metricRepo.UpdateGopsutil()
-			time.Sleep(pollInterval)
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// replay sends the spooled batch with its original idempotency key.
+func replay(ctx context.Context, payload []byte, backoffIntervals []time.Duration, metricClient MetricsClient) error {
+	var record spoolRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return fmt.Errorf("%w: %w", spool.ErrCorrupted, err)
+	}
+
+	batchCtx := idempotency.WithKey(ctx, record.Key)
+	return backoff.RetryWithBackoff(backoffIntervals, IsTemporaryNetworkError, func() error {
+		return metricClient.SendMetric(batchCtx, record.Metrics)
+	})
+}
+
+// newRegistry registers the collectors enabled in the config, a collector is polled
+// every PollInterval seconds unless its own interval is set.
+func newRegistry(cfg *Config) (*collectors.Registry, error) {
+	registry := collectors.NewRegistry()
+	for _, name := range cfg.Collectors {
+		c, err := collectors.New(name)
+		if err != nil {
+			return nil, err
+		}
+
+		interval := time.Duration(cfg.PollInterval) * time.Second
+		if seconds, ok := cfg.CollectorIntervals[name]; ok {
+			interval = time.Duration(seconds) * time.Second
+		}
+		timeout := interval
+		if seconds, ok := cfg.CollectorTimeouts[name]; ok {
+			timeout = time.Duration(seconds) * time.Second
+		}
+
+		if err := registry.Register(c, interval, timeout); err != nil {
+			return nil, err
 		}
 	}
+	return registry, nil
 }
 
 func Start(cfg *Config, logger *zap.Logger) {
@@ -78,26 +241,76 @@ func Start(cfg *Config, logger *zap.Logger) {
 
 	metricRepo := memory.NewCollectionMetricStorage()
 
-	pollInterval := time.Duration(cfg.PollInterval) * time.Second
+	registry, err := newRegistry(cfg)
+	if err != nil {
+		logger.Fatal("collectors config fail", zap.Error(err))
+	}
 	reportInterval := time.Duration(cfg.ReportInterval) * time.Second
 
 	var metricClient MetricsClient
 
 	if cfg.GRPCClient {
-		conn, err := grpc.NewClient(cfg.ListenServerHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		creds, err := grpcCredentials(cfg)
+		if err != nil {
+			logger.Fatal("grpc tls config fail", zap.Error(err))
+		}
+		conn, err := grpc.NewClient(
+			cfg.ListenServerHost,
+			grpc.WithTransportCredentials(creds),
+			grpc.WithUnaryInterceptor(interceptors.NewHashSumClientInterceptor(signature.ParseKeys(cfg.HashBodyKey))),
+			grpc.WithStreamInterceptor(interceptors.NewHashSumStreamClientInterceptor(signature.ParseKeys(cfg.HashBodyKey))),
+		)
 		if err != nil {
 			logger.Fatal("connect to grpc server fail", zap.Error(err))
 		}
 		defer utils.CloseForse(conn)
-		metricClient = grpcmetric.NewGRPCMetricsClient(conn)
+		if cfg.GRPCStream {
+			streamClient := grpcmetric.NewGRPCStreamMetricsClient(conn)
+			defer utils.CloseForse(streamClient)
+			metricClient = streamClient
+		} else {
+			metricClient = grpcmetric.NewGRPCMetricsClient(conn)
+		}
 	} else {
-		metricClient = restymetric.NewRestyMetricsClient(cfg.CompressRequest, cfg.HashBodyKey, cfg.GetUpdateMetricURL(), cfg.GetLocalIP(), cfg.CryptoKey.Key)
+		metricClient = restymetric.NewRestyMetricsClient(
+			cfg.CompressRequest, signature.ParseKeys(cfg.HashBodyKey), cfg.GetUpdateMetricURL(), cfg.GetLocalIP(), cfg.CryptoKey.Key,
+			time.Duration(cfg.RequestTimeout)*time.Second,
+		)
 	}
 
-	go updater(ctx, metricRepo, pollInterval)
+	var sp *spool.Spool
+	if cfg.SpoolDir != "" {
+		sp, err = spool.Open(cfg.SpoolDir, int64(cfg.SpoolMaxSize)<<20, time.Duration(cfg.SpoolMaxAge)*time.Second)
+		if err != nil {
+			logger.Fatal("open spool fail", zap.Error(err))
+		}
+		logger.Info("use spool", zap.String("dir", cfg.SpoolDir), zap.Int("spooled", sp.Len()))
+	}
+
+	queue := make(chan batch, cfg.QueueSize)
+	var wg sync.WaitGroup
+
+	logger.Info("start collectors", zap.Strings("collectors", registry.Names()))
+	go registry.Run(ctx, metricRepo)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		producer(ctx, metricRepo, queue, reportInterval, cfg.MaxBatchSize)
+	}()
 	logger.Info("start senders", zap.Uint("count_senders", cfg.RateLimit))
 	for i := uint(0); i < cfg.RateLimit; i++ {
-		go sender(ctx, metricRepo, cfg.BackoffIntervals, metricClient, reportInterval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sender(ctx, metricRepo, queue, cfg.BackoffIntervals, metricClient, sp)
+		}()
+	}
+	if sp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replayer(ctx, sp, cfg.BackoffIntervals, metricClient, reportInterval)
+		}()
 	}
 
 	// gracefull close
@@ -110,5 +323,14 @@ func Start(cfg *Config, logger *zap.Logger) {
 	}()
 
 	<-ctx.Done()
+	wg.Wait()
+	if sp != nil {
+		// the batches left in the queue are sent after the restart
+		close(queue)
+		for b := range queue {
+			deferBatch(ctx, metricRepo, sp, b)
+		}
+		utils.CloseForse(sp)
+	}
 	fmt.Println("Agent gracefully closed:", ctx.Err())
 }
@@ -2,15 +2,25 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/screamsoul/go-metrics-tpl/internal/client/collectors"
 	"github.com/screamsoul/go-metrics-tpl/internal/client/restymetric"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/screamsoul/go-metrics-tpl/internal/server"
+	"github.com/screamsoul/go-metrics-tpl/pkg/idempotency"
+	"github.com/screamsoul/go-metrics-tpl/pkg/spool"
 
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -26,65 +36,365 @@ func (m *MockMetricStorage) List(ctx context.Context) ([]metrics.Metrics, error)
 	return args.Get(0).([]metrics.Metrics), args.Error(1)
 }
 
-func (m *MockMetricStorage) Update() {
-	m.Called()
+func (m *MockMetricStorage) Take(ctx context.Context) ([]metrics.Metrics, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]metrics.Metrics), args.Error(1)
 }
 
-func (m *MockMetricStorage) UpdateRuntime() {
-	m.Called()
+func (m *MockMetricStorage) Restore(ctx context.Context, list []metrics.Metrics) {
+	m.Called(ctx, list)
+}
+
+func (m *MockMetricStorage) BulkAdd(ctx context.Context, list []metrics.Metrics) error {
+	args := m.Called(ctx, list)
+	return args.Error(0)
+}
+
+func TestNewRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Client
+		want    []string
+		wantErr bool
+	}{
+		{"default", Client{Collectors: []string{"random", "runtime", "gopsutil"}}, []string{"random", "runtime", "gopsutil"}, false},
+		{"disabled", Client{Collectors: []string{"runtime"}, CollectorIntervals: map[string]int{"runtime": 5}}, []string{"runtime"}, false},
+		{"system", Client{Collectors: []string{"disk", "net", "load", "swap", "process"}}, []string{"disk", "net", "load", "swap", "process"}, false},
+		{"none", Client{}, []string{}, false},
+		{"unknown", Client{Collectors: []string{"random", "unknown"}}, nil, true},
+		{"duplicate", Client{Collectors: []string{"random", "random"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := newRegistry(&Config{Client: tt.cfg})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, registry.Names())
+		})
+	}
+}
+
+// poll stores the metrics of a single poll of the random collector.
+func poll(t *testing.T, collection *memory.CollectionMetricStorage) {
+	t.Helper()
+	list, err := collectors.NewRandomCollector().Collect(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, collection.BulkAdd(context.Background(), list))
+}
+
+// recordingClient records the idempotency keys of the sent batches.
+type recordingClient struct {
+	keys chan string
+	err  error
+}
+
+func (c *recordingClient) SendMetric(ctx context.Context, metricsList []metrics.Metrics) error {
+	c.keys <- idempotency.FromContext(ctx)
+	return c.err
+}
+
+// Successfully sends the batches of the queue using metricClient
+func TestSender_SuccessfullySendsMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metricClient := &recordingClient{keys: make(chan string, 2)}
+	mockMetricStorage := new(MockMetricStorage)
+
+	queue := make(chan batch, 2)
+	metricsList := []metrics.Metrics{{ID: "test_metric", MType: metrics.Gauge, Value: new(float64)}}
+	queue <- batch{key: "batch1", metrics: metricsList}
+	queue <- batch{key: "batch2", metrics: metricsList}
+
+	go sender(ctx, mockMetricStorage, queue, []time.Duration{time.Millisecond}, metricClient, nil)
+
+	assert.Equal(t, "batch1", <-metricClient.keys)
+	assert.Equal(t, "batch2", <-metricClient.keys)
+	mockMetricStorage.AssertNotCalled(t, "Restore", mock.Anything, mock.Anything)
 }
 
-func (m *MockMetricStorage) UpdateGopsutil() {
-	m.Called()
+// The counter deltas of an undelivered batch are restored.
+func TestSender_RestoresFailedBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metricClient := &recordingClient{keys: make(chan string, 1), err: errors.New("bad request")}
+	mockMetricStorage := new(MockMetricStorage)
+
+	delta := int64(5)
+	metricsList := []metrics.Metrics{{ID: "PollCount", MType: metrics.Counter, Delta: &delta}}
+	restored := make(chan struct{})
+	mockMetricStorage.On("Restore", mock.Anything, metricsList).Return().Run(func(mock.Arguments) { close(restored) })
+
+	queue := make(chan batch, 1)
+	queue <- batch{key: "batch1", metrics: metricsList}
+
+	go sender(ctx, mockMetricStorage, queue, nil, metricClient, nil)
+
+	assert.Equal(t, "batch1", <-metricClient.keys)
+	<-restored
 }
 
-func TestUpdaterUpdatesMetricsAtRegularIntervals(t *testing.T) {
+// An undelivered batch is spooled with its key, the following batches are spooled behind it.
+func TestSender_SpoolsFailedBatch(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	mockRepo := new(MockMetricStorage)
-	pollInterval := 100 * time.Millisecond
+	sp, err := spool.Open(t.TempDir(), 1<<20, 0)
+	require.NoError(t, err)
+	defer sp.Close()
+
+	metricClient := &recordingClient{keys: make(chan string, 2), err: errors.New("server is down")}
+	mockMetricStorage := new(MockMetricStorage)
+
+	delta := int64(5)
+	metricsList := []metrics.Metrics{{ID: "PollCount", MType: metrics.Counter, Delta: &delta}}
+	queue := make(chan batch, 2)
+	queue <- batch{key: "batch1", metrics: metricsList}
+	queue <- batch{key: "batch2", metrics: metricsList}
 
-	mockRepo.On("Update").Return()
-	mockRepo.On("UpdateRuntime").Return()
-	mockRepo.On("UpdateGopsutil").Return()
+	go sender(ctx, mockMetricStorage, queue, nil, metricClient, sp)
 
-	go updater(ctx, mockRepo, pollInterval)
+	assert.Equal(t, "batch1", <-metricClient.keys)
+	require.Eventually(t, func() bool { return sp.Len() == 2 }, time.Second, time.Millisecond)
+	assert.Empty(t, metricClient.keys, "the batch is not sent while the spool is not empty")
+	mockMetricStorage.AssertNotCalled(t, "Restore", mock.Anything, mock.Anything)
 
-	time.Sleep(300 * time.Millisecond)
+	var keys []string
+	for sp.Len() > 0 {
+		payload, pos, ok, err := sp.Peek()
+		require.NoError(t, err)
+		require.True(t, ok)
+		var record spoolRecord
+		require.NoError(t, json.Unmarshal(payload, &record))
+		assert.Equal(t, metricsList, record.Metrics)
+		keys = append(keys, record.Key)
+		require.NoError(t, sp.Commit(pos))
+	}
+	assert.Equal(t, []string{"batch1", "batch2"}, keys)
+}
+
+// The spooled batches are replayed in order after a restart of the agent once the server is back,
+// the batch applied before the restart is not applied twice and the rejected batch is dropped.
+func TestReplayerAfterRestart(t *testing.T) {
+	store := memory.NewMemStorage()
+	store.EnableIdempotency(time.Minute, 1000)
+	router := server.NewRouter(&server.Config{}, store)
+
+	var down atomic.Bool
+	down.Store(true)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		router.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	metricClient := restymetric.NewRestyMetricsClient(false, nil, ts.URL+"/updates/", "", nil, time.Second)
+
+	dir := t.TempDir()
+	sp, err := spool.Open(dir, 1<<20, 0)
+	require.NoError(t, err)
+
+	newBatch := func(key string, delta int64, value float64) batch {
+		return batch{key: key, metrics: []metrics.Metrics{
+			{ID: "PollCount", MType: metrics.Counter, Delta: &delta},
+			{ID: "RandomValue", MType: metrics.Gauge, Value: &value},
+		}}
+	}
+	batches := []batch{newBatch("batch1", 1, 1), newBatch("batch2", 2, 2), newBatch("batch3", 3, 3)}
+	for _, b := range batches {
+		deferBatch(context.Background(), nil, sp, b)
+	}
+	deferBatch(context.Background(), nil, sp, batch{key: "invalid key", metrics: batches[0].metrics})
+	deferBatch(context.Background(), nil, sp, newBatch("batch4", 4, 4))
+
+	// the server is unavailable, nothing is delivered
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		replayer(ctx, sp, nil, metricClient, time.Millisecond)
+	}()
+	time.Sleep(20 * time.Millisecond)
 	cancel()
+	<-done
+	assert.Equal(t, 5, sp.Len())
+	require.NoError(t, sp.Close())
+
+	// the first batch was applied, but the agent was stopped before the spool was committed
+	down.Store(false)
+	require.NoError(t, metricClient.SendMetric(idempotency.WithKey(context.Background(), "batch1"), batches[0].metrics))
 
-	mockRepo.AssertNumberOfCalls(t, "Update", 3)
-	mockRepo.AssertNumberOfCalls(t, "UpdateRuntime", 3)
-	mockRepo.AssertNumberOfCalls(t, "UpdateGopsutil", 3)
+	sp, err = spool.Open(dir, 1<<20, 0)
+	require.NoError(t, err)
+	defer sp.Close()
+	assert.Equal(t, 5, sp.Len())
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go replayer(ctx, sp, nil, metricClient, time.Millisecond)
+	require.Eventually(t, func() bool { return sp.Len() == 0 }, time.Second, time.Millisecond)
+
+	counter := metrics.Metrics{ID: "PollCount", MType: metrics.Counter}
+	require.NoError(t, store.Get(context.Background(), &counter))
+	assert.Equal(t, int64(1+2+3+4), *counter.Delta)
+
+	gauge := metrics.Metrics{ID: "RandomValue", MType: metrics.Gauge}
+	require.NoError(t, store.Get(context.Background(), &gauge))
+	assert.Equal(t, float64(4), *gauge.Value, "the batches are replayed in order")
 }
 
-// Successfully retrieves metrics from metricRepo and sends them using metricClient
-func TestSender_SuccessfullySendsMetrics(t *testing.T) {
+func TestSplitBatches(t *testing.T) {
+	metricsList := make([]metrics.Metrics, 5)
+
+	tests := []struct {
+		name    string
+		maxSize int
+		want    []int
+	}{
+		{"split", 2, []int{2, 2, 1}},
+		{"exact", 5, []int{5}},
+		{"unlimited", 0, []int{5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches := splitBatches(metricsList, tt.maxSize)
+			var sizes []int
+			keys := make(map[string]bool)
+			for _, b := range batches {
+				sizes = append(sizes, len(b.metrics))
+				keys[b.key] = true
+			}
+			assert.Equal(t, tt.want, sizes)
+			assert.Len(t, keys, len(batches), "every batch has its own key")
+		})
+	}
+	assert.Empty(t, splitBatches(nil, 2))
+}
+
+// The batches that do not fit into the queue are dropped without losing the counter deltas.
+func TestProducerDropsBatches(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Create a mock server
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+	collection := memory.NewCollectionMetricStorage()
+	queue := make(chan batch, 1)
+
+	poll(t, collection)
+	go producer(ctx, collection, queue, time.Millisecond, 0)
+
+	// nobody reads the queue, the following reports are dropped
+	first := <-queue
+	poll(t, collection)
+	time.Sleep(20 * time.Millisecond)
+	poll(t, collection)
+
+	find := func(list []metrics.Metrics, id string) *metrics.Metrics {
+		for i := range list {
+			if list[i].ID == id {
+				return &list[i]
+			}
+		}
+		return nil
+	}
+
+	var polls int64
+	var report []metrics.Metrics
+	require.Eventually(t, func() bool {
+		b := <-queue
+		if m := find(b.metrics, "PollCount"); m != nil {
+			polls += *m.Delta
+		}
+		report = b.metrics
+		return find(b.metrics, droppedBatchesMetric) != nil
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, int64(1), *find(first.metrics, "PollCount").Delta)
+	assert.Equal(t, int64(2), polls, "the deltas of the dropped batches are sent later")
+	assert.Greater(t, *find(report, droppedBatchesMetric).Delta, int64(0))
+	assert.NotNil(t, find(report, queueDepthMetric))
+}
+
+// The server counter equals the number of polls when several senders report concurrently
+// and some of the reports fail, at most RateLimit requests are sent at once.
+func TestSenderCounterDeltas(t *testing.T) {
+	store := memory.NewMemStorage()
+	store.EnableIdempotency(time.Minute, 1000)
+	router := server.NewRouter(&server.Config{}, store)
+
+	const rateLimit = 3
+	var requests, inFlight, maxInFlight atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			max := maxInFlight.Load()
+			if n <= max || maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		// every third report is rejected before it is applied
+		if requests.Add(1)%3 == 0 {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		router.ServeHTTP(w, r)
 	}))
-	defer server.Close()
+	defer ts.Close()
 
-	// Create a MetricsClient instance
-	metricClient := restymetric.NewRestyMetricsClient(
-		false, "", server.URL, "127.0.0.1", nil,
-	)
-	mockMetricStorage := new(MockMetricStorage)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	metricsList := []metrics.Metrics{{ID: "test_metric", MType: metrics.Gauge, Value: new(float64)}}
-	mockMetricStorage.On("List", ctx).Return(metricsList, nil)
+	collection := memory.NewCollectionMetricStorage()
+	metricClient := restymetric.NewRestyMetricsClient(true, nil, ts.URL+"/updates/", "", nil, time.Second)
+
+	queue := make(chan batch, 4)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		producer(ctx, collection, queue, time.Millisecond, 1)
+	}()
+	for i := 0; i < rateLimit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sender(ctx, collection, queue, nil, metricClient, nil)
+		}()
+	}
 
-	backoffIntervals := []time.Duration{time.Millisecond}
-	reportInterval := time.Millisecond
+	const polls = 200
+	for i := 0; i < polls; i++ {
+		poll(t, collection)
+		if i%10 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
 
-	go sender(ctx, mockMetricStorage, backoffIntervals, metricClient, reportInterval)
+	pollCount := func() int64 {
+		counter := metrics.Metrics{ID: "PollCount", MType: metrics.Counter}
+		if err := store.Get(context.Background(), &counter); err != nil {
+			return 0
+		}
+		return *counter.Delta
+	}
+	require.Eventually(t, func() bool { return pollCount() == polls }, 5*time.Second, 10*time.Millisecond)
 
-	time.Sleep(2 * reportInterval)
+	// nothing is sent twice
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(polls), pollCount())
+	assert.Greater(t, requests.Load(), int64(3))
+	assert.LessOrEqual(t, maxInFlight.Load(), int64(rateLimit))
+
+	cancel()
+	wg.Wait()
 }
 
 func TestStartAgent(t *testing.T) {
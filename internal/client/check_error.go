@@ -4,23 +4,54 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"syscall"
 
 	"github.com/go-resty/resty/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// temporaryStatuses the response statuses after which the request is repeated,
+// the server has not applied the request or asks to repeat it later.
+var temporaryStatuses = map[int]bool{
+	http.StatusRequestTimeout:     true,
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
 func IsTemporaryNetworkError(err error) bool {
+	var respErr *resty.ResponseError
+	if errors.As(err, &respErr) {
+		return temporaryStatuses[respErr.Response.StatusCode()]
+	}
+
+	// the server is not listening yet or restarts
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
 	var netErr net.Error
 	if errors.As(err, &netErr) {
 		return netErr.Timeout()
 	}
 
+	return false
+}
+
+// IsRejectedError reports whether the server has rejected the request itself,
+// repeating such a request later does not help.
+func IsRejectedError(err error) bool {
 	var respErr *resty.ResponseError
 	if errors.As(err, &respErr) {
-		if respErr.Response.StatusCode() == http.StatusRequestTimeout ||
-			respErr.Response.StatusCode() == http.StatusServiceUnavailable {
-			return true
-		}
+		code := respErr.Response.StatusCode()
+		return code >= http.StatusBadRequest && code < http.StatusInternalServerError && !temporaryStatuses[code]
 	}
 
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.DataLoss:
+		return true
+	}
 	return false
 }
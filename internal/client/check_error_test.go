@@ -4,10 +4,14 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"os"
+	"syscall"
 	"testing"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestIsTemporaryNetworkError(t *testing.T) {
@@ -51,6 +55,39 @@ func TestIsTemporaryNetworkError(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "resty.ResponseError too many requests",
+			err: &resty.ResponseError{
+				Response: &resty.Response{
+					Request: &resty.Request{},
+					RawResponse: &http.Response{
+						StatusCode: http.StatusTooManyRequests,
+					},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "resty.ResponseError bad request",
+			err: &resty.ResponseError{
+				Response: &resty.Response{
+					Request: &resty.Request{},
+					RawResponse: &http.Response{
+						StatusCode: http.StatusBadRequest,
+					},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "connection refused",
+			err: &net.OpError{
+				Op:  "dial",
+				Net: "tcp",
+				Err: os.NewSyscallError("connect", syscall.ECONNREFUSED),
+			},
+			want: true,
+		},
 		{
 			name: "resty.ResponseError other status code",
 			err: &resty.ResponseError{
@@ -77,3 +114,33 @@ func TestIsTemporaryNetworkError(t *testing.T) {
 		})
 	}
 }
+
+func TestIsRejectedError(t *testing.T) {
+	responseError := func(code int) error {
+		return &resty.ResponseError{
+			Response: &resty.Response{
+				Request:     &resty.Request{},
+				RawResponse: &http.Response{StatusCode: code},
+			},
+		}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad request", responseError(http.StatusBadRequest), true},
+		{"too many requests", responseError(http.StatusTooManyRequests), false},
+		{"internal error", responseError(http.StatusInternalServerError), false},
+		{"grpc invalid argument", status.Error(codes.InvalidArgument, "bad metric"), true},
+		{"grpc unavailable", status.Error(codes.Unavailable, "no server"), false},
+		{"other error", errors.New("some other error"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRejectedError(tt.err))
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Package collectors contains the sources of the agent metrics and the registry which polls them.
+//
+// The metrics are named after the subsystem and the measured value, e.g. DiskUsedBytes or NetBytesRecv,
+// the values of a device are told apart by a label: `mountpoint` for the file systems, `device` for the disks
+// and `interface` for the network interfaces. The monotonic counters of the OS are reported as the counter
+// deltas since the previous poll.
+package collectors
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+// Collector a source of metrics polled by the registry. Collect returns the current gauges
+// and the counter deltas since the previous call, it should return when ctx is done.
+// The registry does not call Collect of a collector concurrently.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) ([]metrics.Metrics, error)
+}
+
+// Sink accepts the collected metrics, the gauges are replaced and the counter deltas are added.
+type Sink interface {
+	BulkAdd(ctx context.Context, metricList []metrics.Metrics) error
+}
+
+// builtin the constructors of the collectors by name.
+var builtin = map[string]func() Collector{
+	randomName:   func() Collector { return NewRandomCollector() },
+	runtimeName:  func() Collector { return NewRuntimeCollector() },
+	gopsutilName: func() Collector { return NewGopsutilCollector() },
+	diskName:     func() Collector { return NewDiskCollector() },
+	netName:      func() Collector { return NewNetCollector() },
+	loadName:     func() Collector { return NewLoadCollector() },
+	swapName:     func() Collector { return NewSwapCollector() },
+	processName:  func() Collector { return NewProcessCollector() },
+}
+
+// New creates the builtin collector by its name.
+func New(name string) (Collector, error) {
+	newCollector, ok := builtin[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown collector %q", name)
+	}
+	return newCollector(), nil
+}
+
+func gauge(name string, value float64) metrics.Metrics {
+	return metrics.Metrics{ID: name, MType: metrics.Gauge, Value: &value}
+}
+
+func labeledGauge(name string, labels metrics.Labels, value float64) metrics.Metrics {
+	m := gauge(name, value)
+	m.Labels = labels
+	return m
+}
+
+func counter(name string, delta int64) metrics.Metrics {
+	return metrics.Metrics{ID: name, MType: metrics.Counter, Delta: &delta}
+}
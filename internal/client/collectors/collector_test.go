@@ -0,0 +1,136 @@
+package collectors
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func AbsPercentageChange[T ~int | ~float64](old, new T) (delta float64) {
+	diff := float64(new - old)
+	delta = (diff / float64(old)) * 100
+	delta = math.Abs(delta)
+	return
+}
+
+func find(t *testing.T, list []metrics.Metrics, id string) metrics.Metrics {
+	t.Helper()
+	for _, m := range list {
+		if m.ID == id {
+			return m
+		}
+	}
+	require.Failf(t, "metric not found", "metric %s is not collected", id)
+	return metrics.Metrics{}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"random", "runtime", "gopsutil", "disk", "net", "load", "swap", "process"} {
+		c, err := New(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, c.Name())
+	}
+
+	_, err := New("unknown")
+	assert.Error(t, err)
+}
+
+func TestRandomCollector(t *testing.T) {
+	list, err := NewRandomCollector().Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), *find(t, list, "PollCount").Delta)
+	assert.Equal(t, metrics.Gauge, find(t, list, "RandomValue").MType)
+}
+
+func TestRuntimeCollector(t *testing.T) {
+	list, err := NewRuntimeCollector().Collect(context.Background())
+	require.NoError(t, err)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	alloc := *find(t, list, "Alloc").Value
+	assert.Less(t, AbsPercentageChange(alloc, float64(mem.Alloc)), float64(20), "Expected Alloc to be %v, got %v", float64(mem.Alloc), alloc)
+	assert.Len(t, list, 27)
+}
+
+func TestGopsutilCollector(t *testing.T) {
+	list, err := NewGopsutilCollector().Collect(context.Background())
+	require.NoError(t, err)
+
+	memory, err := mem.VirtualMemory()
+	require.NoError(t, err)
+
+	total := *find(t, list, "TotalMemory").Value
+	assert.Less(t, AbsPercentageChange(total, float64(memory.Total)), float64(20), "Expected TotalMemory to be %v, got %v", float64(memory.Total), total)
+	assert.Equal(t, metrics.Gauge, find(t, list, "FreeMemory").MType)
+	assert.Equal(t, metrics.Gauge, find(t, list, "CPUutilization1").MType)
+}
+
+// collectTwice returns the metrics of the second poll, the counter deltas are known after the first one.
+func collectTwice(t *testing.T, c Collector) []metrics.Metrics {
+	t.Helper()
+	first, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	for _, m := range first {
+		assert.NotEqual(t, metrics.Counter, m.MType, "there are no deltas after the first poll")
+	}
+
+	list, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	return list
+}
+
+func TestNetCollector(t *testing.T) {
+	list := collectTwice(t, NewNetCollector())
+	require.NotEmpty(t, list)
+
+	for _, m := range list {
+		assert.Equal(t, metrics.Counter, m.MType)
+		assert.GreaterOrEqual(t, *m.Delta, int64(0))
+		assert.NotEmpty(t, m.Labels["interface"], "metric %s has no interface", m.ID)
+	}
+	find(t, list, "NetBytesRecv")
+	find(t, list, "NetPacketsSent")
+}
+
+func TestDiskCollector(t *testing.T) {
+	list, err := NewDiskCollector().Collect(context.Background())
+	if err != nil {
+		t.Skipf("disk statistics are not available: %v", err)
+	}
+
+	used := find(t, list, "DiskUsedBytes")
+	assert.NotEmpty(t, used.Labels["mountpoint"])
+	assert.GreaterOrEqual(t, *find(t, list, "DiskUsedPercent").Value, float64(0))
+}
+
+func TestLoadCollector(t *testing.T) {
+	list, err := NewLoadCollector().Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Len(t, list, 3)
+	assert.GreaterOrEqual(t, *find(t, list, "LoadAverage1").Value, float64(0))
+}
+
+func TestSwapCollector(t *testing.T) {
+	list := collectTwice(t, NewSwapCollector())
+
+	assert.Equal(t, metrics.Gauge, find(t, list, "SwapTotalBytes").MType)
+	assert.Equal(t, metrics.Gauge, find(t, list, "SwapFreeBytes").MType)
+	assert.Equal(t, metrics.Counter, find(t, list, "SwapInBytes").MType)
+}
+
+func TestProcessCollector(t *testing.T) {
+	list := collectTwice(t, NewProcessCollector())
+
+	assert.Greater(t, *find(t, list, "ProcessesTotal").Value, float64(0))
+	assert.GreaterOrEqual(t, *find(t, list, "ContextSwitches").Delta, int64(0))
+}
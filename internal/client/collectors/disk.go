@@ -0,0 +1,75 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+const diskName = "disk"
+
+// DiskCollector reports the usage of the mounted file systems and the I/O counters of the disks.
+type DiskCollector struct {
+	counters *monotonic
+}
+
+func NewDiskCollector() *DiskCollector {
+	return &DiskCollector{counters: newMonotonic()}
+}
+
+func (c *DiskCollector) Name() string {
+	return diskName
+}
+
+// Collect reports the usage even if the I/O counters are not available and vice versa.
+func (c *DiskCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	usage, usageErr := c.usage(ctx)
+	io, ioErr := c.io(ctx)
+	return append(usage, io...), errors.Join(usageErr, ioErr)
+}
+
+func (c *DiskCollector) usage(ctx context.Context) ([]metrics.Metrics, error) {
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []metrics.Metrics
+	var errs []error
+	for _, p := range partitions {
+		usage, err := disk.UsageWithContext(ctx, p.Mountpoint)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		labels := metrics.Labels{"mountpoint": p.Mountpoint}
+		list = append(list,
+			labeledGauge("DiskTotalBytes", labels, float64(usage.Total)),
+			labeledGauge("DiskUsedBytes", labels, float64(usage.Used)),
+			labeledGauge("DiskFreeBytes", labels, float64(usage.Free)),
+			labeledGauge("DiskUsedPercent", labels, usage.UsedPercent),
+		)
+	}
+	return list, errors.Join(errs...)
+}
+
+func (c *DiskCollector) io(ctx context.Context) ([]metrics.Metrics, error) {
+	counters, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.counters.forget()
+
+	var list []metrics.Metrics
+	for device, stat := range counters {
+		labels := metrics.Labels{"device": device}
+		list = c.counters.appendDelta(list, "DiskReadBytes", labels, stat.ReadBytes)
+		list = c.counters.appendDelta(list, "DiskWrittenBytes", labels, stat.WriteBytes)
+		list = c.counters.appendDelta(list, "DiskReads", labels, stat.ReadCount)
+		list = c.counters.appendDelta(list, "DiskWrites", labels, stat.WriteCount)
+		list = c.counters.appendDelta(list, "DiskIOTimeMs", labels, stat.IoTime)
+	}
+	return list, nil
+}
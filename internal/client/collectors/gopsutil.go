@@ -0,0 +1,43 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+const gopsutilName = "gopsutil"
+
+// GopsutilCollector reports the system memory and the CPU utilization.
+type GopsutilCollector struct{}
+
+func NewGopsutilCollector() *GopsutilCollector {
+	return &GopsutilCollector{}
+}
+
+func (c *GopsutilCollector) Name() string {
+	return gopsutilName
+}
+
+func (c *GopsutilCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	memory, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := []metrics.Metrics{
+		gauge("TotalMemory", float64(memory.Total)),
+		gauge("FreeMemory", float64(memory.Free)),
+	}
+
+	cpuPercents, err := cpu.PercentWithContext(ctx, 0, false)
+	if err != nil {
+		return list, err
+	}
+	for i, percent := range cpuPercents {
+		list = append(list, gauge(fmt.Sprintf("CPUutilization%d", i+1), percent))
+	}
+	return list, nil
+}
@@ -0,0 +1,33 @@
+package collectors
+
+import (
+	"context"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/shirou/gopsutil/v3/load"
+)
+
+const loadName = "load"
+
+// LoadCollector reports the system load averages.
+type LoadCollector struct{}
+
+func NewLoadCollector() *LoadCollector {
+	return &LoadCollector{}
+}
+
+func (c *LoadCollector) Name() string {
+	return loadName
+}
+
+func (c *LoadCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []metrics.Metrics{
+		gauge("LoadAverage1", avg.Load1),
+		gauge("LoadAverage5", avg.Load5),
+		gauge("LoadAverage15", avg.Load15),
+	}, nil
+}
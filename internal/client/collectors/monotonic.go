@@ -0,0 +1,56 @@
+package collectors
+
+import (
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+// monotonic turns the monotonic counters of the OS into the counter deltas since the previous poll.
+type monotonic struct {
+	last map[string]uint64 // последние значения счетчиков по серии
+	seen map[string]bool   // счетчики, полученные с последнего вызова forget
+}
+
+func newMonotonic() *monotonic {
+	return &monotonic{
+		last: make(map[string]uint64),
+		seen: make(map[string]bool),
+	}
+}
+
+// delta returns the increase of the counter since the previous poll. There is no delta
+// for the first value of the counter and for the value which is less than the previous one,
+// the counter has been reset then, e.g. the device has been re-attached.
+func (c *monotonic) delta(name string, labels metrics.Labels, value uint64) (metrics.Metrics, bool) {
+	m := metrics.Metrics{ID: name, MType: metrics.Counter, Labels: labels}
+	key := m.SeriesKey()
+
+	prev, ok := c.last[key]
+	c.last[key] = value
+	c.seen[key] = true
+	if !ok || value < prev {
+		return m, false
+	}
+
+	delta := int64(value - prev)
+	m.Delta = &delta
+	return m, true
+}
+
+// appendDelta appends the delta of the counter to the list if there is one.
+func (c *monotonic) appendDelta(list []metrics.Metrics, name string, labels metrics.Labels, value uint64) []metrics.Metrics {
+	if m, ok := c.delta(name, labels, value); ok {
+		list = append(list, m)
+	}
+	return list
+}
+
+// forget drops the counters which have not been polled since the previous call,
+// so the removed devices do not pile up.
+func (c *monotonic) forget() {
+	for key := range c.last {
+		if !c.seen[key] {
+			delete(c.last, key)
+		}
+	}
+	clear(c.seen)
+}
@@ -0,0 +1,62 @@
+package collectors
+
+import (
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonotonic(t *testing.T) {
+	eth0 := metrics.Labels{"interface": "eth0"}
+	eth1 := metrics.Labels{"interface": "eth1"}
+
+	tests := []struct {
+		name   string
+		labels metrics.Labels
+		value  uint64
+		want   int64
+		ok     bool
+	}{
+		{"first value", eth0, 100, 0, false},
+		{"other device", eth1, 5, 0, false},
+		{"increase", eth0, 150, 50, true},
+		{"no change", eth0, 150, 0, true},
+		{"reset", eth0, 10, 0, false},
+		{"after reset", eth0, 25, 15, true},
+		{"other device increase", eth1, 7, 2, true},
+	}
+
+	counters := newMonotonic()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := counters.delta("NetBytesRecv", tt.labels, tt.value)
+			assert.Equal(t, tt.ok, ok)
+			if ok {
+				assert.Equal(t, tt.want, *m.Delta)
+				assert.Equal(t, metrics.Counter, m.MType)
+				assert.Equal(t, tt.labels, m.Labels)
+			}
+		})
+	}
+}
+
+// The counter of a removed device starts over when the device is back.
+func TestMonotonicForget(t *testing.T) {
+	counters := newMonotonic()
+	sda := metrics.Labels{"device": "sda"}
+	sdb := metrics.Labels{"device": "sdb"}
+
+	counters.delta("DiskReads", sda, 10)
+	counters.delta("DiskReads", sdb, 10)
+	counters.forget()
+
+	// sdb is detached
+	_, ok := counters.delta("DiskReads", sda, 20)
+	assert.True(t, ok)
+	counters.forget()
+	assert.Len(t, counters.last, 1)
+
+	_, ok = counters.delta("DiskReads", sdb, 30)
+	assert.False(t, ok)
+}
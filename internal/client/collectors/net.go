@@ -0,0 +1,45 @@
+package collectors
+
+import (
+	"context"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	psnet "github.com/shirou/gopsutil/v3/net"
+)
+
+const netName = "net"
+
+// NetCollector reports the byte, packet, error and drop counters of the network interfaces.
+type NetCollector struct {
+	counters *monotonic
+}
+
+func NewNetCollector() *NetCollector {
+	return &NetCollector{counters: newMonotonic()}
+}
+
+func (c *NetCollector) Name() string {
+	return netName
+}
+
+func (c *NetCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	counters, err := psnet.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	defer c.counters.forget()
+
+	var list []metrics.Metrics
+	for _, stat := range counters {
+		labels := metrics.Labels{"interface": stat.Name}
+		list = c.counters.appendDelta(list, "NetBytesSent", labels, stat.BytesSent)
+		list = c.counters.appendDelta(list, "NetBytesRecv", labels, stat.BytesRecv)
+		list = c.counters.appendDelta(list, "NetPacketsSent", labels, stat.PacketsSent)
+		list = c.counters.appendDelta(list, "NetPacketsRecv", labels, stat.PacketsRecv)
+		list = c.counters.appendDelta(list, "NetErrorsIn", labels, stat.Errin)
+		list = c.counters.appendDelta(list, "NetErrorsOut", labels, stat.Errout)
+		list = c.counters.appendDelta(list, "NetDropsIn", labels, stat.Dropin)
+		list = c.counters.appendDelta(list, "NetDropsOut", labels, stat.Dropout)
+	}
+	return list, nil
+}
@@ -0,0 +1,41 @@
+package collectors
+
+import (
+	"context"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/shirou/gopsutil/v3/load"
+)
+
+const processName = "process"
+
+// ProcessCollector reports the number of processes by state, the created processes
+// and the context switches.
+type ProcessCollector struct {
+	counters *monotonic
+}
+
+func NewProcessCollector() *ProcessCollector {
+	return &ProcessCollector{counters: newMonotonic()}
+}
+
+func (c *ProcessCollector) Name() string {
+	return processName
+}
+
+func (c *ProcessCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	misc, err := load.MiscWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.counters.forget()
+
+	list := []metrics.Metrics{
+		gauge("ProcessesTotal", float64(misc.ProcsTotal)),
+		gauge("ProcessesRunning", float64(misc.ProcsRunning)),
+		gauge("ProcessesBlocked", float64(misc.ProcsBlocked)),
+	}
+	list = c.counters.appendDelta(list, "ProcessesCreated", nil, uint64(misc.ProcsCreated))
+	list = c.counters.appendDelta(list, "ContextSwitches", nil, uint64(misc.Ctxt))
+	return list, nil
+}
@@ -0,0 +1,28 @@
+package collectors
+
+import (
+	"context"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+const randomName = "random"
+
+// RandomCollector reports a changing RandomValue gauge and counts its polls in PollCount.
+type RandomCollector struct{}
+
+func NewRandomCollector() *RandomCollector {
+	return &RandomCollector{}
+}
+
+func (c *RandomCollector) Name() string {
+	return randomName
+}
+
+func (c *RandomCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	return []metrics.Metrics{
+		gauge("RandomValue", float64(time.Now().UnixNano())/float64(time.Second)),
+		counter("PollCount", 1),
+	}, nil
+}
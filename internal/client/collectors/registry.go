@@ -0,0 +1,134 @@
+package collectors
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/pkg/logging"
+	"go.uber.org/zap"
+)
+
+type entry struct {
+	collector Collector
+	interval  time.Duration
+	timeout   time.Duration // ограничение времени одного опроса, 0 - без ограничения
+	running   atomic.Bool   // предыдущий опрос еще не завершился
+}
+
+type result struct {
+	metrics []metrics.Metrics
+	err     error
+}
+
+// Registry polls every registered collector in its own goroutine with its own interval,
+// a failing, slow or panicking collector does not delay the others.
+type Registry struct {
+	logger  *zap.Logger
+	entries []*entry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{logger: logging.GetLogger()}
+}
+
+// Register adds the collector polled every interval, a poll longer than timeout is abandoned.
+func (r *Registry) Register(c Collector, interval, timeout time.Duration) error {
+	for _, e := range r.entries {
+		if e.collector.Name() == c.Name() {
+			return fmt.Errorf("collector %q is already registered", c.Name())
+		}
+	}
+	r.entries = append(r.entries, &entry{collector: c, interval: interval, timeout: timeout})
+	return nil
+}
+
+// Names returns the names of the registered collectors in the order of registration.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.entries))
+	for _, e := range r.entries {
+		names = append(names, e.collector.Name())
+	}
+	return names
+}
+
+// Run polls the collectors and passes the metrics to the sink until ctx is done.
+func (r *Registry) Run(ctx context.Context, sink Sink) {
+	var wg sync.WaitGroup
+	for _, e := range r.entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.poll(ctx, e, sink)
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *Registry) poll(ctx context.Context, e *entry, sink Sink) {
+	for {
+		r.collect(ctx, e, sink)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// collect runs a single poll of the collector. The metrics returned along with an error are kept.
+// The poll which outlives the timeout is abandoned and the collector is skipped until it returns,
+// the metrics of the abandoned poll are stored when it returns, so the counter deltas are not lost.
+func (r *Registry) collect(ctx context.Context, e *entry, sink Sink) {
+	logger := r.logger.With(zap.String("collector", e.collector.Name()))
+	if !e.running.CompareAndSwap(false, true) {
+		logger.Warn("collector is still running, poll skipped")
+		return
+	}
+
+	pollCtx, cancel := ctx, context.CancelFunc(func() {})
+	if e.timeout > 0 {
+		pollCtx, cancel = context.WithTimeout(ctx, e.timeout)
+	}
+	defer cancel()
+
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- result{err: fmt.Errorf("collector panic: %v", p)}
+			}
+		}()
+		list, err := e.collector.Collect(pollCtx)
+		done <- result{metrics: list, err: err}
+	}()
+
+	select {
+	case <-pollCtx.Done():
+		logger.Warn("collector poll abandoned", zap.Error(pollCtx.Err()))
+		go func() {
+			defer e.running.Store(false)
+			r.store(ctx, logger, sink, <-done)
+		}()
+	case res := <-done:
+		defer e.running.Store(false)
+		r.store(ctx, logger, sink, res)
+	}
+}
+
+// store passes the metrics of the poll to the sink.
+func (r *Registry) store(ctx context.Context, logger *zap.Logger, sink Sink, res result) {
+	if res.err != nil {
+		logger.Error("collect metrics error", zap.Error(res.err))
+	}
+	if len(res.metrics) == 0 {
+		return
+	}
+	if err := sink.BulkAdd(ctx, res.metrics); err != nil {
+		logger.Error("store collected metrics error", zap.Error(err))
+	}
+}
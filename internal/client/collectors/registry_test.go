@@ -0,0 +1,125 @@
+package collectors
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCollector counts its polls in the counter named after the collector.
+type fakeCollector struct {
+	name  string
+	err   error
+	panic bool
+	block chan struct{} // опрос ждет закрытия канала или отмены контекста
+}
+
+func (c *fakeCollector) Name() string {
+	return c.name
+}
+
+func (c *fakeCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	if c.panic {
+		panic("collector is broken")
+	}
+	if c.block != nil {
+		<-c.block
+	}
+	return []metrics.Metrics{counter(c.name, 1)}, c.err
+}
+
+func polls(t *testing.T, sink *memory.CollectionMetricStorage, name string) int64 {
+	t.Helper()
+	m := metrics.Metrics{ID: name, MType: metrics.Counter}
+	if err := sink.Get(context.Background(), &m); err != nil {
+		return 0
+	}
+	return *m.Delta
+}
+
+func TestRegistryRegister(t *testing.T) {
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(NewRandomCollector(), time.Second, 0))
+	require.NoError(t, registry.Register(NewRuntimeCollector(), time.Second, 0))
+
+	assert.Error(t, registry.Register(NewRandomCollector(), time.Second, 0))
+	assert.Equal(t, []string{"random", "runtime"}, registry.Names())
+}
+
+func TestRegistryIntervals(t *testing.T) {
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(&fakeCollector{name: "fast"}, 10*time.Millisecond, 0))
+	require.NoError(t, registry.Register(&fakeCollector{name: "slow"}, time.Hour, 0))
+
+	sink := memory.NewCollectionMetricStorage()
+	ctx, cancel := context.WithTimeout(context.Background(), 105*time.Millisecond)
+	defer cancel()
+	registry.Run(ctx, sink)
+
+	fast := polls(t, sink, "fast")
+	assert.GreaterOrEqual(t, fast, int64(5))
+	assert.LessOrEqual(t, fast, int64(11))
+	assert.Equal(t, int64(1), polls(t, sink, "slow"), "the collector is polled at start")
+}
+
+// The failing, panicking and hanging collectors do not stop the others.
+func TestRegistryFailingCollectors(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(&fakeCollector{name: "healthy"}, 5*time.Millisecond, time.Second))
+	require.NoError(t, registry.Register(&fakeCollector{name: "failing", err: errors.New("no data")}, 5*time.Millisecond, time.Second))
+	require.NoError(t, registry.Register(&fakeCollector{name: "panicking", panic: true}, 5*time.Millisecond, time.Second))
+	require.NoError(t, registry.Register(&fakeCollector{name: "hanging", block: block}, 5*time.Millisecond, 10*time.Millisecond))
+
+	sink := memory.NewCollectionMetricStorage()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		registry.Run(ctx, sink)
+	}()
+
+	require.Eventually(t, func() bool { return polls(t, sink, "healthy") >= 5 }, time.Second, time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	assert.Greater(t, polls(t, sink, "failing"), int64(0), "the metrics returned with the error are kept")
+	assert.Zero(t, polls(t, sink, "panicking"))
+	assert.Zero(t, polls(t, sink, "hanging"))
+}
+
+// The metrics of the abandoned poll are stored when the collector returns.
+func TestRegistryAbandonedPoll(t *testing.T) {
+	block := make(chan struct{})
+
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(&fakeCollector{name: "hanging", block: block}, time.Hour, 10*time.Millisecond))
+
+	sink := memory.NewCollectionMetricStorage()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		registry.Run(ctx, sink)
+	}()
+
+	// the only poll before the next interval is abandoned
+	time.Sleep(30 * time.Millisecond)
+	assert.Zero(t, polls(t, sink, "hanging"))
+
+	close(block)
+	require.Eventually(t, func() bool { return polls(t, sink, "hanging") == 1 }, time.Second, time.Millisecond)
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,40 @@
+package collectors
+
+import (
+	"context"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+const swapName = "swap"
+
+// SwapCollector reports the swap usage and the amount of memory swapped in and out.
+type SwapCollector struct {
+	counters *monotonic
+}
+
+func NewSwapCollector() *SwapCollector {
+	return &SwapCollector{counters: newMonotonic()}
+}
+
+func (c *SwapCollector) Name() string {
+	return swapName
+}
+
+func (c *SwapCollector) Collect(ctx context.Context) ([]metrics.Metrics, error) {
+	swap, err := mem.SwapMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.counters.forget()
+
+	list := []metrics.Metrics{
+		gauge("SwapTotalBytes", float64(swap.Total)),
+		gauge("SwapUsedBytes", float64(swap.Used)),
+		gauge("SwapFreeBytes", float64(swap.Free)),
+	}
+	list = c.counters.appendDelta(list, "SwapInBytes", nil, swap.Sin)
+	list = c.counters.appendDelta(list, "SwapOutBytes", nil, swap.Sout)
+	return list, nil
+}
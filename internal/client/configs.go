@@ -26,7 +26,12 @@ type Server struct {
 	CompressRequest  bool            `arg:"-z,env:COMPRESS_REQUEST" default:"true" help:"compress body request"`
 	BackoffIntervals []time.Duration `arg:"--b-intervals,env:BACKOFF_INTERVALS" help:"Интервалы повтора запроса (default=1s,3s,5s)"`
 	BackoffRetries   bool            `arg:"--backoff,env:BACKOFF_RETRIES" default:"true" help:"Повтор запроса при разрыве соединения"`
-	HashBodyKey      string          `arg:"-k,env:KEY" default:"" help:"hash key"`
+	HashBodyKey      string          `arg:"-k,env:KEY" default:"" help:"HMAC-SHA256 signing keys separated by commas, the first is the current one"`
+	GRPCTLS          bool            `arg:"--grpc-tls,env:GRPC_TLS" default:"false" help:"Подключение к серверу gRPC по TLS" json:"grpc_tls"`
+	GRPCTLSCA        string          `arg:"--grpc-tls-ca,env:GRPC_TLS_CA" default:"" help:"Путь к сертификату CA сервера gRPC (пусто - системные сертификаты)" json:"grpc_tls_ca"`
+	GRPCTLSCert      string          `arg:"--grpc-tls-cert,env:GRPC_TLS_CERT" default:"" help:"Путь к сертификату клиента для mTLS" json:"grpc_tls_cert"`
+	GRPCTLSKey       string          `arg:"--grpc-tls-key,env:GRPC_TLS_KEY" default:"" help:"Путь к закрытому ключу сертификата клиента для mTLS" json:"grpc_tls_key"`
+	RequestTimeout   int             `arg:"--request-timeout,env:REQUEST_TIMEOUT" default:"10" help:"Таймаут запроса к серверу в секундах" json:"request_timeout"`
 	CryptoKey        CryptoPublicKey `arg:"--crypto-key,env:CRYPTO_KEY" default:"" help:"the path to the file with the public key" josn:"crypto_key"`
 }
 
@@ -59,11 +64,20 @@ func (cpk *CryptoPublicKey) UnmarshalText(b []byte) error {
 }
 
 type Client struct {
-	RateLimit      uint   `arg:"-l,env:RATE_LIMIT" default:"1" help:"the number of simultaneous outgoing requests to the server"`
-	ReportInterval int    `arg:"-r,env:REPORT_INTERVAL" default:"10" help:"the frequency of sending metrics to the server" json:"report_interval"`
-	PollInterval   int    `arg:"-p,env:POLL_INTERVAL" default:"2" help:"the frequency of polling metrics from the runtime package" json:"poll_interval"`
-	LogLevel       string `arg:"--ll,env:LOG_LEVEL" default:"INFO" help:"log level"`
-	GRPCClient     bool   `arg:"--grpc,env:GRPC_CLIENT" default:"false" help:"If the flag is set, the client uses grpc" json:"grpc_client"`
+	RateLimit          uint           `arg:"-l,env:RATE_LIMIT" default:"1" help:"the maximum number of simultaneous outgoing requests to the server"`
+	QueueSize          int            `arg:"--queue-size,env:QUEUE_SIZE" default:"10" help:"the number of batches waiting to be sent, a batch is dropped when the queue is full" json:"queue_size"`
+	MaxBatchSize       int            `arg:"--max-batch-size,env:MAX_BATCH_SIZE" default:"100" help:"the maximum number of metrics in a single request (0 - unlimited)" json:"max_batch_size"`
+	SpoolDir           string         `arg:"--spool-dir,env:SPOOL_DIR" default:"" help:"the directory where the undelivered batches are kept until the server is reachable (empty - disabled)" json:"spool_dir"`
+	SpoolMaxSize       int            `arg:"--spool-max-size,env:SPOOL_MAX_SIZE" default:"64" help:"the maximum size of the spool in megabytes, the oldest batches are dropped" json:"spool_max_size"`
+	SpoolMaxAge        int            `arg:"--spool-max-age,env:SPOOL_MAX_AGE" default:"3600" help:"the maximum age of the spooled batches in seconds (0 - unlimited), keep it within the idempotency TTL of the server" json:"spool_max_age"`
+	ReportInterval     int            `arg:"-r,env:REPORT_INTERVAL" default:"10" help:"the frequency of sending metrics to the server" json:"report_interval"`
+	PollInterval       int            `arg:"-p,env:POLL_INTERVAL" default:"2" help:"the frequency of polling the collectors" json:"poll_interval"`
+	Collectors         []string       `arg:"--collectors,env:COLLECTORS" help:"the enabled collectors: random, runtime, gopsutil, disk, net, load, swap, process (default=random,runtime,gopsutil)" json:"collectors"`
+	CollectorIntervals map[string]int `arg:"--collector-intervals,env:COLLECTOR_INTERVALS" help:"the poll intervals of the collectors in seconds, e.g. runtime=5,gopsutil=10 (default - poll interval)" json:"collector_intervals"`
+	CollectorTimeouts  map[string]int `arg:"--collector-timeouts,env:COLLECTOR_TIMEOUTS" help:"the timeouts of a single poll of the collectors in seconds (default - the poll interval of the collector)" json:"collector_timeouts"`
+	LogLevel           string         `arg:"--ll,env:LOG_LEVEL" default:"INFO" help:"log level"`
+	GRPCClient         bool           `arg:"--grpc,env:GRPC_CLIENT" default:"false" help:"If the flag is set, the client uses grpc" json:"grpc_client"`
+	GRPCStream         bool           `arg:"--grpc-stream,env:GRPC_STREAM" default:"false" help:"If the flag is set, the grpc client sends metrics over a single long-lived stream" json:"grpc_stream"`
 }
 type Config struct {
 	Server
@@ -115,5 +129,9 @@ func NewConfig() (*Config, error) {
 		cfg.Server.BackoffIntervals = nil
 	}
 
+	if cfg.Client.Collectors == nil {
+		cfg.Client.Collectors = []string{"random", "runtime", "gopsutil"}
+	}
+
 	return &cfg, nil
 }
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// grpcCredentials returns the transport credentials of the connection to the gRPC server:
+// plaintext unless TLS is enabled, the client certificate is presented for mTLS when set.
+func grpcCredentials(cfg *Config) (credentials.TransportCredentials, error) {
+	if !cfg.GRPCTLS {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.GRPCTLSCA != "" {
+		data, err := os.ReadFile(cfg.GRPCTLSCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.GRPCTLSCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.GRPCTLSCert != "" || cfg.GRPCTLSKey != "" {
+		if cfg.GRPCTLSCert == "" || cfg.GRPCTLSKey == "" {
+			return nil, errors.New("both the client certificate and its key must be set")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.GRPCTLSCert, cfg.GRPCTLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSignedCert writes a self-signed certificate and its key, returns the file paths.
+func writeSelfSignedCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "agent.crt"), filepath.Join(dir, "agent.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestGRPCCredentials(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+
+	tests := []struct {
+		name     string
+		server   Server
+		protocol string
+		wantErr  bool
+	}{
+		{"plaintext", Server{}, "insecure", false},
+		{"tls with system roots", Server{GRPCTLS: true}, "tls", false},
+		{"mtls", Server{GRPCTLS: true, GRPCTLSCA: certFile, GRPCTLSCert: certFile, GRPCTLSKey: keyFile}, "tls", false},
+		{"certificate without key", Server{GRPCTLS: true, GRPCTLSCert: certFile}, "", true},
+		{"missing CA", Server{GRPCTLS: true, GRPCTLSCA: "/not/exists"}, "", true},
+		{"CA is not a certificate", Server{GRPCTLS: true, GRPCTLSCA: keyFile}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := grpcCredentials(&Config{Server: tt.server})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.protocol, creds.Info().SecurityProtocol)
+		})
+	}
+}
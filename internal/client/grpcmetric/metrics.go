@@ -2,9 +2,12 @@ package grpcmetric
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
-	pb "github.com/screamsoul/go-metrics-tpl/internal/proto"
+	pbv2 "github.com/screamsoul/go-metrics-tpl/internal/proto/v2"
+	"github.com/screamsoul/go-metrics-tpl/pkg/idempotency"
 	"github.com/screamsoul/go-metrics-tpl/pkg/logging"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
@@ -12,8 +15,15 @@ import (
 
 type GRPCMetricsClient struct {
 	logger *zap.Logger
-	mc     pb.MetricsServiceClient
+	mc     pbv2.MetricsServiceClient
 	conn   *grpc.ClientConn
+
+	streaming bool // отправка метрик через долгоживущий поток StreamMetrics
+
+	mu           sync.Mutex
+	stream       pbv2.MetricsService_StreamMetricsClient
+	cancelStream context.CancelFunc
+	sent         int64 // количество метрик, отправленных в текущий поток
 }
 
 func NewGRPCMetricsClient(
@@ -22,34 +32,128 @@ func NewGRPCMetricsClient(
 	logger := logging.GetLogger()
 
 	client := &GRPCMetricsClient{
-		logger,
-		pb.NewMetricsServiceClient(conn),
-		conn,
+		logger: logger,
+		mc:     pbv2.NewMetricsServiceClient(conn),
+		conn:   conn,
 	}
 	return client
 }
 
+// NewGRPCStreamMetricsClient creates the client which pushes metrics over a single
+// long-lived StreamMetrics stream, the stream is reopened after an error.
+func NewGRPCStreamMetricsClient(
+	conn *grpc.ClientConn,
+) *GRPCMetricsClient {
+	client := NewGRPCMetricsClient(conn)
+	client.streaming = true
+	return client
+}
+
+// metricToProto converts the metric into the v2 message, the value field is chosen by the metric type.
+func metricToProto(m metrics.Metrics) (*pbv2.Metric, error) {
+	pm := &pbv2.Metric{
+		Name:   m.ID,
+		Labels: m.Labels,
+	}
+
+	switch {
+	case m.MType == metrics.Gauge && m.Value != nil:
+		pm.Data = &pbv2.Metric_Value{Value: *m.Value}
+	case m.MType == metrics.Counter && m.Delta != nil:
+		pm.Data = &pbv2.Metric_Delta{Delta: *m.Delta}
+	case m.MType == metrics.Histogram && m.Histogram != nil:
+		pm.Data = &pbv2.Metric_Histogram{Histogram: &pbv2.Histogram{
+			Bounds: m.Histogram.Bounds,
+			Counts: m.Histogram.Counts,
+			Sum:    m.Histogram.Sum,
+			Count:  m.Histogram.Count,
+		}}
+	default:
+		return nil, fmt.Errorf("metric %s of type `%s` has no value", m.ID, m.MType)
+	}
+	return pm, nil
+}
+
+// SendMetric sends the metrics with the idempotency key of the batch set in ctx.
 func (client *GRPCMetricsClient) SendMetric(ctx context.Context, metricsList []metrics.Metrics) error {
-	var mr pb.MetricsRequest
+	mr := pbv2.MetricsRequest{IdempotencyKey: idempotency.FromContext(ctx)}
 	for _, m := range metricsList {
-		var mType pb.Metric_MType
-
-		switch m.MType {
-		case metrics.Gauge:
-			mType = pb.Metric_GAUGE
-		case metrics.Counter:
-			mType = pb.Metric_COUNTER
+		pm, err := metricToProto(m)
+		if err != nil {
+			return err
 		}
+		mr.Metrics = append(mr.Metrics, pm)
+	}
 
-		mr.Metrics = append(mr.Metrics, &pb.Metric{
-			Name:  m.ID,
-			Delta: *m.Delta,
-			Value: *m.Value,
-			MType: mType,
-		})
+	if client.streaming {
+		return client.sendStream(ctx, &mr)
 	}
 
 	_, err := client.mc.UpdateMetrics(ctx, &mr)
 
 	return err
 }
+
+// sendStream pushes the request into the stream and waits for its acknowledgement.
+// The requests of concurrent senders are serialized, so every ack matches its request.
+func (client *GRPCMetricsClient) sendStream(ctx context.Context, mr *pbv2.MetricsRequest) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.stream == nil {
+		// the stream outlives the call, it is bound to the call context only while the call lasts
+		streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		stream, err := client.mc.StreamMetrics(streamCtx)
+		if err != nil {
+			cancel()
+			return err
+		}
+		client.stream, client.cancelStream, client.sent = stream, cancel, 0
+	}
+
+	stop := context.AfterFunc(ctx, client.cancelStream)
+	defer stop()
+
+	expected := client.sent + int64(len(mr.GetMetrics()))
+	err := client.stream.Send(mr)
+	if err == nil {
+		var ack *pbv2.StreamMetricsAck
+		ack, err = client.stream.Recv()
+		if err == nil && ack.GetAccepted() != expected {
+			err = fmt.Errorf("stream ack mismatch: accepted %d, sent %d", ack.GetAccepted(), expected)
+		}
+	}
+	if err != nil {
+		client.resetStream()
+		return err
+	}
+
+	client.sent = expected
+	return nil
+}
+
+// resetStream drops the broken stream, the next call opens a new one.
+func (client *GRPCMetricsClient) resetStream() {
+	if client.stream == nil {
+		return
+	}
+	client.cancelStream()
+	client.stream, client.cancelStream = nil, nil
+}
+
+// Close closes the stream of the streaming client.
+func (client *GRPCMetricsClient) Close() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.stream == nil {
+		return nil
+	}
+	err := client.stream.CloseSend()
+	if err == nil {
+		// wait for the server to finish the stream
+		_, _ = client.stream.Recv()
+	}
+	client.resetStream()
+	return err
+}
@@ -2,34 +2,64 @@ package grpcmetric_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/screamsoul/go-metrics-tpl/internal/client/grpcmetric"
+	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/grpctest"
+	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/services"
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
-	pb "github.com/screamsoul/go-metrics-tpl/internal/proto"
+	pbv2 "github.com/screamsoul/go-metrics-tpl/internal/proto/v2"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/screamsoul/go-metrics-tpl/pkg/idempotency"
 	"github.com/screamsoul/go-metrics-tpl/pkg/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 type mockMetricsServer struct {
-	pb.UnimplementedMetricsServiceServer
+	pbv2.UnimplementedMetricsServiceServer
 }
 
-func (m *mockMetricsServer) UpdateMetrics(ctx context.Context, req *pb.MetricsRequest) (*emptypb.Empty, error) {
+func (m *mockMetricsServer) StreamMetrics(stream pbv2.MetricsService_StreamMetricsServer) error {
+	var accepted int64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(req.GetMetrics()) > 0 && req.GetMetrics()[0].GetName() == "broken" {
+			return status.Error(codes.DataLoss, "broken metric")
+		}
+		accepted += int64(len(req.GetMetrics()))
+		if err := stream.Send(&pbv2.StreamMetricsAck{Accepted: accepted}); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *mockMetricsServer) UpdateMetrics(ctx context.Context, req *pbv2.MetricsRequest) (*emptypb.Empty, error) {
 	return &emptypb.Empty{}, nil
 }
 
 func dialer() func(context.Context, string) (net.Conn, error) {
 	listener := bufconn.Listen(1024 * 1024)
 	server := grpc.NewServer()
-	pb.RegisterMetricsServiceServer(server, &mockMetricsServer{})
+	pbv2.RegisterMetricsServiceServer(server, &mockMetricsServer{})
 	go func() {
 		if err := server.Serve(listener); err != nil {
 			log.Fatal(err)
@@ -68,9 +98,107 @@ func TestGRPCMetricsClient_SendMetric(t *testing.T) {
 			Value: new(float64),
 			MType: metrics.Counter,
 		},
+		{
+			ID:        "metric3",
+			Histogram: metrics.NewHistogramValue(0.1, 1),
+			MType:     metrics.Histogram,
+		},
 	}
 
 	err = mc.SendMetric(context.Background(), metricsList)
 
 	assert.NoError(t, err)
 }
+
+func TestGRPCMetricsClient_SendMetricStream(t *testing.T) {
+	conn, err := grpc.NewClient(
+		"localhost",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(dialer()),
+	)
+	require.NoError(t, err)
+	defer utils.CloseForse(conn)
+
+	mc := grpcmetric.NewGRPCStreamMetricsClient(conn)
+	defer utils.CloseForse(mc)
+
+	delta := int64(1)
+	batch := []metrics.Metrics{
+		{ID: "PollCount", MType: metrics.Counter, Delta: &delta},
+		{ID: "metric3", MType: metrics.Histogram, Histogram: metrics.NewHistogramValue(0.1, 1)},
+	}
+
+	// acks are counted from the start of the stream
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, mc.SendMetric(context.Background(), batch))
+	}
+
+	// the failed stream is dropped and the next call opens a new one
+	err = mc.SendMetric(context.Background(), []metrics.Metrics{{ID: "broken", MType: metrics.Counter, Delta: &delta}})
+	assert.Equal(t, codes.DataLoss, status.Code(err))
+
+	assert.NoError(t, mc.SendMetric(context.Background(), batch))
+	assert.NoError(t, mc.Close())
+}
+
+func TestGRPCMetricsClient_SendMetricWithoutValue(t *testing.T) {
+	conn, err := grpc.NewClient(
+		"localhost",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(dialer()),
+	)
+	require.NoError(t, err)
+	defer utils.CloseForse(conn)
+
+	mc := grpcmetric.NewGRPCMetricsClient(conn)
+
+	err = mc.SendMetric(context.Background(), []metrics.Metrics{{ID: "Alloc", MType: metrics.Gauge}})
+	assert.Error(t, err)
+}
+
+func TestGRPCMetricsClient_RoundTrip(t *testing.T) {
+	store := memory.NewMemStorage()
+	store.EnableIdempotency(time.Minute, 100)
+	conn := grpctest.Serve(t, func(s *grpc.Server) {
+		pbv2.RegisterMetricsServiceServer(s, services.NewMetricServerV2(services.NewMetricServer(store)))
+	})
+
+	// the batch is larger than the server chunk and mixes gauges and counters
+	var batch []metrics.Metrics
+	for i := 0; i < 150; i++ {
+		value := float64(i) + 0.5
+		delta := int64(i)
+		batch = append(batch,
+			metrics.Metrics{ID: fmt.Sprintf("gauge%d", i), MType: metrics.Gauge, Value: &value},
+			metrics.Metrics{ID: fmt.Sprintf("counter%d", i), MType: metrics.Counter, Delta: &delta},
+		)
+	}
+
+	clients := map[string]*grpcmetric.GRPCMetricsClient{
+		"unary":  grpcmetric.NewGRPCMetricsClient(conn),
+		"stream": grpcmetric.NewGRPCStreamMetricsClient(conn),
+	}
+	for _, name := range []string{"unary", "stream"} {
+		// the batch is retried with the same idempotency key and applied once
+		batchCtx := idempotency.WithKey(context.Background(), idempotency.NewKey())
+		for i := 0; i < 2; i++ {
+			require.NoError(t, clients[name].SendMetric(batchCtx, batch), name)
+		}
+	}
+	require.NoError(t, clients["stream"].Close())
+
+	list, err := store.List(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, list, 300)
+
+	for i := 0; i < 150; i++ {
+		gauge := metrics.Metrics{ID: fmt.Sprintf("gauge%d", i), MType: metrics.Gauge}
+		require.NoError(t, store.Get(context.Background(), &gauge))
+		assert.Equal(t, float64(i)+0.5, *gauge.Value)
+
+		// counters are sent twice, by the unary and the stream client
+		counter := metrics.Metrics{ID: fmt.Sprintf("counter%d", i), MType: metrics.Counter}
+		require.NoError(t, store.Get(context.Background(), &counter))
+		assert.Equal(t, int64(2*i), *counter.Delta)
+	}
+}
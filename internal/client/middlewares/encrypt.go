@@ -1,25 +1,28 @@
 package middlewares
 
 import (
-	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/screamsoul/go-metrics-tpl/pkg/envelope"
 )
 
+// NewEncryptMiddleware seals the body into the hybrid encryption envelope for the server key
+// and marks the request with the envelope header.
 func NewEncryptMiddleware(publicKey *rsa.PublicKey) func(c *resty.Client, r *resty.Request) error {
 	return func(c *resty.Client, r *resty.Request) error {
 		bodyBytes, ok := r.Body.([]byte)
 		if !ok {
 			return fmt.Errorf("body is not of type []byte")
 		}
-		ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, bodyBytes)
+		ciphertext, err := envelope.Seal(publicKey, bodyBytes)
 		if err != nil {
 			return fmt.Errorf("fail encrypt massage; %w", err)
 		}
 
 		r.SetBody(ciphertext)
+		r.SetHeader(envelope.Header, envelope.Scheme)
 		return nil
 	}
 }
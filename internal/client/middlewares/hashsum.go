@@ -1,27 +1,49 @@
 package middlewares
 
 import (
-	"crypto/sha256"
 	"fmt"
+	"net/url"
+	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/screamsoul/go-metrics-tpl/pkg/signature"
 )
 
-func NewHashSumHeaderMiddleware(hashKey string) func(c *resty.Client, r *resty.Request) error {
+// NewHashSumHeaderMiddleware signs the request with the current key, a new timestamp and nonce,
+// see package signature for the scheme. It must be the last hook, so the body is signed
+// as it is transmitted. The request URL must be absolute and carry the whole query.
+func NewHashSumHeaderMiddleware(keys signature.Keys) func(c *resty.Client, r *resty.Request) error {
 	return func(c *resty.Client, r *resty.Request) error {
 		bodyBytes, ok := r.Body.([]byte)
 		if !ok {
 			return fmt.Errorf("body is not of type []byte")
 		}
-		h := sha256.New()
-
-		h.Write(bodyBytes)
-		h.Write([]byte(hashKey))
+		target, err := url.Parse(r.URL)
+		if err != nil {
+			return err
+		}
 
-		dst := h.Sum(nil)
+		timestamp, nonce := signature.FormatTimestamp(time.Now()), signature.NewNonce()
+		parts := signature.HTTPRequestParts(r.Method, target.RequestURI(), timestamp, nonce, bodyBytes)
+		r.Header.Set(signature.TimestampHeader, timestamp)
+		r.Header.Set(signature.NonceHeader, nonce)
+		r.Header.Set(signature.Header, keys.Sign(parts...))
 
-		r.Header.Set("HashSHA256", string(dst))
+		return nil
+	}
+}
 
+// NewVerifyHashSumMiddleware checks the signature of the response body made by the server,
+// unsigned responses are accepted.
+func NewVerifyHashSumMiddleware(keys signature.Keys) func(c *resty.Client, r *resty.Response) error {
+	return func(c *resty.Client, r *resty.Response) error {
+		bodyHash := r.Header().Get(signature.Header)
+		if bodyHash == "" {
+			return nil
+		}
+		if !keys.Verify(bodyHash, r.Body()) {
+			return fmt.Errorf("response signature is not valid")
+		}
 		return nil
 	}
 }
@@ -5,12 +5,16 @@ import (
 	"compress/gzip"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/screamsoul/go-metrics-tpl/internal/client/middlewares"
+	"github.com/screamsoul/go-metrics-tpl/pkg/envelope"
+	"github.com/screamsoul/go-metrics-tpl/pkg/signature"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,19 +37,59 @@ func TestNewGzipCompressBodyMiddleware_CompressesValidBody(t *testing.T) {
 }
 
 func TestCorrectlySetsHashSHA256Header(t *testing.T) {
-	hashKey := "testKey"
 	body := []byte("testBody")
-	expectedHash := sha256.New()
-	expectedHash.Write(body)
-	expectedHash.Write([]byte(hashKey))
-	expectedHashSum := expectedHash.Sum(nil)
 
-	middleware := middlewares.NewHashSumHeaderMiddleware(hashKey)
+	middleware := middlewares.NewHashSumHeaderMiddleware(signature.Keys{"testKey", "oldKey"})
 	req := resty.New().R().SetBody(body)
+	req.Method, req.URL = "POST", "http://localhost:8080/updates/?host=a"
 
 	err := middleware(nil, req)
 	assert.NoError(t, err)
-	assert.Equal(t, string(expectedHashSum), req.Header.Get("HashSHA256"))
+
+	timestamp, nonce := req.Header.Get("X-Timestamp"), req.Header.Get("X-Nonce")
+	assert.WithinDuration(t, time.Now(), signature.ParseTimestamp(timestamp), time.Minute)
+	assert.NotEmpty(t, nonce)
+	assert.Equal(t,
+		signature.Sign("testKey", []byte("POST /updates/?host=a\n"+timestamp+"\n"+nonce+"\n"), body),
+		req.Header.Get("HashSHA256"),
+	)
+
+	// every request gets a new nonce
+	next := resty.New().R().SetBody(body)
+	assert.NoError(t, middleware(nil, next))
+	assert.NotEqual(t, nonce, next.Header.Get("X-Nonce"))
+}
+
+func TestNewVerifyHashSumMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{"current key", signature.Sign("testKey", []byte("testBody")), false},
+		{"previous key", signature.Sign("oldKey", []byte("testBody")), false},
+		{"unknown key", signature.Sign("otherKey", []byte("testBody")), true},
+		{"not signed", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.header != "" {
+					w.Header().Set("HashSHA256", tt.header)
+				}
+				_, _ = w.Write([]byte("testBody"))
+			}))
+			defer server.Close()
+
+			client := resty.New().OnAfterResponse(middlewares.NewVerifyHashSumMiddleware(signature.Keys{"testKey", "oldKey"}))
+			_, err := client.R().Get(server.URL)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
 }
 
 func TestNewEncryptMiddleware(t *testing.T) {
@@ -54,7 +98,9 @@ func TestNewEncryptMiddleware(t *testing.T) {
 	publicKey := &privateKey.PublicKey
 	client := resty.New()
 	request := client.NewRequest()
-	request.Body = []byte("test message")
+	// the body is larger than a 2048-bit key can encrypt directly
+	body := bytes.Repeat([]byte("test message"), 1000)
+	request.Body = body
 
 	// Test middleware function
 	middleware := middlewares.NewEncryptMiddleware(publicKey)
@@ -66,12 +112,12 @@ func TestNewEncryptMiddleware(t *testing.T) {
 
 	// Assertions
 	encryptedBody := request.Body.([]byte)
-	if len(encryptedBody) == 0 {
-		t.Error("Request body was not encrypted")
-	}
+	assert.NotEqual(t, body, encryptedBody)
+	assert.Equal(t, envelope.Scheme, request.Header.Get(envelope.Header))
 
-	// Optional: Decrypt and verify the content
-	// This part requires handling the private key and might be omitted in unit tests focusing on integration
+	decrypted, err := envelope.NewKeyring(privateKey).Open(encryptedBody)
+	assert.NoError(t, err)
+	assert.Equal(t, body, decrypted)
 }
 
 func TestMiddlewareSetsXRealIPHeader(t *testing.T) {
@@ -4,72 +4,96 @@ import (
 	"context"
 	"crypto/rsa"
 	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/screamsoul/go-metrics-tpl/internal/client/middlewares"
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/pkg/idempotency"
 	"github.com/screamsoul/go-metrics-tpl/pkg/logging"
+	"github.com/screamsoul/go-metrics-tpl/pkg/signature"
 	"go.uber.org/zap"
 )
 
+// RestyMetricsClient sends the metrics in JSON over HTTP, the body is prepared by the request hooks
+// of the shared client: compressed, then encrypted, then signed.
 type RestyMetricsClient struct {
-	resty.Client
+	client    *resty.Client
 	logger    *zap.Logger
 	uploadURL string
 }
 
 func NewRestyMetricsClient(
 	compressRequest bool,
-	hashKey string,
+	hashKeys signature.Keys,
 	uploadURL string,
 	localIP string,
 	pubKey *rsa.PublicKey,
+	timeout time.Duration,
 ) *RestyMetricsClient {
 
 	client := &RestyMetricsClient{
-		*resty.New(),
-		logging.GetLogger(),
-		uploadURL,
+		client:    resty.New().SetTimeout(timeout),
+		logger:    logging.GetLogger(),
+		uploadURL: uploadURL,
 	}
 
 	if localIP != "" {
-		client.OnBeforeRequest(middlewares.NewRealIPHeaderMiddleware(localIP))
+		client.client.OnBeforeRequest(middlewares.NewRealIPHeaderMiddleware(localIP))
 	}
 
 	if compressRequest {
-		client.OnBeforeRequest(middlewares.NewGzipCompressBodyMiddleware())
+		client.client.OnBeforeRequest(middlewares.NewGzipCompressBodyMiddleware())
 	}
 
-	if hashKey != "" {
-		client.OnBeforeRequest(middlewares.NewHashSumHeaderMiddleware(hashKey))
+	if pubKey != nil {
+		client.client.OnBeforeRequest(middlewares.NewEncryptMiddleware(pubKey))
 	}
 
-	if pubKey != nil {
-		client.OnBeforeRequest(middlewares.NewEncryptMiddleware(pubKey))
+	// the body is signed as it is transmitted, after the compression and the encryption
+	if hashKeys.Enabled() {
+		client.client.OnBeforeRequest(middlewares.NewHashSumHeaderMiddleware(hashKeys))
+		client.client.OnAfterResponse(middlewares.NewVerifyHashSumMiddleware(hashKeys))
 	}
 
 	return client
 }
 
+// SendMetric posts the metrics with the idempotency key of the batch set in ctx,
+// a response with a non-2xx status is returned as *resty.ResponseError.
 func (client *RestyMetricsClient) SendMetric(ctx context.Context, metricsList []metrics.Metrics) error {
 	jsonData, err := json.Marshal(metricsList)
 	if err != nil {
 		return err
 	}
 
-	resp, err := resty.New().R().
+	req := client.client.R().
 		SetContext(ctx).
 		SetHeader("Content-Type", "application/json").
-		SetBody(jsonData).
-		Post(client.uploadURL)
+		SetBody(jsonData)
+	if key := idempotency.FromContext(ctx); key != "" {
+		req.SetHeader(idempotency.Header, key)
+	}
+
+	resp, err := req.Post(client.uploadURL)
 
 	if err != nil {
 		client.logger.Error("send error", zap.Error(err))
 		return err
 	}
 
+	if resp.IsError() {
+		err = &resty.ResponseError{
+			Response: resp,
+			Err:      fmt.Errorf("unexpected response status %s: %s", resp.Status(), resp.String()),
+		}
+		client.logger.Error("send error", zap.Error(err))
+		return err
+	}
+
 	client.logger.Info(
-		"send metric", zap.Any("metric", resp.Request.Body), zap.String("url", client.uploadURL),
+		"send metric", zap.Int("count", len(metricsList)), zap.String("url", client.uploadURL),
 	)
 	return nil
 }
@@ -3,14 +3,24 @@ package restymetric_test
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/go-resty/resty/v2"
+	"github.com/screamsoul/go-metrics-tpl/internal/client"
 	"github.com/screamsoul/go-metrics-tpl/internal/client/restymetric"
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/screamsoul/go-metrics-tpl/internal/server"
+	"github.com/screamsoul/go-metrics-tpl/pkg/idempotency"
+	"github.com/screamsoul/go-metrics-tpl/pkg/signature"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewRestyMetricsClient(t *testing.T) {
@@ -19,7 +29,7 @@ func TestNewRestyMetricsClient(t *testing.T) {
 	localIP := "192.168.1.1"
 	pubKey := &rsa.PublicKey{}
 
-	client := restymetric.NewRestyMetricsClient(true, hashKey, uploadURL, localIP, pubKey)
+	client := restymetric.NewRestyMetricsClient(true, signature.Keys{hashKey}, uploadURL, localIP, pubKey, time.Second)
 
 	assert.NotNil(t, client, "Client should not be nil")
 }
@@ -34,7 +44,7 @@ func TestSendMetric_Success(t *testing.T) {
 
 	// Create a MetricsClient instance
 	client := restymetric.NewRestyMetricsClient(
-		false, "", server.URL, "127.0.0.1", nil,
+		false, nil, server.URL, "127.0.0.1", nil, time.Second,
 	)
 
 	// Create a context
@@ -57,7 +67,7 @@ func TestSendMetric_Success(t *testing.T) {
 func TestSendMetricFail(t *testing.T) {
 	// Create a MetricsClient instance
 	client := restymetric.NewRestyMetricsClient(
-		false, "", "fakeurl", "127.0.0.1", nil,
+		false, nil, "fakeurl", "127.0.0.1", nil, time.Second,
 	)
 
 	// Create a sample metrics list
@@ -72,3 +82,146 @@ func TestSendMetricFail(t *testing.T) {
 		t.Fatalf("expected error, got nil")
 	}
 }
+
+// startServer runs the real HTTP API with the whole middleware chain over the memory storage.
+func startServer(t *testing.T, hashKey string, privateKey *rsa.PrivateKey) (*httptest.Server, *memory.MemStorage) {
+	t.Helper()
+
+	cfg := &server.Config{HashBodyKey: hashKey, ReplayWindow: 60, ReplayCacheSize: 100}
+	require.NoError(t, cfg.TrustedSubnetCIDR.UnmarshalText([]byte("127.0.0.0/8")))
+	if privateKey != nil {
+		cfg.CryptoKey = server.CryptoPublicKey{Key: privateKey, Keys: []*rsa.PrivateKey{privateKey}}
+	}
+
+	store := memory.NewMemStorage()
+	store.EnableIdempotency(time.Minute, 100)
+	ts := httptest.NewServer(server.NewRouter(cfg, store))
+	t.Cleanup(ts.Close)
+	return ts, store
+}
+
+func TestSendMetric_Pipeline(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		compress   bool
+		hashKeys   signature.Keys
+		realIP     string
+		serverHash string
+		pubKey     *rsa.PublicKey
+		serverKey  *rsa.PrivateKey
+		wantStatus int // 0 - метрики приняты
+	}{
+		{name: "plain", realIP: "127.0.0.1"},
+		{name: "compressed", compress: true, realIP: "127.0.0.1"},
+		{name: "compressed and signed", compress: true, hashKeys: signature.Keys{"secret"}, serverHash: "secret", realIP: "127.0.0.1"},
+		{name: "compressed, encrypted and signed", compress: true, hashKeys: signature.Keys{"secret"}, serverHash: "secret", realIP: "127.0.0.1", pubKey: &privateKey.PublicKey, serverKey: privateKey},
+		{name: "signed with an unknown key", hashKeys: signature.Keys{"old", "secret"}, serverHash: "secret", realIP: "127.0.0.1", wantStatus: http.StatusBadRequest},
+		{name: "not signed", compress: true, serverHash: "secret", realIP: "127.0.0.1", wantStatus: http.StatusUnauthorized},
+		{name: "encrypted for another key", compress: true, hashKeys: signature.Keys{"secret"}, serverHash: "secret", realIP: "127.0.0.1", pubKey: &otherKey.PublicKey, serverKey: privateKey, wantStatus: http.StatusBadRequest},
+		{name: "plaintext to the server with a key", compress: true, hashKeys: signature.Keys{"secret"}, serverHash: "secret", realIP: "127.0.0.1", serverKey: privateKey},
+		{name: "untrusted ip", compress: true, realIP: "10.0.0.1", wantStatus: http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, store := startServer(t, tc.serverHash, tc.serverKey)
+
+			mc := restymetric.NewRestyMetricsClient(tc.compress, tc.hashKeys, ts.URL+"/updates/", tc.realIP, tc.pubKey, time.Second)
+
+			value, delta := 1.5, int64(3)
+			metricsList := []metrics.Metrics{
+				{ID: "Alloc", MType: metrics.Gauge, Value: &value},
+				{ID: "PollCount", MType: metrics.Counter, Delta: &delta},
+			}
+			// the hooks prepare every request from scratch, the second send is not corrupted by the first
+			for i := 0; i < 2; i++ {
+				err := mc.SendMetric(context.Background(), metricsList)
+				if tc.wantStatus != 0 {
+					var respErr *resty.ResponseError
+					require.ErrorAs(t, err, &respErr)
+					assert.Equal(t, tc.wantStatus, respErr.Response.StatusCode())
+					assert.False(t, client.IsTemporaryNetworkError(err))
+					return
+				}
+				require.NoError(t, err)
+			}
+
+			counter := metrics.Metrics{ID: "PollCount", MType: metrics.Counter}
+			require.NoError(t, store.Get(context.Background(), &counter))
+			assert.Equal(t, int64(6), *counter.Delta)
+		})
+	}
+}
+
+func TestSendMetric_Idempotency(t *testing.T) {
+	ts, store := startServer(t, "secret", nil)
+	mc := restymetric.NewRestyMetricsClient(true, signature.Keys{"secret"}, ts.URL+"/updates/", "127.0.0.1", nil, time.Second)
+
+	delta := int64(3)
+	metricsList := []metrics.Metrics{{ID: "PollCount", MType: metrics.Counter, Delta: &delta}}
+
+	// the retry of the batch whose response was lost is not applied again
+	batchCtx := idempotency.WithKey(context.Background(), idempotency.NewKey())
+	require.NoError(t, mc.SendMetric(batchCtx, metricsList))
+	require.NoError(t, mc.SendMetric(batchCtx, metricsList))
+
+	counter := metrics.Metrics{ID: "PollCount", MType: metrics.Counter}
+	require.NoError(t, store.Get(context.Background(), &counter))
+	assert.Equal(t, int64(3), *counter.Delta)
+
+	require.NoError(t, mc.SendMetric(idempotency.WithKey(context.Background(), idempotency.NewKey()), metricsList))
+	require.NoError(t, store.Get(context.Background(), &counter))
+	assert.Equal(t, int64(6), *counter.Delta)
+}
+
+func TestSendMetric_HTTPErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		wantTemporary bool
+	}{
+		{
+			name:          "service unavailable",
+			handler:       func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) },
+			wantTemporary: true,
+		},
+		{
+			name:          "internal error",
+			handler:       func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+			wantTemporary: false,
+		},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+			},
+			wantTemporary: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(tc.handler)
+			defer ts.Close()
+
+			mc := restymetric.NewRestyMetricsClient(false, nil, ts.URL, "", nil, 100*time.Millisecond)
+			err := mc.SendMetric(context.Background(), []metrics.Metrics{{ID: "Alloc", MType: metrics.Gauge, Value: new(float64)}})
+			require.Error(t, err)
+			assert.Equal(t, tc.wantTemporary, client.IsTemporaryNetworkError(err))
+		})
+	}
+
+	// the server is gone
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	mc := restymetric.NewRestyMetricsClient(false, nil, ts.URL, "", nil, time.Second)
+	err := mc.SendMetric(context.Background(), nil)
+	assert.True(t, client.IsTemporaryNetworkError(err))
+	assert.False(t, errors.Is(err, context.Canceled))
+}
@@ -0,0 +1,234 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/pkg/replay"
+	"github.com/screamsoul/go-metrics-tpl/pkg/signature"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	HashMetadataKey      = "hashsha256"  // подпись вызова
+	TimestampMetadataKey = "x-timestamp" // время подписи вызова, unix секунды
+	NonceMetadataKey     = "x-nonce"     // уникальная строка вызова
+)
+
+// unaryPayload the signed data of a unary call, the request serialized deterministically
+// so the client and the server get the same bytes for messages with maps.
+func unaryPayload(req interface{}) ([]byte, error) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return nil, status.Error(codes.Internal, "request is not a protobuf message")
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+// signatureField the field of a stream message which carries the signature of the message.
+const signatureField = "signature"
+
+// streamPayload the signed data of a streaming call. The metadata is sent before the
+// first message, so only the method is signed there, every message is signed by messageSignature.
+func streamPayload(method string) []byte {
+	return []byte(method)
+}
+
+// messageParts the signed data of the seq-th message of the stream opened with the timestamp
+// and the nonce, so a message can not be moved into another stream or reordered.
+func messageParts(timestamp, nonce string, seq int64, payload []byte) [][]byte {
+	return append(signature.RequestParts(timestamp, nonce, payload), []byte(strconv.FormatInt(seq, 10)))
+}
+
+// messageSignature returns the signature field of the message and the message serialized
+// without it, ok is false when the message has no signature field.
+func messageSignature(msg proto.Message) (sig string, payload []byte, ok bool, err error) {
+	field := msg.ProtoReflect().Descriptor().Fields().ByName(signatureField)
+	if field == nil || field.Kind() != protoreflect.StringKind {
+		return "", nil, false, nil
+	}
+	unsigned := proto.Clone(msg)
+	unsigned.ProtoReflect().Clear(field)
+	payload, err = proto.MarshalOptions{Deterministic: true}.Marshal(unsigned)
+	return msg.ProtoReflect().Get(field).String(), payload, true, err
+}
+
+// verifiedStream checks the signature of every received message of the stream.
+type verifiedStream struct {
+	grpc.ServerStream
+	keys             signature.Keys
+	timestamp, nonce string
+	seq              int64 // количество полученных сообщений
+}
+
+func (s *verifiedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Error(codes.Internal, "message is not a protobuf message")
+	}
+	sig, payload, ok, err := messageSignature(msg)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !ok || sig == "" {
+		return status.Error(codes.Unauthenticated, "message signature is required")
+	}
+	s.seq++
+	if !s.keys.Verify(sig, messageParts(s.timestamp, s.nonce, s.seq, payload)...) {
+		return status.Error(codes.Unauthenticated, "the message is corrupted")
+	}
+	return nil
+}
+
+// signingStream signs every sent message of the stream with the current key.
+type signingStream struct {
+	grpc.ClientStream
+	keys             signature.Keys
+	timestamp, nonce string
+	seq              int64 // количество отправленных сообщений
+}
+
+func (s *signingStream) SendMsg(m interface{}) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return status.Error(codes.Internal, "message is not a protobuf message")
+	}
+	_, payload, ok, err := messageSignature(msg)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return s.ClientStream.SendMsg(m)
+	}
+
+	s.seq++
+	signed := proto.Clone(msg)
+	field := signed.ProtoReflect().Descriptor().Fields().ByName(signatureField)
+	sig := s.keys.Sign(messageParts(s.timestamp, s.nonce, s.seq, payload)...)
+	signed.ProtoReflect().Set(field, protoreflect.ValueOfString(sig))
+	return s.ClientStream.SendMsg(signed)
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// checkSignature verifies the signature of the call made over the timestamp, the nonce
+// and the payload, then checks the call is not replayed.
+func checkSignature(ctx context.Context, keys signature.Keys, guard *replay.Guard, payload []byte) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	sig := firstValue(md, HashMetadataKey)
+	if sig == "" {
+		return status.Error(codes.Unauthenticated, "signature is required")
+	}
+	timestamp, nonce := firstValue(md, TimestampMetadataKey), firstValue(md, NonceMetadataKey)
+	if !keys.Verify(sig, signature.RequestParts(timestamp, nonce, payload)...) {
+		return status.Error(codes.Unauthenticated, "the data is corrupted")
+	}
+
+	if guard == nil {
+		return nil
+	}
+	err := guard.Check(signature.ParseTimestamp(timestamp), nonce)
+	if errors.Is(err, replay.ErrCacheFull) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+// signContext adds the signature of the payload with a new timestamp and nonce to the outgoing metadata.
+func signContext(ctx context.Context, keys signature.Keys, payload []byte) (signed context.Context, timestamp, nonce string) {
+	timestamp, nonce = signature.FormatTimestamp(time.Now()), signature.NewNonce()
+	signed = metadata.AppendToOutgoingContext(ctx,
+		TimestampMetadataKey, timestamp,
+		NonceMetadataKey, nonce,
+		HashMetadataKey, keys.Sign(signature.RequestParts(timestamp, nonce, payload)...),
+	)
+	return signed, timestamp, nonce
+}
+
+// NewHashSumMiddleware verifies the HMAC-SHA256 signature of the serialized request against
+// the active keys. Calls without the signature are rejected when the keys are set,
+// replayed calls are rejected when the guard is set.
+func NewHashSumMiddleware(keys signature.Keys, guard *replay.Guard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !keys.Enabled() {
+			return handler(ctx, req)
+		}
+		payload, err := unaryPayload(req)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSignature(ctx, keys, guard, payload); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// NewHashSumStreamMiddleware verifies the HMAC-SHA256 signature of the streaming call and
+// of every received message. A message is signed over the timestamp and the nonce of the call,
+// its number in the stream and its serialization without the signature field.
+func NewHashSumStreamMiddleware(keys signature.Keys, guard *replay.Guard) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !keys.Enabled() {
+			return handler(srv, ss)
+		}
+		if err := checkSignature(ss.Context(), keys, guard, streamPayload(info.FullMethod)); err != nil {
+			return err
+		}
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		return handler(srv, &verifiedStream{
+			ServerStream: ss,
+			keys:         keys,
+			timestamp:    firstValue(md, TimestampMetadataKey),
+			nonce:        firstValue(md, NonceMetadataKey),
+		})
+	}
+}
+
+// NewHashSumClientInterceptor signs the serialized request of unary calls with the current key.
+func NewHashSumClientInterceptor(keys signature.Keys) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !keys.Enabled() {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		payload, err := unaryPayload(req)
+		if err != nil {
+			return err
+		}
+		ctx, _, _ = signContext(ctx, keys, payload)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// NewHashSumStreamClientInterceptor signs streaming calls and every sent message.
+func NewHashSumStreamClientInterceptor(keys signature.Keys) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !keys.Enabled() {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		ctx, timestamp, nonce := signContext(ctx, keys, streamPayload(method))
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &signingStream{ClientStream: stream, keys: keys, timestamp: timestamp, nonce: nonce}, nil
+	}
+}
@@ -0,0 +1,254 @@
+package interceptors_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/interceptors"
+	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/services"
+	pbv2 "github.com/screamsoul/go-metrics-tpl/internal/proto/v2"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/screamsoul/go-metrics-tpl/pkg/replay"
+	"github.com/screamsoul/go-metrics-tpl/pkg/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// signedClient serves the ingestion API verifying signatures with the server key
+// and returns the client signing calls with the client key.
+func signedClient(t *testing.T, serverKey, clientKey string) pbv2.MetricsServiceClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptors.NewHashSumMiddleware(signature.ParseKeys(serverKey), replay.NewGuard(time.Minute, 100))),
+		grpc.StreamInterceptor(interceptors.NewHashSumStreamMiddleware(signature.ParseKeys(serverKey), replay.NewGuard(time.Minute, 100))),
+	)
+	pbv2.RegisterMetricsServiceServer(server, services.NewMetricServerV2(services.NewMetricServer(memory.NewMemStorage())))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(interceptors.NewHashSumClientInterceptor(signature.ParseKeys(clientKey))),
+		grpc.WithStreamInterceptor(interceptors.NewHashSumStreamClientInterceptor(signature.ParseKeys(clientKey))),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pbv2.NewMetricsServiceClient(conn)
+}
+
+func TestHashSumMiddleware(t *testing.T) {
+	// labels are serialized deterministically, so the map order does not break the signature
+	req := &pbv2.MetricsRequest{Metrics: []*pbv2.Metric{
+		{Name: "Alloc", Labels: map[string]string{"host": "a", "dc": "b", "rack": "c", "zone": "d"}, Data: &pbv2.Metric_Value{Value: 1}},
+		{Name: "PollCount", Data: &pbv2.Metric_Delta{Delta: 1}},
+	}}
+
+	tests := []struct {
+		name      string
+		serverKey string
+		clientKey string
+		want      codes.Code
+	}{
+		{"signed", "secret", "secret", codes.OK},
+		{"previous key", "current,secret", "secret", codes.OK},
+		{"wrong key", "secret", "other", codes.Unauthenticated},
+		{"not signed", "secret", "", codes.Unauthenticated},
+		{"verification disabled", "", "secret", codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := signedClient(t, tt.serverKey, tt.clientKey)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			_, err := client.UpdateMetrics(ctx, req)
+			assert.Equal(t, tt.want, status.Code(err))
+
+			stream, err := client.StreamMetrics(ctx)
+			require.NoError(t, err)
+			require.NoError(t, stream.Send(req))
+			_, err = stream.Recv()
+			assert.Equal(t, tt.want, status.Code(err))
+		})
+	}
+}
+
+func TestHashSumMiddlewareTamperedRequest(t *testing.T) {
+	middleware := interceptors.NewHashSumMiddleware(signature.Keys{"secret"}, nil)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "success", nil
+	}
+
+	var signed metadata.MD
+	signer := interceptors.NewHashSumClientInterceptor(signature.Keys{"secret"})
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		signed, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	original := &pbv2.MetricsRequest{Metrics: []*pbv2.Metric{{Name: "Alloc", Data: &pbv2.Metric_Value{Value: 1}}}}
+	require.NoError(t, signer(context.Background(), "/method", original, nil, nil, invoker))
+
+	ctx := metadata.NewIncomingContext(context.Background(), signed)
+	resp, err := middleware(ctx, original, &grpc.UnaryServerInfo{}, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "success", resp)
+
+	tampered := &pbv2.MetricsRequest{Metrics: []*pbv2.Metric{{Name: "Alloc", Data: &pbv2.Metric_Value{Value: 2}}}}
+	_, err = middleware(ctx, tampered, &grpc.UnaryServerInfo{}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestHashSumMiddlewareReplay(t *testing.T) {
+	middleware := interceptors.NewHashSumMiddleware(signature.Keys{"secret"}, replay.NewGuard(time.Minute, 1))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "success", nil
+	}
+
+	signer := interceptors.NewHashSumClientInterceptor(signature.Keys{"secret"})
+	req := &pbv2.MetricsRequest{Metrics: []*pbv2.Metric{{Name: "PollCount", Data: &pbv2.Metric_Delta{Delta: 1}}}}
+	sign := func() context.Context {
+		var signed metadata.MD
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			signed, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		}
+		require.NoError(t, signer(context.Background(), "/method", req, nil, nil, invoker))
+		return metadata.NewIncomingContext(context.Background(), signed)
+	}
+
+	captured := sign()
+	_, err := middleware(captured, req, &grpc.UnaryServerInfo{}, handler)
+	assert.NoError(t, err)
+
+	_, err = middleware(captured, req, &grpc.UnaryServerInfo{}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the only slot of the nonce cache is taken
+	_, err = middleware(sign(), req, &grpc.UnaryServerInfo{}, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	stale := sign()
+	md, _ := metadata.FromIncomingContext(stale)
+	md.Set(interceptors.TimestampMetadataKey, signature.FormatTimestamp(time.Now().Add(-time.Hour)))
+	_, err = middleware(metadata.NewIncomingContext(context.Background(), md), req, &grpc.UnaryServerInfo{}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+// clientStream records the messages sent by the client.
+type clientStream struct {
+	grpc.ClientStream
+	sent []proto.Message
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m.(proto.Message))
+	return nil
+}
+
+// serverStream returns the recorded messages to the server.
+type serverStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages []proto.Message
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	if len(s.messages) == 0 {
+		return io.EOF
+	}
+	proto.Merge(m.(proto.Message), s.messages[0])
+	s.messages = s.messages[1:]
+	return nil
+}
+
+// signStream signs the messages as they are sent over a single stream and returns
+// the incoming context of the stream and the sent messages.
+func signStream(t *testing.T, messages ...*pbv2.MetricsRequest) (context.Context, []proto.Message) {
+	t.Helper()
+	var md metadata.MD
+	stream := &clientStream{}
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return stream, nil
+	}
+	signed, err := interceptors.NewHashSumStreamClientInterceptor(signature.Keys{"secret"})(context.Background(), &grpc.StreamDesc{}, nil, "/method", streamer)
+	require.NoError(t, err)
+	for _, m := range messages {
+		require.NoError(t, signed.SendMsg(m))
+	}
+	return metadata.NewIncomingContext(context.Background(), md), stream.sent
+}
+
+// Every message of the stream is authenticated, the message can not be forged, moved or reordered.
+func TestHashSumStreamMiddlewareMessages(t *testing.T) {
+	first := &pbv2.MetricsRequest{Metrics: []*pbv2.Metric{{Name: "PollCount", Data: &pbv2.Metric_Delta{Delta: 1}}}}
+	second := &pbv2.MetricsRequest{Metrics: []*pbv2.Metric{{Name: "PollCount", Data: &pbv2.Metric_Delta{Delta: 2}}}}
+
+	ctx, sent := signStream(t, first, second)
+	otherCtx, otherSent := signStream(t, first)
+	assert.Empty(t, first.GetSignature(), "the sent message is not modified")
+
+	tampered := proto.Clone(sent[1]).(*pbv2.MetricsRequest)
+	tampered.Metrics[0].Data = &pbv2.Metric_Delta{Delta: 100}
+	unsigned := proto.Clone(sent[1]).(*pbv2.MetricsRequest)
+	unsigned.Signature = ""
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		messages []proto.Message
+		want     codes.Code
+	}{
+		{"signed", ctx, sent, codes.OK},
+		{"reordered", ctx, []proto.Message{sent[1], sent[0]}, codes.Unauthenticated},
+		{"tampered", ctx, []proto.Message{sent[0], tampered}, codes.Unauthenticated},
+		{"injected without signature", ctx, []proto.Message{sent[0], unsigned}, codes.Unauthenticated},
+		{"from another stream", ctx, []proto.Message{otherSent[0]}, codes.Unauthenticated},
+		{"other stream", otherCtx, otherSent, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			middleware := interceptors.NewHashSumStreamMiddleware(signature.Keys{"secret"}, nil)
+			var received int
+			handler := func(srv interface{}, stream grpc.ServerStream) error {
+				for {
+					var req pbv2.MetricsRequest
+					if err := stream.RecvMsg(&req); err != nil {
+						if errors.Is(err, io.EOF) {
+							return nil
+						}
+						return err
+					}
+					received++
+				}
+			}
+
+			err := middleware(nil, &serverStream{ctx: tt.ctx, messages: tt.messages}, &grpc.StreamServerInfo{FullMethod: "/method"}, handler)
+			assert.Equal(t, tt.want, status.Code(err))
+			if tt.want == codes.OK {
+				assert.Equal(t, len(tt.messages), received)
+			}
+		})
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/interceptors"
 	"github.com/screamsoul/go-metrics-tpl/pkg/ipmask"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 func TestMiddlewareAllowsRequestWithinCIDR(t *testing.T) {
@@ -87,3 +90,54 @@ func TestMiddlewareHandlesNilNetworkGracefully(t *testing.T) {
 		t.Fatalf("expected success, got %v", resp)
 	}
 }
+
+func TestMiddlewareAllowsTCPPeerWithinCIDR(t *testing.T) {
+	cidrip := ipmask.CIDRIP{
+		Network: &net.IPNet{
+			IP:   net.ParseIP("192.168.1.0"),
+			Mask: net.CIDRMask(24, 32),
+		},
+	}
+	middleware := interceptors.NewTrustedIPMiddleware(cidrip)
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 51234},
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "success", nil
+	}
+	resp, err := middleware(ctx, nil, nil, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "success", resp)
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamMiddlewareChecksCIDR(t *testing.T) {
+	cidrip := ipmask.CIDRIP{
+		Network: &net.IPNet{
+			IP:   net.ParseIP("192.168.1.0"),
+			Mask: net.CIDRMask(24, 32),
+		},
+	}
+	middleware := interceptors.NewTrustedIPStreamMiddleware(cidrip)
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	}
+
+	allowed := &fakeServerStream{ctx: peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 51234},
+	})}
+	assert.NoError(t, middleware(nil, allowed, nil, handler))
+
+	denied := &fakeServerStream{ctx: peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("192.168.2.10"), Port: 51234},
+	})}
+	assert.Equal(t, codes.PermissionDenied, status.Code(middleware(nil, denied, nil, handler)))
+}
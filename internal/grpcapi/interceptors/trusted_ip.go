@@ -2,6 +2,7 @@ package interceptors
 
 import (
 	"context"
+	"net"
 
 	"github.com/screamsoul/go-metrics-tpl/pkg/ipmask"
 	"google.golang.org/grpc"
@@ -10,20 +11,42 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// checkTrustedIP checks that the peer of the call belongs to the trusted subnet.
+func checkTrustedIP(ctx context.Context, cidrip ipmask.CIDRIP) error {
+	if cidrip.Network == nil {
+		return nil
+	}
+	p, ok := peer.FromContext(ctx)
+	// extracts the client's IP address
+	if !ok {
+		return status.Error(codes.Internal, "not check ip addr")
+	}
+
+	ip := p.Addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !cidrip.CheckIPIncluded(ip) {
+		return status.Error(codes.PermissionDenied, "access dinied")
+	}
+	return nil
+}
+
 func NewTrustedIPMiddleware(cidrip ipmask.CIDRIP) func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		if cidrip.Network == nil {
-			return handler(ctx, req)
-		}
-		p, ok := peer.FromContext(ctx)
-		// extracts the client's IP address
-		if !ok {
-			return nil, status.Error(codes.Internal, "not check ip addr")
+		if err := checkTrustedIP(ctx, cidrip); err != nil {
+			return nil, err
 		}
+		return handler(ctx, req)
+	}
+}
 
-		if !cidrip.CheckIPIncluded(p.Addr.String()) {
-			return nil, status.Error(codes.PermissionDenied, "access dinied")
+// NewTrustedIPStreamMiddleware the trusted subnet check for streaming calls.
+func NewTrustedIPStreamMiddleware(cidrip ipmask.CIDRIP) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkTrustedIP(ss.Context(), cidrip); err != nil {
+			return err
 		}
-		return handler(ctx, req)
+		return handler(srv, ss)
 	}
 }
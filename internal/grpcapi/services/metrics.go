@@ -2,16 +2,21 @@ package services
 
 import (
 	"context"
+	"errors"
+	"io"
 	"strings"
+	"time"
 
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
 	pb "github.com/screamsoul/go-metrics-tpl/internal/proto"
 	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"github.com/screamsoul/go-metrics-tpl/pkg/idempotency"
 	"github.com/screamsoul/go-metrics-tpl/pkg/logging"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type MetricServer struct {
@@ -27,49 +32,336 @@ func NewMetricServer(metricRepo repositories.MetricStorage) *MetricServer {
 	return &MetricServer{store: metricRepo, logger: logger}
 }
 
-func (s *MetricServer) UpdateMetrics(ctx context.Context, in *pb.MetricsRequest) (*emptypb.Empty, error) {
+// chunkSize the maximum number of metrics passed to MetricStorage.BulkAdd at once.
+const chunkSize = 100
 
-	chunkSize := 100
+func histogramToProto(h *metrics.HistogramValue) *pb.Histogram {
+	if h == nil {
+		return nil
+	}
+	return &pb.Histogram{
+		Bounds: h.Bounds,
+		Counts: h.Counts,
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
 
-	for i := 0; i < len(in.Metrics); i += chunkSize {
-		end := i + chunkSize
+// metricToProto converts the stored metric into its protobuf representation.
+func metricToProto(m metrics.Metrics) *pb.Metric {
+	pbMetric := &pb.Metric{
+		Name:      m.ID,
+		MType:     pb.Metric_MType(pb.Metric_MType_value[strings.ToUpper(string(m.MType))]),
+		Labels:    m.Labels.Copy(),
+		Histogram: histogramToProto(m.Histogram),
+	}
+	if m.Delta != nil {
+		pbMetric.Delta = *m.Delta
+	}
+	if m.Value != nil {
+		pbMetric.Value = *m.Value
+	}
+	return pbMetric
+}
 
-		// necessary check to avoid slicing beyond
-		// slice capacity
-		if end > len(in.Metrics) {
-			end = len(in.Metrics)
-		}
-		metricChunk := make([]metrics.Metrics, end-i)
-
-		// generate metrics chunk
-		for _, m := range in.Metrics[i:end] {
-			metric, err := metrics.NewMetric(
-				strings.ToLower(m.GetMType().String()),
-				m.GetName(),
-				"",
-			)
-			if err != nil {
-				return nil, status.Error(codes.DataLoss, err.Error())
+func typesFromProto(types []pb.Metric_MType) []metrics.MetricType {
+	result := make([]metrics.MetricType, 0, len(types))
+	for _, t := range types {
+		result = append(result, metrics.MetricType(strings.ToLower(t.String())))
+	}
+	return result
+}
+
+// metricFromProto converts and validates the received metric,
+// only the value field matching the metric type is taken.
+func metricFromProto(m *pb.Metric) (*metrics.Metrics, error) {
+	metric, err := metrics.NewMetric(
+		strings.ToLower(m.GetMType().String()),
+		m.GetName(),
+		"",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	metric.Labels = metrics.Labels(m.GetLabels()).Copy()
+	switch metric.MType {
+	case metrics.Gauge:
+		value := m.GetValue()
+		metric.Value = &value
+	case metrics.Counter:
+		delta := m.GetDelta()
+		metric.Delta = &delta
+	case metrics.Histogram:
+		if h := m.GetHistogram(); h != nil {
+			metric.Histogram = &metrics.HistogramValue{
+				Bounds: h.GetBounds(),
+				Counts: h.GetCounts(),
+				Sum:    h.GetSum(),
+				Count:  h.GetCount(),
 			}
+		}
+	}
+
+	if err := metric.ValidateValue(); err != nil {
+		return nil, err
+	}
+	if err := metric.Labels.Validate(); err != nil {
+		return nil, err
+	}
+	return metric, nil
+}
+
+// metricsFromProto converts the metrics of the request, status DataLoss on the first invalid one.
+func metricsFromProto(in []*pb.Metric) ([]metrics.Metrics, error) {
+	result := make([]metrics.Metrics, 0, len(in))
+	for _, m := range in {
+		metric, err := metricFromProto(m)
+		if err != nil {
+			return nil, status.Error(codes.DataLoss, err.Error())
+		}
+		result = append(result, *metric)
+	}
+	return result, nil
+}
 
-			metric.Value = &m.Value
-			metric.Delta = &m.Delta
+// bulkAdd stores the metrics through MetricStorage.BulkAdd in chunks of chunkSize.
+func (s *MetricServer) bulkAdd(ctx context.Context, metricList []metrics.Metrics) error {
+	for i := 0; i < len(metricList); i += chunkSize {
+		end := min(i+chunkSize, len(metricList))
+		if err := s.store.BulkAdd(ctx, metricList[i:end]); err != nil {
+			s.logger.Error("internal error", zap.Error(err))
+			return status.Error(codes.Internal, "internal error")
+		}
+	}
+	return nil
+}
 
-			err = metric.ValidateValue()
+// bulkAddOnce stores the batch at most once per key, a repeated batch is answered as a stored one.
+// The batch without a key or for the repository without idempotent updates is stored by bulkAdd.
+func (s *MetricServer) bulkAddOnce(ctx context.Context, key string, metricList []metrics.Metrics) error {
+	if key == "" {
+		return s.bulkAdd(ctx, metricList)
+	}
+	if err := idempotency.Validate(key); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if is, ok := s.store.(repositories.IdempotentStorage); ok {
+		_, err := is.BulkAddOnce(ctx, key, metricList)
+		if !errors.Is(err, repositories.ErrIdempotencyDisabled) {
 			if err != nil {
-				return nil, status.Error(codes.DataLoss, err.Error())
+				s.logger.Error("internal error", zap.Error(err))
+				return status.Error(codes.Internal, "internal error")
 			}
+			return nil
+		}
+	}
+	return s.bulkAdd(ctx, metricList)
+}
 
-			metricChunk = append(metricChunk, *metric)
+func (s *MetricServer) UpdateMetrics(ctx context.Context, in *pb.MetricsRequest) (*emptypb.Empty, error) {
+	metricList, err := metricsFromProto(in.GetMetrics())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.bulkAdd(ctx, metricList); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// StreamMetrics stores the metrics of every received request in chunks and acknowledges
+// the request with the number of metrics stored since the stream was opened.
+func (s *MetricServer) StreamMetrics(stream pb.MetricsService_StreamMetricsServer) error {
+	var accepted int64
+	for {
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 
-		err := s.store.BulkAdd(ctx, metricChunk)
+		metricList, err := metricsFromProto(in.GetMetrics())
 		if err != nil {
-			s.logger.Error("internal error", zap.Error(err))
-			return nil, status.Error(codes.Internal, "internal error")
+			return err
+		}
+		if err := s.bulkAdd(stream.Context(), metricList); err != nil {
+			return err
 		}
+		accepted += int64(len(metricList))
 
+		if err := stream.Send(&pb.StreamMetricsAck{Accepted: accepted}); err != nil {
+			return err
+		}
 	}
+}
 
-	return &emptypb.Empty{}, nil
+// GetHistory returns the samples of the series in the [from, to] interval.
+func (s *MetricServer) GetHistory(ctx context.Context, in *pb.HistoryRequest) (*pb.HistoryResponse, error) {
+	metric, err := metrics.NewMetric(
+		strings.ToLower(in.GetMType().String()),
+		in.GetName(),
+		"",
+	)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	metric.Labels = metrics.Labels(in.GetLabels()).Copy()
+	if err := metric.Labels.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var from, to time.Time
+	if in.GetFrom() != nil {
+		from = in.GetFrom().AsTime()
+	}
+	if in.GetTo() != nil {
+		to = in.GetTo().AsTime()
+	}
+
+	hs, ok := s.store.(repositories.HistoryStorage)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, repositories.ErrHistoryDisabled.Error())
+	}
+
+	samples, err := hs.History(ctx, *metric, from, to)
+	if errors.Is(err, repositories.ErrHistoryDisabled) {
+		return nil, status.Error(codes.Unimplemented, err.Error())
+	}
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		s.logger.Error("internal error", zap.Error(err))
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := &pb.HistoryResponse{Samples: make([]*pb.Sample, 0, len(samples))}
+	for _, sample := range samples {
+		pbSample := &pb.Sample{Timestamp: timestamppb.New(sample.Timestamp)}
+		if sample.Delta != nil {
+			pbSample.Delta = *sample.Delta
+		}
+		if sample.Value != nil {
+			pbSample.Value = *sample.Value
+		}
+		pbSample.Histogram = histogramToProto(sample.Histogram)
+		resp.Samples = append(resp.Samples, pbSample)
+	}
+	return resp, nil
+}
+
+// QueryMetrics returns a page of metrics filtered, sorted and paginated according to the request.
+func (s *MetricServer) QueryMetrics(ctx context.Context, in *pb.QueryRequest) (*pb.QueryResponse, error) {
+	q := metrics.Query{
+		Name:   in.GetName(),
+		Order:  metrics.Asc,
+		Limit:  int(in.GetLimit()),
+		Cursor: in.GetCursor(),
+	}
+	if in.GetOrder() == pb.QueryRequest_DESC {
+		q.Order = metrics.Desc
+	}
+	q.Types = typesFromProto(in.GetTypes())
+	if q.Limit == 0 {
+		q.Limit = metrics.DefaultQueryLimit
+	}
+	if q.Limit < 0 || q.Limit > metrics.MaxQueryLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", metrics.MaxQueryLimit)
+	}
+	if err := q.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	page, err := s.store.Query(ctx, q)
+	if err != nil {
+		s.logger.Error("internal error", zap.Error(err))
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := &pb.QueryResponse{
+		Metrics:    make([]*pb.Metric, 0, len(page.Metrics)),
+		NextCursor: page.NextCursor,
+	}
+	for _, m := range page.Metrics {
+		resp.Metrics = append(resp.Metrics, metricToProto(m))
+	}
+	return resp, nil
+}
+
+// GetMetric returns the current value of the series identified by name, type and labels.
+func (s *MetricServer) GetMetric(ctx context.Context, in *pb.GetMetricRequest) (*pb.Metric, error) {
+	metric, err := metrics.NewMetric(
+		strings.ToLower(in.GetMType().String()),
+		in.GetName(),
+		"",
+	)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if metric.ID == "" {
+		return nil, status.Error(codes.InvalidArgument, "metric name must not be empty")
+	}
+	metric.Labels = metrics.Labels(in.GetLabels()).Copy()
+	if err := metric.Labels.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = s.store.Get(ctx, metric)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		s.logger.Error("internal error", zap.Error(err))
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return metricToProto(*metric), nil
+}
+
+// ListMetrics returns all series page by page in name order.
+func (s *MetricServer) ListMetrics(ctx context.Context, in *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	resp, err := s.QueryMetrics(ctx, &pb.QueryRequest{Limit: in.GetPageSize(), Cursor: in.GetPageToken()})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListMetricsResponse{Metrics: resp.GetMetrics(), NextPageToken: resp.GetNextCursor()}, nil
+}
+
+// WatchMetrics sends every accepted update matching the name and type filter until the client disconnects,
+// counter updates carry the accepted delta.
+func (s *MetricServer) WatchMetrics(in *pb.WatchMetricsRequest, stream pb.MetricsService_WatchMetricsServer) error {
+	ss, ok := s.store.(repositories.StreamStorage)
+	if !ok {
+		return status.Error(codes.Unimplemented, "metric stream is disabled")
+	}
+
+	q := metrics.Query{Name: in.GetName(), Types: typesFromProto(in.GetTypes())}
+	if err := q.Validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	updates, err := ss.Subscribe(stream.Context(), q)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case m, ok := <-updates:
+			if !ok {
+				if stream.Context().Err() != nil {
+					return nil
+				}
+				return status.Error(codes.ResourceExhausted, "subscriber is too slow, stream dropped")
+			}
+			if err := stream.Send(metricToProto(m)); err != nil {
+				return err
+			}
+		}
+	}
 }
@@ -4,11 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"testing"
+	"time"
 
 	"github.com/gojuno/minimock/v3"
+	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/grpctest"
 	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/services"
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
 	pb "github.com/screamsoul/go-metrics-tpl/internal/proto"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/broadcast"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -67,3 +77,338 @@ func TestUpdateMetricsProcessesChunks__Error(t *testing.T) {
 	_, err := server.UpdateMetrics(ctx, req)
 	assert.Error(t, err)
 }
+
+func TestUpdateMetricsMixedChunks(t *testing.T) {
+	ctx := context.Background()
+	mc := minimock.NewController(t)
+
+	mockStore := NewMetricStorageMock(mc)
+	defer mockStore.MinimockFinish()
+
+	server := services.NewMetricServer(mockStore)
+
+	var req pb.MetricsRequest
+	for i := 0; i < 150; i++ {
+		mType := pb.Metric_GAUGE
+		if i%2 == 1 {
+			mType = pb.Metric_COUNTER
+		}
+		req.Metrics = append(req.Metrics, &pb.Metric{Name: fmt.Sprintf("metric%d", i), MType: mType, Value: 1.5, Delta: 2})
+	}
+
+	var chunks []int
+	mockStore.BulkAddMock.Set(func(ctx context.Context, m []metrics.Metrics) error {
+		chunks = append(chunks, len(m))
+		for _, metric := range m {
+			assert.NotEmpty(t, metric.ID)
+			switch metric.MType {
+			case metrics.Gauge:
+				assert.Equal(t, 1.5, *metric.Value)
+				assert.Nil(t, metric.Delta)
+			case metrics.Counter:
+				assert.Equal(t, int64(2), *metric.Delta)
+				assert.Nil(t, metric.Value)
+			}
+		}
+		return nil
+	})
+
+	_, err := server.UpdateMetrics(ctx, &req)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{100, 50}, chunks)
+}
+
+func TestUpdateMetricsHistogram(t *testing.T) {
+	ctx := context.Background()
+	mc := minimock.NewController(t)
+
+	mockStore := NewMetricStorageMock(mc)
+	defer mockStore.MinimockFinish()
+
+	server := services.NewMetricServer(mockStore)
+
+	req := &pb.MetricsRequest{Metrics: []*pb.Metric{
+		{
+			Name:  "latency",
+			MType: pb.Metric_HISTOGRAM,
+			Histogram: &pb.Histogram{
+				Bounds: []float64{0.5},
+				Counts: []int64{1, 1},
+				Sum:    1.2,
+				Count:  2,
+			},
+		},
+	}}
+
+	mockStore.BulkAddMock.Set(func(ctx context.Context, m []metrics.Metrics) error {
+		for _, metric := range m {
+			if metric.MType != metrics.Histogram {
+				continue
+			}
+			assert.Equal(t, "latency", metric.ID)
+			assert.Equal(t, []int64{1, 1}, metric.Histogram.Counts)
+			assert.Equal(t, int64(2), metric.Histogram.Count)
+		}
+		return nil
+	})
+
+	_, err := server.UpdateMetrics(ctx, req)
+	assert.NoError(t, err)
+}
+
+func TestGetHistory(t *testing.T) {
+	ctx := context.Background()
+	mc := minimock.NewController(t)
+
+	mockStore := NewMetricStorageMock(mc)
+	defer mockStore.MinimockFinish()
+
+	_, err := services.NewMetricServer(mockStore).GetHistory(ctx, &pb.HistoryRequest{Name: "hits"})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	memS := memory.NewMemStorage()
+	memS.EnableHistory(10)
+	server := services.NewMetricServer(memS)
+
+	for i := int64(1); i <= 3; i++ {
+		delta := i
+		assert.NoError(t, memS.Add(ctx, metrics.Metrics{ID: "hits", MType: metrics.Counter, Delta: &delta}))
+	}
+
+	resp, err := server.GetHistory(ctx, &pb.HistoryRequest{Name: "hits", MType: pb.Metric_COUNTER})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.GetSamples(), 3) {
+		assert.Equal(t, int64(1), resp.GetSamples()[0].GetDelta())
+		assert.Equal(t, int64(6), resp.GetSamples()[2].GetDelta())
+	}
+
+	_, err = server.GetHistory(ctx, &pb.HistoryRequest{Name: "misses", MType: pb.Metric_COUNTER})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = server.GetHistory(ctx, &pb.HistoryRequest{Name: "hits", Labels: map[string]string{"1bad": "x"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestQueryMetrics(t *testing.T) {
+	ctx := context.Background()
+	memS := memory.NewMemStorage()
+	server := services.NewMetricServer(memS)
+
+	for i := 0; i < 3; i++ {
+		value := float64(i)
+		assert.NoError(t, memS.Add(ctx, metrics.Metrics{ID: fmt.Sprintf("load%d", i), MType: metrics.Gauge, Value: &value}))
+	}
+	delta := int64(5)
+	assert.NoError(t, memS.Add(ctx, metrics.Metrics{ID: "load", MType: metrics.Counter, Delta: &delta, Labels: metrics.Labels{"host": "a"}}))
+
+	resp, err := server.QueryMetrics(ctx, &pb.QueryRequest{
+		Name:  "load?",
+		Types: []pb.Metric_MType{pb.Metric_GAUGE},
+		Order: pb.QueryRequest_DESC,
+		Limit: 2,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.GetMetrics(), 2) {
+		assert.Equal(t, "load2", resp.GetMetrics()[0].GetName())
+		assert.Equal(t, 1.0, resp.GetMetrics()[1].GetValue())
+	}
+
+	resp, err = server.QueryMetrics(ctx, &pb.QueryRequest{Name: "load?", Order: pb.QueryRequest_DESC, Limit: 2, Cursor: resp.GetNextCursor()})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetMetrics(), 1)
+	assert.Empty(t, resp.GetNextCursor())
+
+	resp, err = server.QueryMetrics(ctx, &pb.QueryRequest{Types: []pb.Metric_MType{pb.Metric_COUNTER}})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.GetMetrics(), 1) {
+		assert.Equal(t, int64(5), resp.GetMetrics()[0].GetDelta())
+		assert.Equal(t, map[string]string{"host": "a"}, resp.GetMetrics()[0].GetLabels())
+	}
+
+	_, err = server.QueryMetrics(ctx, &pb.QueryRequest{Limit: 5000})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = server.QueryMetrics(ctx, &pb.QueryRequest{Cursor: "bad"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGetMetric(t *testing.T) {
+	ctx := context.Background()
+	mc := minimock.NewController(t)
+
+	mockStore := NewMetricStorageMock(mc)
+	defer mockStore.MinimockFinish()
+
+	server := services.NewMetricServer(mockStore)
+
+	mockStore.GetMock.Set(func(ctx context.Context, m *metrics.Metrics) error {
+		switch m.ID {
+		case "hits":
+			delta := int64(7)
+			m.Delta = &delta
+			return nil
+		case "broken":
+			return errors.New("connection lost")
+		}
+		return fmt.Errorf("metric %s %w", m.ID, repositories.ErrNotFound)
+	})
+
+	resp, err := server.GetMetric(ctx, &pb.GetMetricRequest{Name: "hits", MType: pb.Metric_COUNTER, Labels: map[string]string{"host": "a"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), resp.GetDelta())
+	assert.Equal(t, map[string]string{"host": "a"}, resp.GetLabels())
+
+	_, err = server.GetMetric(ctx, &pb.GetMetricRequest{Name: "misses", MType: pb.Metric_COUNTER})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = server.GetMetric(ctx, &pb.GetMetricRequest{Name: "broken", MType: pb.Metric_COUNTER})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	_, err = server.GetMetric(ctx, &pb.GetMetricRequest{MType: pb.Metric_COUNTER})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.GetMetric(ctx, &pb.GetMetricRequest{Name: "hits", Labels: map[string]string{"1host": "a"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestListMetrics(t *testing.T) {
+	ctx := context.Background()
+	memS := memory.NewMemStorage()
+	server := services.NewMetricServer(memS)
+
+	for i := 0; i < 5; i++ {
+		value := float64(i)
+		assert.NoError(t, memS.Add(ctx, metrics.Metrics{ID: fmt.Sprintf("m%d", i), MType: metrics.Gauge, Value: &value}))
+	}
+
+	var names []string
+	req := &pb.ListMetricsRequest{PageSize: 2}
+	for {
+		resp, err := server.ListMetrics(ctx, req)
+		assert.NoError(t, err)
+		for _, m := range resp.GetMetrics() {
+			names = append(names, m.GetName())
+		}
+		if resp.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+	assert.Equal(t, []string{"m0", "m1", "m2", "m3", "m4"}, names)
+
+	_, err := server.ListMetrics(ctx, &pb.ListMetricsRequest{PageToken: "bad"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// startBufServer runs the metric service on an in-memory listener and returns the connected client.
+func startBufServer(t *testing.T, store repositories.MetricStorage) pb.MetricsServiceClient {
+	conn := grpctest.Serve(t, func(s *grpc.Server) {
+		pb.RegisterMetricsServiceServer(s, services.NewMetricServer(store))
+	})
+	return pb.NewMetricsServiceClient(conn)
+}
+
+func TestWatchMetrics(t *testing.T) {
+	store := broadcast.NewBroadcastMetricWrapper(memory.NewMemStorage(), broadcast.NewHub())
+	client := startBufServer(t, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchMetrics(ctx, &pb.WatchMetricsRequest{Types: []pb.Metric_MType{pb.Metric_COUNTER}})
+	assert.NoError(t, err)
+
+	// the subscription is registered when the first message is sent, so keep publishing until it arrives
+	received := make(chan *pb.Metric)
+	go func() {
+		m, err := stream.Recv()
+		if err == nil {
+			received <- m
+		}
+	}()
+
+	delta := int64(2)
+	value := 1.0
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-received:
+			assert.Equal(t, "hits", m.GetName())
+			assert.Equal(t, int64(2), m.GetDelta())
+			return
+		case <-ticker.C:
+			assert.NoError(t, store.Add(ctx, metrics.Metrics{ID: "load", MType: metrics.Gauge, Value: &value}))
+			assert.NoError(t, store.Add(ctx, metrics.Metrics{ID: "hits", MType: metrics.Counter, Delta: &delta}))
+		case <-ctx.Done():
+			t.Fatal("no update received")
+		}
+	}
+}
+
+func TestWatchMetricsErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := startBufServer(t, memory.NewMemStorage()).WatchMetrics(ctx, &pb.WatchMetricsRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	store := broadcast.NewBroadcastMetricWrapper(memory.NewMemStorage(), broadcast.NewHub())
+	stream, err = startBufServer(t, store).WatchMetrics(ctx, &pb.WatchMetricsRequest{Types: []pb.Metric_MType{pb.Metric_MType(42)}})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestStreamMetrics(t *testing.T) {
+	store := memory.NewMemStorage()
+	client := startBufServer(t, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.StreamMetrics(ctx)
+	assert.NoError(t, err)
+
+	// a request larger than the chunk is stored in several BulkAdd calls
+	var req pb.MetricsRequest
+	for i := 0; i < 250; i++ {
+		req.Metrics = append(req.Metrics, &pb.Metric{Name: "hits", MType: pb.Metric_COUNTER, Delta: 1})
+	}
+	assert.NoError(t, stream.Send(&req))
+	ack, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(250), ack.GetAccepted())
+
+	assert.NoError(t, stream.Send(&pb.MetricsRequest{Metrics: []*pb.Metric{{Name: "load", MType: pb.Metric_GAUGE, Value: 0.5}}}))
+	ack, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(251), ack.GetAccepted())
+
+	assert.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+
+	hits := metrics.Metrics{ID: "hits", MType: metrics.Counter}
+	assert.NoError(t, store.Get(ctx, &hits))
+	assert.Equal(t, int64(250), *hits.Delta)
+
+	load := metrics.Metrics{ID: "load", MType: metrics.Gauge}
+	assert.NoError(t, store.Get(ctx, &load))
+	assert.Equal(t, 0.5, *load.Value)
+}
+
+func TestStreamMetricsInvalidMetric(t *testing.T) {
+	client := startBufServer(t, memory.NewMemStorage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.StreamMetrics(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, stream.Send(&pb.MetricsRequest{Metrics: []*pb.Metric{{Name: "load", MType: pb.Metric_GAUGE, Labels: map[string]string{"1host": "a"}}}}))
+	_, err = stream.Recv()
+	assert.Equal(t, codes.DataLoss, status.Code(err))
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	pbv2 "github.com/screamsoul/go-metrics-tpl/internal/proto/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// MetricServerV2 the ingestion API where the metric type is defined by the set value.
+type MetricServerV2 struct {
+	pbv2.UnimplementedMetricsServiceServer
+
+	base *MetricServer
+}
+
+func NewMetricServerV2(base *MetricServer) *MetricServerV2 {
+	return &MetricServerV2{base: base}
+}
+
+// metricFromProtoV2 converts and validates the received metric.
+func metricFromProtoV2(m *pbv2.Metric) (*metrics.Metrics, error) {
+	metric := &metrics.Metrics{ID: m.GetName(), Labels: metrics.Labels(m.GetLabels()).Copy()}
+
+	switch data := m.GetData().(type) {
+	case *pbv2.Metric_Value:
+		metric.MType = metrics.Gauge
+		metric.Value = &data.Value
+	case *pbv2.Metric_Delta:
+		metric.MType = metrics.Counter
+		metric.Delta = &data.Delta
+	case *pbv2.Metric_Histogram:
+		metric.MType = metrics.Histogram
+		if h := data.Histogram; h != nil {
+			metric.Histogram = &metrics.HistogramValue{
+				Bounds: h.GetBounds(),
+				Counts: h.GetCounts(),
+				Sum:    h.GetSum(),
+				Count:  h.GetCount(),
+			}
+		}
+	default:
+		return nil, errors.New("metric value is not set")
+	}
+
+	if err := metric.ValidateValue(); err != nil {
+		return nil, err
+	}
+	if err := metric.Labels.Validate(); err != nil {
+		return nil, err
+	}
+	return metric, nil
+}
+
+// metricsFromProtoV2 converts the metrics of the request, status DataLoss on the first invalid one.
+func metricsFromProtoV2(in []*pbv2.Metric) ([]metrics.Metrics, error) {
+	result := make([]metrics.Metrics, 0, len(in))
+	for _, m := range in {
+		metric, err := metricFromProtoV2(m)
+		if err != nil {
+			return nil, status.Error(codes.DataLoss, err.Error())
+		}
+		result = append(result, *metric)
+	}
+	return result, nil
+}
+
+// UpdateMetrics stores the metrics, a request with an already applied idempotency key is not applied again.
+func (s *MetricServerV2) UpdateMetrics(ctx context.Context, in *pbv2.MetricsRequest) (*emptypb.Empty, error) {
+	metricList, err := metricsFromProtoV2(in.GetMetrics())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.base.bulkAddOnce(ctx, in.GetIdempotencyKey(), metricList); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// StreamMetrics stores the metrics of every received request and acknowledges the request
+// with the number of metrics stored since the stream was opened, a request with an already
+// applied idempotency key is acknowledged without applying it again.
+func (s *MetricServerV2) StreamMetrics(stream pbv2.MetricsService_StreamMetricsServer) error {
+	var accepted int64
+	for {
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		metricList, err := metricsFromProtoV2(in.GetMetrics())
+		if err != nil {
+			return err
+		}
+		if err := s.base.bulkAddOnce(stream.Context(), in.GetIdempotencyKey(), metricList); err != nil {
+			return err
+		}
+		accepted += int64(len(metricList))
+
+		if err := stream.Send(&pbv2.StreamMetricsAck{Accepted: accepted}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package services_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/grpctest"
+	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/services"
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	pbv2 "github.com/screamsoul/go-metrics-tpl/internal/proto/v2"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func startBufServerV2(t *testing.T, store *memory.MemStorage) pbv2.MetricsServiceClient {
+	conn := grpctest.Serve(t, func(s *grpc.Server) {
+		pbv2.RegisterMetricsServiceServer(s, services.NewMetricServerV2(services.NewMetricServer(store)))
+	})
+	return pbv2.NewMetricsServiceClient(conn)
+}
+
+// mixedRequestV2 returns gauges and counters interleaved, a gauge 0 and a counter 0 are included.
+func mixedRequestV2(n int) *pbv2.MetricsRequest {
+	var req pbv2.MetricsRequest
+	for i := 0; i < n; i++ {
+		req.Metrics = append(req.Metrics,
+			&pbv2.Metric{Name: fmt.Sprintf("gauge%d", i), Data: &pbv2.Metric_Value{Value: float64(i)}},
+			&pbv2.Metric{Name: fmt.Sprintf("counter%d", i), Data: &pbv2.Metric_Delta{Delta: int64(i)}},
+		)
+	}
+	return &req
+}
+
+func assertMixedStored(t *testing.T, store *memory.MemStorage, n int, times int64) {
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		gauge := metrics.Metrics{ID: fmt.Sprintf("gauge%d", i), MType: metrics.Gauge}
+		require.NoError(t, store.Get(ctx, &gauge))
+		assert.Equal(t, float64(i), *gauge.Value)
+
+		counter := metrics.Metrics{ID: fmt.Sprintf("counter%d", i), MType: metrics.Counter}
+		require.NoError(t, store.Get(ctx, &counter))
+		assert.Equal(t, times*int64(i), *counter.Delta)
+	}
+}
+
+func TestUpdateMetricsV2(t *testing.T) {
+	store := memory.NewMemStorage()
+	client := startBufServerV2(t, store)
+	ctx := context.Background()
+
+	_, err := client.UpdateMetrics(ctx, mixedRequestV2(120))
+	require.NoError(t, err)
+
+	list, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, list, 240)
+	assertMixedStored(t, store, 120, 1)
+
+	_, err = client.UpdateMetrics(ctx, &pbv2.MetricsRequest{Metrics: []*pbv2.Metric{
+		{Name: "latency", Data: &pbv2.Metric_Histogram{Histogram: &pbv2.Histogram{
+			Bounds: []float64{0.5},
+			Counts: []int64{1, 1},
+			Sum:    1.2,
+			Count:  2,
+		}}},
+	}})
+	require.NoError(t, err)
+
+	latency := metrics.Metrics{ID: "latency", MType: metrics.Histogram}
+	require.NoError(t, store.Get(ctx, &latency))
+	assert.Equal(t, int64(2), latency.Histogram.Count)
+}
+
+func TestUpdateMetricsV2Invalid(t *testing.T) {
+	client := startBufServerV2(t, memory.NewMemStorage())
+
+	tests := []struct {
+		name   string
+		metric *pbv2.Metric
+	}{
+		{"value not set", &pbv2.Metric{Name: "Alloc"}},
+		{"invalid histogram", &pbv2.Metric{Name: "latency", Data: &pbv2.Metric_Histogram{Histogram: &pbv2.Histogram{Bounds: []float64{1}}}}},
+		{"invalid labels", &pbv2.Metric{Name: "Alloc", Labels: map[string]string{"1host": "a"}, Data: &pbv2.Metric_Value{Value: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.UpdateMetrics(context.Background(), &pbv2.MetricsRequest{Metrics: []*pbv2.Metric{tt.metric}})
+			assert.Equal(t, codes.DataLoss, status.Code(err))
+		})
+	}
+}
+
+func TestStreamMetricsV2(t *testing.T) {
+	store := memory.NewMemStorage()
+	client := startBufServerV2(t, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.StreamMetrics(ctx)
+	require.NoError(t, err)
+
+	for i := 1; i <= 2; i++ {
+		require.NoError(t, stream.Send(mixedRequestV2(60)))
+		ack, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, int64(i*120), ack.GetAccepted())
+	}
+
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+
+	assertMixedStored(t, store, 60, 2)
+}
+
+func TestUpdateMetricsV2Idempotency(t *testing.T) {
+	store := memory.NewMemStorage()
+	store.EnableIdempotency(time.Minute, 10)
+	client := startBufServerV2(t, store)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request := func(key string) *pbv2.MetricsRequest {
+		req := mixedRequestV2(10)
+		req.IdempotencyKey = key
+		return req
+	}
+
+	// the unary retry is answered without applying the batch again
+	for i := 0; i < 2; i++ {
+		_, err := client.UpdateMetrics(ctx, request("batch1"))
+		require.NoError(t, err)
+	}
+	assertMixedStored(t, store, 10, 1)
+
+	_, err := client.UpdateMetrics(ctx, request("batch 2"))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// a batch resent over a new stream is acknowledged as stored
+	stream, err := client.StreamMetrics(ctx)
+	require.NoError(t, err)
+	for i, key := range []string{"batch1", "batch2", "batch2"} {
+		require.NoError(t, stream.Send(request(key)))
+		ack, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, int64((i+1)*20), ack.GetAccepted())
+	}
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+
+	assertMixedStored(t, store, 10, 2)
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// HistogramValue a structure for storing the distribution of observed values.
+//
+// Bounds holds the ascending upper bounds of the buckets, Counts holds the
+// number of observations per bucket (non-cumulative). The last element of
+// Counts is the overflow bucket for values above the last bound, so
+// len(Counts) is always len(Bounds)+1.
+type HistogramValue struct {
+	Bounds []float64 `json:"bounds"` // верхние границы корзин
+	Counts []int64   `json:"counts"` // количество наблюдений в каждой корзине
+	Sum    float64   `json:"sum"`    // сумма наблюдаемых значений
+	Count  int64     `json:"count"`  // общее количество наблюдений
+}
+
+// NewHistogramValue creates an empty histogram with the given bucket bounds.
+func NewHistogramValue(bounds ...float64) *HistogramValue {
+	b := make([]float64, len(bounds))
+	copy(b, bounds)
+	sort.Float64s(b)
+
+	return &HistogramValue{
+		Bounds: b,
+		Counts: make([]int64, len(b)+1),
+	}
+}
+
+// Observe adds a single value to the histogram.
+func (h *HistogramValue) Observe(v float64) {
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+// Validate checks the consistency of buckets, counts and the total count.
+func (h *HistogramValue) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("histogram must have %d counts for %d bounds", len(h.Bounds)+1, len(h.Bounds))
+	}
+	for i := 1; i < len(h.Bounds); i++ {
+		if h.Bounds[i] <= h.Bounds[i-1] {
+			return errors.New("histogram bounds must be strictly ascending")
+		}
+	}
+
+	var total int64
+	for _, c := range h.Counts {
+		if c < 0 {
+			return errors.New("histogram counts must not be negative")
+		}
+		total += c
+	}
+	if total != h.Count {
+		return fmt.Errorf("histogram count %d does not match sum of bucket counts %d", h.Count, total)
+	}
+	return nil
+}
+
+// Merge adds the bucket counts, sum and count of other to the histogram,
+// the same way counters accumulate deltas. Both histograms must have identical bounds.
+func (h *HistogramValue) Merge(other *HistogramValue) error {
+	if len(h.Bounds) != len(other.Bounds) {
+		return errors.New("histogram bounds mismatch")
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return errors.New("histogram bounds mismatch")
+		}
+	}
+
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+	return nil
+}
+
+// Copy returns a deep copy of the histogram.
+func (h *HistogramValue) Copy() *HistogramValue {
+	c := &HistogramValue{
+		Bounds: make([]float64, len(h.Bounds)),
+		Counts: make([]int64, len(h.Counts)),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+	copy(c.Bounds, h.Bounds)
+	copy(c.Counts, h.Counts)
+	return c
+}
+
+// Value implements driver.Valuer, the histogram is stored as json.
+func (h *HistogramValue) Value() (driver.Value, error) {
+	if h == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner for reading the histogram json from the database.
+func (h *HistogramValue) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, h)
+	case string:
+		return json.Unmarshal([]byte(v), h)
+	}
+	return fmt.Errorf("unsupported histogram source type %T", src)
+}
@@ -0,0 +1,90 @@
+package metrics_test
+
+import (
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := metrics.NewHistogramValue(10, 1, 5)
+
+	for _, v := range []float64{0.5, 1, 3, 7, 100} {
+		h.Observe(v)
+	}
+
+	assert.Equal(t, []float64{1, 5, 10}, h.Bounds)
+	assert.Equal(t, []int64{2, 1, 1, 1}, h.Counts)
+	assert.Equal(t, int64(5), h.Count)
+	assert.InDelta(t, 111.5, h.Sum, 1e-9)
+	assert.NoError(t, h.Validate())
+}
+
+func TestHistogramValidate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		histogram metrics.HistogramValue
+		wantErr   bool
+	}{
+		{
+			name:      "valid",
+			histogram: metrics.HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{1, 0, 2}, Count: 3},
+		},
+		{
+			name:      "counts length mismatch",
+			histogram: metrics.HistogramValue{Bounds: []float64{1, 2}, Counts: []int64{1, 0}, Count: 1},
+			wantErr:   true,
+		},
+		{
+			name:      "bounds not ascending",
+			histogram: metrics.HistogramValue{Bounds: []float64{2, 1}, Counts: []int64{1, 0, 0}, Count: 1},
+			wantErr:   true,
+		},
+		{
+			name:      "count mismatch",
+			histogram: metrics.HistogramValue{Bounds: []float64{1}, Counts: []int64{1, 1}, Count: 5},
+			wantErr:   true,
+		},
+		{
+			name:      "negative count",
+			histogram: metrics.HistogramValue{Bounds: []float64{1}, Counts: []int64{-1, 1}, Count: 0},
+			wantErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.histogram.Validate()
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	h := &metrics.HistogramValue{Bounds: []float64{1}, Counts: []int64{1, 2}, Sum: 4, Count: 3}
+
+	err := h.Merge(&metrics.HistogramValue{Bounds: []float64{1}, Counts: []int64{3, 0}, Sum: 1.5, Count: 3})
+	require.NoError(t, err)
+
+	assert.Equal(t, []int64{4, 2}, h.Counts)
+	assert.Equal(t, int64(6), h.Count)
+	assert.InDelta(t, 5.5, h.Sum, 1e-9)
+
+	err = h.Merge(&metrics.HistogramValue{Bounds: []float64{2}, Counts: []int64{0, 0}})
+	assert.Error(t, err)
+}
+
+func TestNewMetricHistogramFromPath(t *testing.T) {
+	_, err := metrics.NewMetric("histogram", "latency", "1")
+	assert.Error(t, err)
+
+	m, err := metrics.NewMetric("histogram", "latency", "")
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Histogram, m.MType)
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// seriesNameEscaper escapes the braces in the series key name, so the name cannot end like a label set.
+var seriesNameEscaper = strings.NewReplacer(`\`, `\\`, `{`, `\{`)
+
+// Labels a set of key/value pairs which, together with the metric name and type, identifies a series.
+type Labels map[string]string
+
+// Validate checks that all label names are valid identifiers.
+func (l Labels) Validate() error {
+	for name := range l {
+		if !labelNameRe.MatchString(name) {
+			return fmt.Errorf("label name `%s` is not valid", name)
+		}
+	}
+	return nil
+}
+
+// Copy returns a copy of the labels, nil for an empty set.
+func (l Labels) Copy() Labels {
+	if len(l) == 0 {
+		return nil
+	}
+	c := make(Labels, len(l))
+	for k, v := range l {
+		c[k] = v
+	}
+	return c
+}
+
+// String returns the canonical representation of labels: sorted `name="value"` pairs separated by comma.
+func (l Labels) String() string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(l[name]))
+	}
+	return sb.String()
+}
+
+// Value implements driver.Valuer, labels are stored as json object.
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(map[string]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner for reading labels json from the database.
+func (l *Labels) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported labels source type %T", src)
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*l = Labels(m).Copy()
+	return nil
+}
+
+// SeriesKey returns the unique series identifier of the metric within its type,
+// the name for metrics without labels and `name{labels}` otherwise.
+// The `{` and `\` of the name are escaped with a backslash.
+func (m *Metrics) SeriesKey() string {
+	name := seriesNameEscaper.Replace(m.ID)
+	if len(m.Labels) == 0 {
+		return name
+	}
+	return name + "{" + m.Labels.String() + "}"
+}
@@ -0,0 +1,85 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesKey(t *testing.T) {
+	testCases := []struct {
+		name   string
+		metric metrics.Metrics
+		expect string
+	}{
+		{
+			name:   "without labels",
+			metric: metrics.Metrics{ID: "cpu"},
+			expect: "cpu",
+		},
+		{
+			name:   "labels are sorted",
+			metric: metrics.Metrics{ID: "cpu", Labels: metrics.Labels{"service": "api", "host": "a"}},
+			expect: `cpu{host="a",service="api"}`,
+		},
+		{
+			name:   "values are escaped",
+			metric: metrics.Metrics{ID: "cpu", Labels: metrics.Labels{"host": `a",b="c`}},
+			expect: `cpu{host="a\",b=\"c"}`,
+		},
+		{
+			name:   "name braces are escaped",
+			metric: metrics.Metrics{ID: `cpu{host="a"}`},
+			expect: `cpu\{host="a"}`,
+		},
+		{
+			name:   "name backslashes are escaped",
+			metric: metrics.Metrics{ID: `cpu\`, Labels: metrics.Labels{"host": "a"}},
+			expect: `cpu\\{host="a"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expect, tc.metric.SeriesKey())
+		})
+	}
+
+	// the unlabeled name which looks like a labeled series is another series
+	labeled := metrics.Metrics{ID: "cpu", Labels: metrics.Labels{"host": "a"}}
+	unlabeled := metrics.Metrics{ID: labeled.SeriesKey()}
+	assert.NotEqual(t, labeled.SeriesKey(), unlabeled.SeriesKey())
+}
+
+func TestLabelsScanValue(t *testing.T) {
+	labels := metrics.Labels{"host": "a"}
+
+	v, err := labels.Value()
+	require.NoError(t, err)
+	assert.Equal(t, `{"host":"a"}`, v)
+
+	var scanned metrics.Labels
+	require.NoError(t, scanned.Scan([]byte(`{"host":"a"}`)))
+	assert.Equal(t, labels, scanned)
+
+	require.NoError(t, scanned.Scan("{}"))
+	assert.Nil(t, scanned)
+
+	v, err = metrics.Labels(nil).Value()
+	require.NoError(t, err)
+	assert.Equal(t, "{}", v)
+}
+
+func TestUnmarshalInvalidLabels(t *testing.T) {
+	var m metrics.Metrics
+	err := json.Unmarshal([]byte(`{"id": "cpu", "type": "gauge", "value": 1, "labels": {"bad-name": "a"}}`), &m)
+	assert.Error(t, err)
+
+	var valid metrics.Metrics
+	err = json.Unmarshal([]byte(`{"id": "cpu", "type": "gauge", "value": 1, "labels": {"host": "a"}}`), &valid)
+	require.NoError(t, err)
+	assert.Equal(t, metrics.Labels{"host": "a"}, valid.Labels)
+}
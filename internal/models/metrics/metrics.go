@@ -10,12 +10,13 @@ import (
 type MetricType string
 
 const (
-	Gauge   MetricType = "gauge"
-	Counter MetricType = "counter"
+	Gauge     MetricType = "gauge"
+	Counter   MetricType = "counter"
+	Histogram MetricType = "histogram"
 )
 
 func (mt MetricType) IsValid() bool {
-	if mt == Gauge || mt == Counter {
+	if mt == Gauge || mt == Counter || mt == Histogram {
 		return true
 	}
 	return false
@@ -24,10 +25,12 @@ func (mt MetricType) IsValid() bool {
 
 // Metrics a structure for storing information about metrics.
 type Metrics struct {
-	ID    string     `json:"id" db:"name"`                         // имя метрики
-	MType MetricType `json:"type" db:"m_type"`                     // параметр, принимающий значение gauge или counter
-	Delta *int64     `json:"delta,omitempty" db:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64   `json:"value,omitempty" db:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID        string          `json:"id" db:"name"`                                 // имя метрики
+	MType     MetricType      `json:"type" db:"m_type"`                             // параметр, принимающий значение gauge, counter или histogram
+	Delta     *int64          `json:"delta,omitempty" db:"delta,omitempty"`         // значение метрики в случае передачи counter
+	Value     *float64        `json:"value,omitempty" db:"value,omitempty"`         // значение метрики в случае передачи gauge
+	Histogram *HistogramValue `json:"histogram,omitempty" db:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Labels    Labels          `json:"labels,omitempty" db:"labels"`                 // метки серии, входят в идентификатор метрики
 }
 
 func NewMetric(metricType, metricName, metricValue string) (*Metrics, error) {
@@ -58,6 +61,8 @@ func NewMetric(metricType, metricName, metricValue string) (*Metrics, error) {
 			return nil, fmt.Errorf("failed to parse metric delta as int64: %w", err)
 		}
 		metrics.Delta = &delta
+	case Histogram:
+		return nil, fmt.Errorf("metric type `%s` can only be passed in json format", mType)
 	}
 
 	return metrics, nil
@@ -69,6 +74,9 @@ func (m *Metrics) GetValue() (val string) {
 		val = fmt.Sprint(*m.Value)
 	case Counter:
 		val = fmt.Sprint(*m.Delta)
+	case Histogram:
+		data, _ := json.Marshal(m.Histogram)
+		val = string(data)
 	}
 	return
 }
@@ -92,6 +100,11 @@ func (m *Metrics) ValidateValue() error {
 		if m.Delta == nil {
 			return fmt.Errorf("metric type `%s` must be set Delta filed", m.MType)
 		}
+	case Histogram:
+		if m.Histogram == nil {
+			return fmt.Errorf("metric type `%s` must be set Histogram filed", m.MType)
+		}
+		return m.Histogram.Validate()
 	}
 	return nil
 }
@@ -112,5 +125,8 @@ func (m *Metrics) UnmarshalJSON(data []byte) error {
 
 	m.MType = MetricType(aux.MType)
 
-	return m.ValidateType()
+	if err := m.ValidateType(); err != nil {
+		return err
+	}
+	return m.Labels.Validate()
 }
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Order the sort order of the listing, series are ordered by name, type and labels.
+type Order string
+
+const (
+	Asc  Order = "asc"
+	Desc Order = "desc"
+)
+
+// The page size limits of the listing shared by the REST and gRPC APIs.
+const (
+	DefaultQueryLimit = 100  // размер страницы листинга по умолчанию
+	MaxQueryLimit     = 1000 // максимальный размер страницы листинга
+)
+
+// Query parameters of the metric listing.
+type Query struct {
+	Name   string       // префикс имени или glob шаблон (`*`, `?`, `[...]`)
+	Types  []MetricType // типы метрик, пустой список - все типы
+	Order  Order        // порядок сортировки, по умолчанию Asc
+	Limit  int          // максимальный размер страницы, 0 - без ограничения
+	Cursor string       // курсор страницы из Page.NextCursor
+}
+
+// Page a single page of the metric listing.
+type Page struct {
+	Metrics    []Metrics `json:"metrics"`               // метрики страницы
+	NextCursor string    `json:"next_cursor,omitempty"` // курсор следующей страницы, пусто если страница последняя
+}
+
+// cursor the sort key of the last series of the page.
+type cursor struct {
+	Name   string     `json:"n"`
+	MType  MetricType `json:"t"`
+	Labels Labels     `json:"l,omitempty"`
+}
+
+// Validate checks the query parameters.
+func (q *Query) Validate() error {
+	for _, t := range q.Types {
+		if !t.IsValid() {
+			return fmt.Errorf("metric type `%s` is not valid", t)
+		}
+	}
+	if q.Order != "" && q.Order != Asc && q.Order != Desc {
+		return fmt.Errorf("order `%s` is not valid", q.Order)
+	}
+	if q.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	if _, err := q.After(); err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(q.NameRegexp()); err != nil {
+		return fmt.Errorf("name pattern `%s` is not valid", q.Name)
+	}
+	return nil
+}
+
+// IsGlob reports whether the name filter is a glob pattern rather than a prefix.
+func (q *Query) IsGlob() bool {
+	return strings.ContainsAny(q.Name, "*?[")
+}
+
+// NameRegexp converts the name filter into an anchored regular expression, empty for no filter.
+// The syntax is common to Go regexp and PostgreSQL `~` operator.
+func (q *Query) NameRegexp() string {
+	if q.Name == "" {
+		return ""
+	}
+	if !q.IsGlob() {
+		return "^" + regexp.QuoteMeta(q.Name)
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('^')
+	for i := 0; i < len(q.Name); i++ {
+		switch c := q.Name[i]; c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteByte('.')
+		case '[':
+			end := strings.IndexByte(q.Name[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := q.Name[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteByte('$')
+	return sb.String()
+}
+
+// After decodes the cursor into the identity of the last series of the previous page, nil for the first page.
+func (q *Query) After() (*Metrics, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, errors.New("cursor is not valid")
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.New("cursor is not valid")
+	}
+	return &Metrics{ID: c.Name, MType: c.MType, Labels: c.Labels.Copy()}, nil
+}
+
+// NewCursor encodes the identity of the metric into a page cursor.
+func NewCursor(m Metrics) string {
+	data, _ := json.Marshal(cursor{Name: m.ID, MType: m.MType, Labels: m.Labels.Copy()})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// compareSeries orders series by name, type and the canonical labels representation.
+func compareSeries(a, b *Metrics) int {
+	if c := strings.Compare(a.ID, b.ID); c != 0 {
+		return c
+	}
+	if c := strings.Compare(string(a.MType), string(b.MType)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Labels.String(), b.Labels.String())
+}
+
+// Apply filters, sorts and paginates the full list of metrics according to the query,
+// it is used by repositories that can not do the work in the storage itself.
+func (q *Query) Apply(list []Metrics) (Page, error) {
+	if err := q.Validate(); err != nil {
+		return Page{}, err
+	}
+	after, _ := q.After()
+
+	var nameRe *regexp.Regexp
+	if pattern := q.NameRegexp(); pattern != "" {
+		nameRe = regexp.MustCompile(pattern)
+	}
+
+	desc := q.Order == Desc
+	result := make([]Metrics, 0, len(list))
+	for _, m := range list {
+		if nameRe != nil && !nameRe.MatchString(m.ID) {
+			continue
+		}
+		if len(q.Types) > 0 && !containsType(q.Types, m.MType) {
+			continue
+		}
+		if after != nil {
+			c := compareSeries(&m, after)
+			if (!desc && c <= 0) || (desc && c >= 0) {
+				continue
+			}
+		}
+		result = append(result, m)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		c := compareSeries(&result[i], &result[j])
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	page := Page{Metrics: result}
+	if q.Limit > 0 && len(result) > q.Limit {
+		page.Metrics = result[:q.Limit]
+		page.NextCursor = NewCursor(page.Metrics[q.Limit-1])
+	}
+	return page, nil
+}
+
+func containsType(types []MetricType, t MetricType) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
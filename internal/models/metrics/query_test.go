@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryNameRegexp(t *testing.T) {
+	var testTable = []struct {
+		name    string
+		matches []string
+		misses  []string
+	}{
+		{name: "", matches: []string{"Alloc"}},
+		{name: "Heap", matches: []string{"HeapAlloc", "Heap"}, misses: []string{"StackHeap"}},
+		{name: "go.gc", matches: []string{"go.gc.count"}, misses: []string{"goXgc"}},
+		{name: "*Alloc", matches: []string{"HeapAlloc", "Alloc"}, misses: []string{"AllocCount"}},
+		{name: "cpu?", matches: []string{"cpu0", "cpu1"}, misses: []string{"cpu", "cpu10"}},
+		{name: "cpu[0-1]", matches: []string{"cpu0", "cpu1"}, misses: []string{"cpu2"}},
+		{name: "cpu[!0]", matches: []string{"cpu1"}, misses: []string{"cpu0"}},
+		{name: "a[b", matches: []string{"a[b"}, misses: []string{"ab"}},
+	}
+	for _, v := range testTable {
+		t.Run(v.name, func(t *testing.T) {
+			q := Query{Name: v.name}
+			re := regexp.MustCompile(q.NameRegexp())
+			for _, name := range v.matches {
+				assert.True(t, re.MatchString(name), name)
+			}
+			for _, name := range v.misses {
+				assert.False(t, re.MatchString(name), name)
+			}
+		})
+	}
+}
+
+func TestQueryValidate(t *testing.T) {
+	assert.NoError(t, (&Query{Types: []MetricType{Gauge}, Order: Desc, Limit: 1}).Validate())
+	assert.Error(t, (&Query{Types: []MetricType{"timer"}}).Validate())
+	assert.Error(t, (&Query{Order: "random"}).Validate())
+	assert.Error(t, (&Query{Limit: -1}).Validate())
+	assert.Error(t, (&Query{Cursor: "not a cursor"}).Validate())
+}
+
+func TestQueryApplyPagination(t *testing.T) {
+	var list []Metrics
+	for i := 0; i < 5; i++ {
+		value := float64(i)
+		delta := int64(i)
+		list = append(list,
+			Metrics{ID: fmt.Sprintf("m%d", i), MType: Gauge, Value: &value},
+			Metrics{ID: fmt.Sprintf("m%d", i), MType: Counter, Delta: &delta, Labels: Labels{"host": "a"}},
+		)
+	}
+	list = append(list, Metrics{ID: "other", MType: Gauge, Value: list[0].Value})
+
+	for _, order := range []Order{Asc, Desc} {
+		t.Run(string(order), func(t *testing.T) {
+			q := Query{Name: "m", Order: order, Limit: 3}
+			var keys []string
+			pages := 0
+			for {
+				page, err := q.Apply(list)
+				require.NoError(t, err)
+				pages++
+				for _, m := range page.Metrics {
+					keys = append(keys, string(m.MType)+":"+m.SeriesKey())
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				q.Cursor = page.NextCursor
+			}
+
+			expected := []string{
+				"counter:m0{host=\"a\"}", "gauge:m0",
+				"counter:m1{host=\"a\"}", "gauge:m1",
+				"counter:m2{host=\"a\"}", "gauge:m2",
+				"counter:m3{host=\"a\"}", "gauge:m3",
+				"counter:m4{host=\"a\"}", "gauge:m4",
+			}
+			if order == Desc {
+				for i, j := 0, len(expected)-1; i < j; i, j = i+1, j-1 {
+					expected[i], expected[j] = expected[j], expected[i]
+				}
+			}
+			assert.Equal(t, expected, keys)
+			assert.Equal(t, 4, pages)
+		})
+	}
+
+	page, err := (&Query{Types: []MetricType{Gauge}, Name: "*4"}).Apply(list)
+	require.NoError(t, err)
+	require.Len(t, page.Metrics, 1)
+	assert.Equal(t, "m4", page.Metrics[0].ID)
+	assert.Empty(t, page.NextCursor)
+}
@@ -0,0 +1,11 @@
+package metrics
+
+import "time"
+
+// Sample the value of a series at the moment an update was accepted.
+type Sample struct {
+	Timestamp time.Time       `json:"timestamp" db:"created_at"`          // время принятия обновления
+	Delta     *int64          `json:"delta,omitempty" db:"delta"`         // значение counter после обновления
+	Value     *float64        `json:"value,omitempty" db:"value"`         // значение gauge после обновления
+	Histogram *HistogramValue `json:"histogram,omitempty" db:"histogram"` // значение histogram после обновления
+}
@@ -0,0 +1,54 @@
+// Package graphite implements parsing of the Graphite plaintext protocol.
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+// ParseLine parses a `path value [timestamp]` line into a gauge.
+//
+// Tagged paths `path;tag=value;...` are supported, tags become the series labels.
+// The timestamp is validated but not stored, `-1` means the time of receipt.
+func ParseLine(line string) (*metrics.Metrics, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, errors.New("line must be in `path value [timestamp]` format")
+	}
+	if len(fields) == 3 {
+		if _, err := strconv.ParseFloat(fields[2], 64); err != nil {
+			return nil, fmt.Errorf("timestamp `%s` is not valid", fields[2])
+		}
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("value `%s` is not valid", fields[1])
+	}
+
+	path, rawTags, _ := strings.Cut(fields[0], ";")
+	if path == "" {
+		return nil, errors.New("path must not be empty")
+	}
+
+	m := &metrics.Metrics{ID: path, MType: metrics.Gauge, Value: &value}
+	if rawTags != "" {
+		m.Labels = make(metrics.Labels)
+		for _, tag := range strings.Split(rawTags, ";") {
+			name, tagValue, ok := strings.Cut(tag, "=")
+			if !ok || name == "" || tagValue == "" {
+				return nil, fmt.Errorf("tag `%s` must be in name=value format", tag)
+			}
+			m.Labels[name] = tagValue
+		}
+		if err := m.Labels.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,48 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLine(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+
+	var testTable = []struct {
+		line     string
+		expected *metrics.Metrics
+		wantErr  bool
+	}{
+		{
+			line:     "servers.web1.load 0.75 1700000000\n",
+			expected: &metrics.Metrics{ID: "servers.web1.load", MType: metrics.Gauge, Value: value(0.75)},
+		},
+		{
+			line:     "backup.duration 12",
+			expected: &metrics.Metrics{ID: "backup.duration", MType: metrics.Gauge, Value: value(12)},
+		},
+		{
+			line:     "disk.used;host=a;mount=root 42 -1",
+			expected: &metrics.Metrics{ID: "disk.used", MType: metrics.Gauge, Value: value(42), Labels: metrics.Labels{"host": "a", "mount": "root"}},
+		},
+		{line: "disk.used", wantErr: true},
+		{line: "disk.used abc", wantErr: true},
+		{line: "disk.used 1 now", wantErr: true},
+		{line: "disk.used NaN", wantErr: true},
+		{line: "disk.used;host 1", wantErr: true},
+		{line: ";host=a 1", wantErr: true},
+	}
+	for _, v := range testTable {
+		t.Run(v.line, func(t *testing.T) {
+			m, err := ParseLine(v.line)
+			if v.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, v.expected, m)
+		})
+	}
+}
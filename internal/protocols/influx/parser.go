@@ -0,0 +1,178 @@
+// Package influx implements parsing of the InfluxDB line protocol into metrics.
+package influx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/protocols"
+)
+
+// Parse reads metrics in the InfluxDB line protocol.
+//
+// Every field of a line becomes a separate metric named `measurement_field`, the field
+// `value` is named after the measurement only, tags become the series labels.
+// Integer fields (`i` and `u` suffixes) are stored as counters, float and boolean
+// fields as gauges, string fields are skipped. Lines that fail to parse or have no
+// numeric fields are recorded in the report.
+func Parse(r io.Reader, report *protocols.Report) ([]metrics.Metrics, error) {
+	var result []metrics.Metrics
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lineMetrics, err := parseLine(line)
+		if err != nil {
+			report.Reject(lineNum, err)
+			continue
+		}
+		result = append(result, lineMetrics...)
+	}
+
+	return result, scanner.Err()
+}
+
+// splitUnescaped splits s by sep ignoring escaped separators and, if quoted is set,
+// separators inside double quotes.
+func splitUnescaped(s string, sep byte, quoted bool, limit int) []string {
+	var parts []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			if limit > 0 && len(parts) == limit-1 {
+				continue
+			}
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+var unescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\\`, `\`)
+
+func parseLine(line string) ([]metrics.Metrics, error) {
+	sections := splitUnescaped(line, ' ', true, 0)
+	nonEmpty := sections[:0]
+	for _, s := range sections {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	if len(nonEmpty) < 2 || len(nonEmpty) > 3 {
+		return nil, errors.New("line must be in `measurement[,tags] fields [timestamp]` format")
+	}
+	if len(nonEmpty) == 3 {
+		if _, err := strconv.ParseInt(nonEmpty[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("timestamp `%s` is not valid", nonEmpty[2])
+		}
+	}
+
+	key := splitUnescaped(nonEmpty[0], ',', false, 0)
+	measurement := unescaper.Replace(key[0])
+	if measurement == "" {
+		return nil, errors.New("measurement must not be empty")
+	}
+
+	var labels metrics.Labels
+	if len(key) > 1 {
+		labels = make(metrics.Labels, len(key)-1)
+		for _, tag := range key[1:] {
+			kv := splitUnescaped(tag, '=', false, 2)
+			if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				return nil, fmt.Errorf("tag `%s` must be in key=value format", tag)
+			}
+			labels[unescaper.Replace(kv[0])] = unescaper.Replace(kv[1])
+		}
+		if err := labels.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var result []metrics.Metrics
+	for _, field := range splitUnescaped(nonEmpty[1], ',', true, 0) {
+		kv := splitUnescaped(field, '=', true, 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("field `%s` must be in key=value format", field)
+		}
+
+		m, err := parseField(measurement, unescaper.Replace(kv[0]), kv[1])
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			continue
+		}
+		m.Labels = labels.Copy()
+		result = append(result, *m)
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("line has no numeric fields")
+	}
+	return result, nil
+}
+
+// parseField converts the field value into a metric, nil for string fields.
+func parseField(measurement, name, raw string) (*metrics.Metrics, error) {
+	id := measurement + "_" + name
+	if name == "value" {
+		id = measurement
+	}
+
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return nil, fmt.Errorf("string field `%s` is not terminated", name)
+		}
+		return nil, nil
+	case strings.HasSuffix(raw, "i"):
+		delta, err := strconv.ParseInt(strings.TrimSuffix(raw, "i"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("integer field `%s` value `%s` is not valid", name, raw)
+		}
+		return &metrics.Metrics{ID: id, MType: metrics.Counter, Delta: &delta}, nil
+	case strings.HasSuffix(raw, "u"):
+		u, err := strconv.ParseUint(strings.TrimSuffix(raw, "u"), 10, 64)
+		if err != nil || u > math.MaxInt64 {
+			return nil, fmt.Errorf("unsigned field `%s` value `%s` is not valid", name, raw)
+		}
+		delta := int64(u)
+		return &metrics.Metrics{ID: id, MType: metrics.Counter, Delta: &delta}, nil
+	}
+
+	var value float64
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		value = 1
+	case "f", "F", "false", "False", "FALSE":
+		value = 0
+	default:
+		var err error
+		value, err = strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("float field `%s` value `%s` is not valid", name, raw)
+		}
+	}
+	return &metrics.Metrics{ID: id, MType: metrics.Gauge, Value: &value}, nil
+}
@@ -0,0 +1,47 @@
+package influx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/protocols"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	payload := `# comment
+cpu,host=server\ 1,region=eu usage_idle=92.5,ticks=100i,up=true,note="a b, c" 1700000000000000000
+mem value=1024u
+weather\,station temperature=-3.5e1
+
+bad_line
+cpu usage=abc
+cpu,host usage=1
+cpu,host=a note="text"
+cpu,1host=a usage=1
+cpu usage=1 notatime
+`
+	var report protocols.Report
+	list, err := Parse(strings.NewReader(payload), &report)
+	require.NoError(t, err)
+
+	gauge := func(v float64) *float64 { return &v }
+	counter := func(v int64) *int64 { return &v }
+	labels := metrics.Labels{"host": "server 1", "region": "eu"}
+
+	assert.Equal(t, []metrics.Metrics{
+		{ID: "cpu_usage_idle", MType: metrics.Gauge, Value: gauge(92.5), Labels: labels},
+		{ID: "cpu_ticks", MType: metrics.Counter, Delta: counter(100), Labels: labels},
+		{ID: "cpu_up", MType: metrics.Gauge, Value: gauge(1), Labels: labels},
+		{ID: "mem", MType: metrics.Counter, Delta: counter(1024)},
+		{ID: "weather,station_temperature", MType: metrics.Gauge, Value: gauge(-35)},
+	}, list)
+
+	lines := make([]int, 0, len(report.Rejected))
+	for _, rejected := range report.Rejected {
+		lines = append(lines, rejected.Line)
+	}
+	assert.Equal(t, []int{6, 7, 8, 9, 10, 11}, lines)
+}
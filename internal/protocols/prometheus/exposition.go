@@ -0,0 +1,184 @@
+// Package prometheus implements the Prometheus text exposition format for metrics.
+package prometheus
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+// ContentType the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// SanitizeName converts the metric name to the Prometheus rules `[a-zA-Z_:][a-zA-Z0-9_:]*`,
+// invalid characters are replaced by underscore.
+func SanitizeName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(name) + 1)
+	for i, r := range name {
+		switch {
+		case r == '_' || r == ':' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z'):
+			sb.WriteRune(r)
+		case '0' <= r && r <= '9':
+			if i == 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+func typeName(mType metrics.MetricType) string {
+	switch mType {
+	case metrics.Gauge:
+		return "gauge"
+	case metrics.Counter:
+		return "counter"
+	case metrics.Histogram:
+		return "histogram"
+	}
+	return "untyped"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeLabels writes the `{name="value",...}` block, extra is appended after the series labels.
+func writeLabels(w *bufio.Writer, labels metrics.Labels, extra ...string) {
+	if len(labels) == 0 && len(extra) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(name)
+		w.WriteString(`="`)
+		w.WriteString(labelValueReplacer.Replace(labels[name]))
+		w.WriteByte('"')
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if len(names) > 0 || i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(extra[i])
+		w.WriteString(`="`)
+		w.WriteString(labelValueReplacer.Replace(extra[i+1]))
+		w.WriteByte('"')
+	}
+	w.WriteByte('}')
+}
+
+func writeSample(w *bufio.Writer, name string, labels metrics.Labels, value string, extra ...string) {
+	w.WriteString(name)
+	writeLabels(w, labels, extra...)
+	w.WriteByte(' ')
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
+
+// Write renders the metrics in the text exposition format.
+//
+// Series are grouped into families by sanitized name, every family gets a single
+// `# TYPE` line. Histograms are exposed with cumulative `_bucket`, `_sum` and `_count` series.
+// When metrics of different types are sanitized to the same name, only the first
+// type in the sort order is exposed, since Prometheus rejects mixed families.
+func Write(out io.Writer, list []metrics.Metrics) error {
+	type entry struct {
+		name   string
+		series string
+		metric metrics.Metrics
+	}
+
+	entries := make([]entry, 0, len(list))
+	for _, m := range list {
+		entries = append(entries, entry{name: SanitizeName(m.ID), series: m.Labels.String(), metric: m})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].name != entries[j].name {
+			return entries[i].name < entries[j].name
+		}
+		if entries[i].metric.MType != entries[j].metric.MType {
+			return entries[i].metric.MType < entries[j].metric.MType
+		}
+		return entries[i].series < entries[j].series
+	})
+
+	w := bufio.NewWriter(out)
+
+	var family string
+	var familyType metrics.MetricType
+	for i, e := range entries {
+		m := e.metric
+		if i == 0 || e.name != family {
+			family, familyType = e.name, m.MType
+			w.WriteString("# TYPE ")
+			w.WriteString(family)
+			w.WriteByte(' ')
+			w.WriteString(typeName(familyType))
+			w.WriteByte('\n')
+		} else if m.MType != familyType {
+			continue
+		}
+
+		switch m.MType {
+		case metrics.Gauge:
+			if m.Value != nil {
+				writeSample(w, family, m.Labels, formatFloat(*m.Value))
+			}
+		case metrics.Counter:
+			if m.Delta != nil {
+				writeSample(w, family, m.Labels, strconv.FormatInt(*m.Delta, 10))
+			}
+		case metrics.Histogram:
+			if m.Histogram == nil {
+				continue
+			}
+			h := m.Histogram
+			var cumulative int64
+			for i, c := range h.Counts {
+				cumulative += c
+				le := math.Inf(1)
+				if i < len(h.Bounds) {
+					le = h.Bounds[i]
+				}
+				writeSample(w, family+"_bucket", m.Labels, strconv.FormatInt(cumulative, 10), "le", formatFloat(le))
+			}
+			writeSample(w, family+"_sum", m.Labels, formatFloat(h.Sum))
+			writeSample(w, family+"_count", m.Labels, strconv.FormatInt(h.Count, 10))
+		}
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,70 @@
+package prometheus
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeName(t *testing.T) {
+	var testTable = []struct {
+		name     string
+		expected string
+	}{
+		{name: "Alloc", expected: "Alloc"},
+		{name: "http.requests-total", expected: "http_requests_total"},
+		{name: "9lives", expected: "_9lives"},
+		{name: "ns:metric_1", expected: "ns:metric_1"},
+		{name: "cpu load%", expected: "cpu_load_"},
+		{name: "", expected: "_"},
+	}
+	for _, v := range testTable {
+		t.Run(v.name, func(t *testing.T) {
+			assert.Equal(t, v.expected, SanitizeName(v.name))
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	gauge := 1.5
+	inf := math.Inf(1)
+	counter := int64(42)
+	labeled := int64(3)
+
+	h := metrics.NewHistogramValue(0.1, 1)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	list := []metrics.Metrics{
+		{ID: "requests", MType: metrics.Counter, Delta: &labeled, Labels: metrics.Labels{"path": "/a\"b"}},
+		{ID: "requests", MType: metrics.Counter, Delta: &counter},
+		{ID: "heap.alloc", MType: metrics.Gauge, Value: &gauge},
+		{ID: "requests", MType: metrics.Gauge, Value: &gauge},
+		{ID: "top", MType: metrics.Gauge, Value: &inf},
+		{ID: "latency", MType: metrics.Histogram, Histogram: h, Labels: metrics.Labels{"host": "a"}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, list))
+
+	expected := `# TYPE heap_alloc gauge
+heap_alloc 1.5
+# TYPE latency histogram
+latency_bucket{host="a",le="0.1"} 1
+latency_bucket{host="a",le="1"} 2
+latency_bucket{host="a",le="+Inf"} 3
+latency_sum{host="a"} 5.55
+latency_count{host="a"} 3
+# TYPE requests counter
+requests 42
+requests{path="/a\"b"} 3
+# TYPE top gauge
+top +Inf
+`
+	assert.Equal(t, expected, buf.String())
+}
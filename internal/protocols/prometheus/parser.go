@@ -0,0 +1,183 @@
+package prometheus
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/protocols"
+)
+
+// Parse reads metrics in the Prometheus/OpenMetrics text format.
+//
+// Only `gauge` and `counter` families are accepted, samples of other families and
+// malformed lines are recorded in the report. The value of a counter sample is
+// the cumulative total, it is returned in Delta as is and the caller converts it
+// into the increment since the previously stored total.
+// The sample timestamp is validated but not stored.
+func Parse(r io.Reader, report *protocols.Report) ([]metrics.Metrics, error) {
+	families := make(map[string]string)
+	var result []metrics.Metrics
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[1] == "EOF" {
+				break
+			}
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				families[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		m, err := parseSample(line, families)
+		if err != nil {
+			report.Reject(lineNum, err)
+			continue
+		}
+		result = append(result, *m)
+	}
+
+	return result, scanner.Err()
+}
+
+// familyType returns the declared type of the sample family, the OpenMetrics `_total`
+// suffix of counter samples is taken into account.
+func familyType(name string, families map[string]string) string {
+	if t, ok := families[name]; ok {
+		return t
+	}
+	if base, ok := strings.CutSuffix(name, "_total"); ok && families[base] == "counter" {
+		return "counter"
+	}
+	return "untyped"
+}
+
+func parseSample(line string, families map[string]string) (*metrics.Metrics, error) {
+	nameEnd := strings.IndexAny(line, "{ \t")
+	if nameEnd <= 0 {
+		return nil, errors.New("sample must have a name and a value")
+	}
+	name := line[:nameEnd]
+	rest := line[nameEnd:]
+
+	var labels metrics.Labels
+	if rest[0] == '{' {
+		var err error
+		labels, rest, err = parseLabels(rest[1:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, errors.New("sample must have a value and an optional timestamp")
+	}
+	if len(fields) == 2 {
+		if _, err := strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return nil, fmt.Errorf("timestamp `%s` is not valid", fields[1])
+		}
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("value `%s` is not valid", fields[0])
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("value `%s` must be finite", fields[0])
+	}
+
+	m := metrics.Metrics{ID: name, Labels: labels}
+	switch t := familyType(name, families); t {
+	case "gauge":
+		m.MType = metrics.Gauge
+		m.Value = &value
+	case "counter":
+		if value != math.Trunc(value) || value < math.MinInt64 || value >= math.MaxInt64 {
+			return nil, fmt.Errorf("counter value `%s` must be an integer", fields[0])
+		}
+		delta := int64(value)
+		m.MType = metrics.Counter
+		m.Delta = &delta
+	default:
+		return nil, fmt.Errorf("metric family `%s` has unsupported type `%s`", name, t)
+	}
+
+	if err := m.Labels.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// parseLabels parses the label pairs after the opening brace and returns the rest of the line.
+func parseLabels(s string) (metrics.Labels, string, error) {
+	labels := make(metrics.Labels)
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return nil, "", errors.New("unterminated label set")
+		}
+		if s[0] == '}' {
+			return labels.Copy(), s[1:], nil
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, "", errors.New("label must be in name=\"value\" format")
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		if s == "" || s[0] != '"' {
+			return nil, "", fmt.Errorf("value of label `%s` must be quoted", name)
+		}
+
+		var value strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] != '\\' {
+				value.WriteByte(s[i])
+				continue
+			}
+			i++
+			if i == len(s) {
+				break
+			}
+			switch s[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case '\\', '"':
+				value.WriteByte(s[i])
+			default:
+				return nil, "", fmt.Errorf("value of label `%s` has invalid escape sequence", name)
+			}
+		}
+		if i >= len(s) {
+			return nil, "", errors.New("unterminated label value")
+		}
+		labels[name] = value.String()
+
+		s = strings.TrimLeft(s[i+1:], " \t")
+		if s != "" && s[0] == ',' {
+			s = s[1:]
+		} else if s != "" && s[0] != '}' {
+			return nil, "", errors.New("labels must be separated by comma")
+		}
+	}
+}
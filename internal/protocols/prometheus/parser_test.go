@@ -0,0 +1,70 @@
+package prometheus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/protocols"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	payload := `# HELP heap_alloc Allocated heap bytes.
+# TYPE heap_alloc gauge
+heap_alloc 1.5e3
+heap_alloc{host="a",path="/x\"y\\z"} 2 1700000000000
+# TYPE requests counter
+requests_total{code="200",} 10
+requests 3
+# TYPE latency histogram
+latency_bucket{le="+Inf"} 3
+untyped_metric 1
+heap_alloc{host="a" 1
+heap_alloc abc
+requests 1.5
+heap_alloc{1host="a"} 1
+# EOF
+heap_alloc 100
+`
+	var report protocols.Report
+	list, err := Parse(strings.NewReader(payload), &report)
+	require.NoError(t, err)
+
+	require.Len(t, list, 4)
+	assert.Equal(t, metrics.Metrics{ID: "heap_alloc", MType: metrics.Gauge, Value: list[0].Value}, list[0])
+	assert.Equal(t, 1500.0, *list[0].Value)
+	assert.Equal(t, metrics.Labels{"host": "a", "path": `/x"y\z`}, list[1].Labels)
+	assert.Equal(t, "requests_total", list[2].ID)
+	assert.Equal(t, metrics.Counter, list[2].MType)
+	assert.Equal(t, int64(10), *list[2].Delta)
+	assert.Equal(t, metrics.Labels{"code": "200"}, list[2].Labels)
+	assert.Equal(t, int64(3), *list[3].Delta)
+
+	lines := make([]int, 0, len(report.Rejected))
+	for _, rejected := range report.Rejected {
+		lines = append(lines, rejected.Line)
+	}
+	assert.Equal(t, []int{9, 10, 11, 12, 13, 14}, lines)
+}
+
+func TestParseExposition(t *testing.T) {
+	gauge := 0.25
+	counter := int64(7)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, []metrics.Metrics{
+		{ID: "load", MType: metrics.Gauge, Value: &gauge, Labels: metrics.Labels{"cpu": "0"}},
+		{ID: "polls", MType: metrics.Counter, Delta: &counter},
+	}))
+
+	var report protocols.Report
+	list, err := Parse(&buf, &report)
+	require.NoError(t, err)
+	assert.Empty(t, report.Rejected)
+	require.Len(t, list, 2)
+	assert.Equal(t, gauge, *list[0].Value)
+	assert.Equal(t, counter, *list[1].Delta)
+}
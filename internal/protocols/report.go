@@ -0,0 +1,19 @@
+// Package protocols contains the types shared by the parsers of third-party metric formats.
+package protocols
+
+// LineError a rejected line of the ingested payload.
+type LineError struct {
+	Line   int    `json:"line"`  // номер строки, начиная с 1
+	Reason string `json:"error"` // причина отклонения строки
+}
+
+// Report the result of ingesting a payload.
+type Report struct {
+	Accepted int         `json:"accepted"` // количество принятых метрик
+	Rejected []LineError `json:"rejected"` // отклонённые строки
+}
+
+// Reject records the line as rejected with the reason taken from err.
+func (r *Report) Reject(line int, err error) {
+	r.Rejected = append(r.Rejected, LineError{Line: line, Reason: err.Error()})
+}
@@ -0,0 +1,102 @@
+package statsd
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+)
+
+type gaugeUpdate struct {
+	metric   metrics.Metrics
+	value    float64
+	relative bool
+}
+
+type counterUpdate struct {
+	metric metrics.Metrics
+	value  float64
+}
+
+// Aggregator accumulates StatsD updates within a flush window.
+//
+// Counter increments of a series are summed, gauges keep the last absolute value
+// with relative changes applied on top of it. A relative change of a gauge that was
+// not set within the window is applied to the value currently in the storage.
+type Aggregator struct {
+	mu       sync.Mutex
+	counters map[string]*counterUpdate
+	gauges   map[string]*gaugeUpdate
+}
+
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		counters: make(map[string]*counterUpdate),
+		gauges:   make(map[string]*gaugeUpdate),
+	}
+}
+
+// Add adds the update to the current window.
+func (a *Aggregator) Add(u *Update) {
+	m := metrics.Metrics{ID: u.Name, MType: u.MType, Labels: u.Labels}
+	key := m.SeriesKey()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch u.MType {
+	case metrics.Counter:
+		c, ok := a.counters[key]
+		if !ok {
+			c = &counterUpdate{metric: m}
+			a.counters[key] = c
+		}
+		c.value += u.Value
+	case metrics.Gauge:
+		g, ok := a.gauges[key]
+		if !ok || !u.Relative {
+			a.gauges[key] = &gaugeUpdate{metric: m, value: u.Value, relative: u.Relative}
+			return
+		}
+		g.value += u.Value
+	}
+}
+
+// Flush stores the aggregated window through MetricStorage.BulkAdd and starts a new window.
+func (a *Aggregator) Flush(ctx context.Context, store repositories.MetricStorage) error {
+	a.mu.Lock()
+	counters, gauges := a.counters, a.gauges
+	a.counters = make(map[string]*counterUpdate)
+	a.gauges = make(map[string]*gaugeUpdate)
+	a.mu.Unlock()
+
+	metricList := make([]metrics.Metrics, 0, len(counters)+len(gauges))
+	for _, c := range counters {
+		delta := int64(math.Round(c.value))
+		if delta == 0 {
+			continue
+		}
+		m := c.metric
+		m.Delta = &delta
+		metricList = append(metricList, m)
+	}
+	for _, g := range gauges {
+		m := g.metric
+		value := g.value
+		if g.relative {
+			current := m
+			if err := store.Get(ctx, &current); err == nil && current.Value != nil {
+				value += *current.Value
+			}
+		}
+		m.Value = &value
+		metricList = append(metricList, m)
+	}
+
+	if len(metricList) == 0 {
+		return nil
+	}
+	return store.BulkAdd(ctx, metricList)
+}
@@ -0,0 +1,48 @@
+package statsd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregatorFlush(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewMemStorage()
+
+	stored := 10.0
+	require.NoError(t, store.Add(ctx, metrics.Metrics{ID: "queue", MType: metrics.Gauge, Value: &stored}))
+
+	a := NewAggregator()
+	for _, line := range []string{
+		"requests:1|c",
+		"requests:1|c|@0.5",
+		"temp:20|g",
+		"temp:+5|g",
+		"temp:-1|g",
+		"queue:-3|g",
+		"errors:1|c|#host:a",
+	} {
+		u, err := ParseLine(line)
+		require.NoError(t, err)
+		a.Add(u)
+	}
+	require.NoError(t, a.Flush(ctx, store))
+
+	get := func(m metrics.Metrics) metrics.Metrics {
+		require.NoError(t, store.Get(ctx, &m))
+		return m
+	}
+	assert.Equal(t, int64(3), *get(metrics.Metrics{ID: "requests", MType: metrics.Counter}).Delta)
+	assert.Equal(t, 24.0, *get(metrics.Metrics{ID: "temp", MType: metrics.Gauge}).Value)
+	assert.Equal(t, 7.0, *get(metrics.Metrics{ID: "queue", MType: metrics.Gauge}).Value)
+	assert.Equal(t, int64(1), *get(metrics.Metrics{ID: "errors", MType: metrics.Counter, Labels: metrics.Labels{"host": "a"}}).Delta)
+
+	// the window is reset after flush
+	require.NoError(t, a.Flush(ctx, store))
+	assert.Equal(t, int64(3), *get(metrics.Metrics{ID: "requests", MType: metrics.Counter}).Delta)
+}
@@ -0,0 +1,89 @@
+// Package statsd implements parsing and aggregation of the StatsD protocol.
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+// Update a single parsed StatsD line.
+type Update struct {
+	Name       string             // имя метрики
+	Labels     metrics.Labels     // метки из тегов DogStatsD
+	MType      metrics.MetricType // тип метрики
+	Value      float64            // значение, для counter уже поделено на частоту выборки
+	Relative   bool               // относительное изменение gauge (`+`/`-` перед значением)
+	SampleRate float64            // частота выборки, 1 если не задана
+}
+
+// ParseLine parses a `name:value|type[|@rate][|#tags]` line, only `c` and `g` types are supported.
+func ParseLine(line string) (*Update, error) {
+	head, tail, ok := strings.Cut(line, "|")
+	colon := strings.LastIndexByte(head, ':')
+	if !ok || colon <= 0 {
+		return nil, errors.New("line must be in `name:value|type` format")
+	}
+
+	u := &Update{Name: head[:colon], SampleRate: 1}
+	rawValue := head[colon+1:]
+	parts := strings.Split(tail, "|")
+	rawType := parts[0]
+
+	for _, part := range parts[1:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("sample rate `%s` is not valid", part[1:])
+			}
+			u.SampleRate = rate
+		case strings.HasPrefix(part, "#"):
+			labels, err := parseTags(part[1:])
+			if err != nil {
+				return nil, err
+			}
+			u.Labels = labels
+		default:
+			return nil, fmt.Errorf("unknown line section `%s`", part)
+		}
+	}
+
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("value `%s` is not valid", rawValue)
+	}
+
+	switch rawType {
+	case "c":
+		u.MType = metrics.Counter
+		u.Value = value / u.SampleRate
+	case "g":
+		u.MType = metrics.Gauge
+		u.Value = value
+		u.Relative = strings.HasPrefix(rawValue, "+") || strings.HasPrefix(rawValue, "-")
+	default:
+		return nil, fmt.Errorf("metric type `%s` is not supported", rawType)
+	}
+	return u, nil
+}
+
+// parseTags parses the DogStatsD `key:value,key2:value2` tags into labels.
+func parseTags(s string) (metrics.Labels, error) {
+	labels := make(metrics.Labels)
+	for _, tag := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("tag `%s` must be in key:value format", tag)
+		}
+		labels[key] = value
+	}
+	if err := labels.Validate(); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
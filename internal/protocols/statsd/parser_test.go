@@ -0,0 +1,54 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLine(t *testing.T) {
+	var testTable = []struct {
+		line     string
+		expected *Update
+		wantErr  bool
+	}{
+		{
+			line:     "requests:1|c",
+			expected: &Update{Name: "requests", MType: metrics.Counter, Value: 1, SampleRate: 1},
+		},
+		{
+			line:     "requests:2|c|@0.5",
+			expected: &Update{Name: "requests", MType: metrics.Counter, Value: 4, SampleRate: 0.5},
+		},
+		{
+			line:     "temp:21.5|g",
+			expected: &Update{Name: "temp", MType: metrics.Gauge, Value: 21.5, SampleRate: 1},
+		},
+		{
+			line:     "temp:-1.5|g|#host:a",
+			expected: &Update{Name: "temp", MType: metrics.Gauge, Value: -1.5, Relative: true, SampleRate: 1, Labels: metrics.Labels{"host": "a"}},
+		},
+		{
+			line:     "api.v1:lat:+2|g",
+			expected: &Update{Name: "api.v1:lat", MType: metrics.Gauge, Value: 2, Relative: true, SampleRate: 1},
+		},
+		{line: "latency:320|ms", wantErr: true},
+		{line: "requests:1|c|@2", wantErr: true},
+		{line: "requests:abc|c", wantErr: true},
+		{line: "requests|c", wantErr: true},
+		{line: ":1|c", wantErr: true},
+		{line: "requests:1|c|#1host:a", wantErr: true},
+	}
+	for _, v := range testTable {
+		t.Run(v.line, func(t *testing.T) {
+			u, err := ParseLine(v.line)
+			if v.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, v.expected, u)
+		})
+	}
+}
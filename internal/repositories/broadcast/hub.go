@@ -0,0 +1,105 @@
+// Package broadcast publishes accepted metric updates to live subscribers.
+package broadcast
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+// SubscriberBuffer the number of updates buffered for a subscriber before it is dropped.
+const SubscriberBuffer = 256
+
+type subscriber struct {
+	ch     chan metrics.Metrics
+	nameRe *regexp.Regexp
+	types  []metrics.MetricType
+}
+
+func (s *subscriber) matches(m *metrics.Metrics) bool {
+	if s.nameRe != nil && !s.nameRe.MatchString(m.ID) {
+		return false
+	}
+	return len(s.types) == 0 || slices.Contains(s.types, m.MType)
+}
+
+// Hub delivers published updates to subscribers without blocking the publisher,
+// a subscriber whose buffer is full is dropped and its channel is closed.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*subscriber]struct{})}
+}
+
+// Subscribe registers a subscriber for the updates matching the name and type filter of the query.
+func (h *Hub) Subscribe(ctx context.Context, q metrics.Query) (<-chan metrics.Metrics, error) {
+	q.Limit, q.Cursor = 0, ""
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	s := &subscriber{ch: make(chan metrics.Metrics, SubscriberBuffer), types: q.Types}
+	if pattern := q.NameRegexp(); pattern != "" {
+		s.nameRe = regexp.MustCompile(pattern)
+	}
+
+	h.mu.Lock()
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.remove(s)
+	}()
+
+	return s.ch, nil
+}
+
+// remove unregisters the subscriber and closes its channel, repeated calls are ignored.
+func (h *Hub) remove(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[s]; ok {
+		delete(h.subscribers, s)
+		close(s.ch)
+	}
+}
+
+// Publish delivers the updates to the matching subscribers.
+func (h *Hub) Publish(updates ...metrics.Metrics) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subscribers {
+		for i := range updates {
+			if !s.matches(&updates[i]) {
+				continue
+			}
+			select {
+			case s.ch <- updates[i]:
+			default:
+				// the subscriber does not keep up, drop it instead of blocking the writer
+				delete(h.subscribers, s)
+				close(s.ch)
+			}
+			if _, ok := h.subscribers[s]; !ok {
+				break
+			}
+		}
+	}
+}
+
+// Len returns the number of active subscribers.
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subscribers)
+}
@@ -0,0 +1,112 @@
+package broadcast
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubFilter(t *testing.T) {
+	hub := NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	updates, err := hub.Subscribe(ctx, metrics.Query{Name: "cpu*", Types: []metrics.MetricType{metrics.Gauge}})
+	require.NoError(t, err)
+
+	value := 1.0
+	delta := int64(1)
+	hub.Publish(
+		metrics.Metrics{ID: "cpu0", MType: metrics.Gauge, Value: &value},
+		metrics.Metrics{ID: "cpu0", MType: metrics.Counter, Delta: &delta},
+		metrics.Metrics{ID: "mem", MType: metrics.Gauge, Value: &value},
+	)
+
+	m := <-updates
+	assert.Equal(t, "cpu0", m.ID)
+	assert.Equal(t, metrics.Gauge, m.MType)
+	assert.Empty(t, updates)
+
+	cancel()
+	_, ok := <-updates
+	assert.False(t, ok, "channel must be closed after unsubscribe")
+	assert.Eventually(t, func() bool { return hub.Len() == 0 }, time.Second, time.Millisecond)
+
+	_, err = hub.Subscribe(context.Background(), metrics.Query{Types: []metrics.MetricType{"timer"}})
+	assert.Error(t, err)
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	hub := NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slow, err := hub.Subscribe(ctx, metrics.Query{})
+	require.NoError(t, err)
+
+	value := 1.0
+	for i := 0; i <= SubscriberBuffer; i++ {
+		hub.Publish(metrics.Metrics{ID: "load", MType: metrics.Gauge, Value: &value})
+	}
+	assert.Equal(t, 0, hub.Len())
+
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, SubscriberBuffer, received)
+}
+
+func TestBroadcastMetricWrapper(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wrapper := NewBroadcastMetricWrapper(memory.NewMemStorage(), NewHub())
+	updates, err := wrapper.Subscribe(ctx, metrics.Query{})
+	require.NoError(t, err)
+
+	value := 1.0
+	delta := int64(2)
+	require.NoError(t, wrapper.Add(ctx, metrics.Metrics{ID: "load", MType: metrics.Gauge, Value: &value}))
+	require.NoError(t, wrapper.BulkAdd(ctx, []metrics.Metrics{{ID: "hits", MType: metrics.Counter, Delta: &delta}}))
+	require.NoError(t, wrapper.Add(ctx, metrics.Metrics{ID: "latency", MType: metrics.Histogram, Histogram: metrics.NewHistogramValue(1)}))
+	// merge with mismatched bounds fails and is not published
+	assert.Error(t, wrapper.Add(ctx, metrics.Metrics{ID: "latency", MType: metrics.Histogram, Histogram: metrics.NewHistogramValue(2)}))
+
+	assert.Equal(t, "load", (<-updates).ID)
+	assert.Equal(t, "hits", (<-updates).ID)
+	assert.Equal(t, "latency", (<-updates).ID)
+	assert.Empty(t, updates)
+}
+
+func TestBroadcastMetricWrapperBulkAddOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := memory.NewMemStorage()
+	wrapper := NewBroadcastMetricWrapper(store, NewHub())
+	updates, err := wrapper.Subscribe(ctx, metrics.Query{})
+	require.NoError(t, err)
+
+	delta := int64(2)
+	batch := []metrics.Metrics{{ID: "hits", MType: metrics.Counter, Delta: &delta}}
+
+	_, err = wrapper.BulkAddOnce(ctx, "batch", batch)
+	assert.ErrorIs(t, err, repositories.ErrIdempotencyDisabled)
+
+	store.EnableIdempotency(time.Minute, 10)
+	for i := 0; i < 2; i++ {
+		applied, err := wrapper.BulkAddOnce(ctx, "batch", batch)
+		require.NoError(t, err)
+		assert.Equal(t, i == 0, applied)
+	}
+
+	// the repeated batch is not published
+	assert.Equal(t, "hits", (<-updates).ID)
+	assert.Empty(t, updates)
+}
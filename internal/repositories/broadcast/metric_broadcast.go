@@ -0,0 +1,76 @@
+package broadcast
+
+import (
+	"context"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+)
+
+// BroadcastMetricWrapper wraps the storage and publishes every successfully stored update to the hub.
+type BroadcastMetricWrapper struct {
+	ms  repositories.MetricStorage
+	hub *Hub
+}
+
+func NewBroadcastMetricWrapper(metricStorage repositories.MetricStorage, hub *Hub) *BroadcastMetricWrapper {
+	return &BroadcastMetricWrapper{ms: metricStorage, hub: hub}
+}
+
+func (wrapper *BroadcastMetricWrapper) Add(ctx context.Context, m metrics.Metrics) error {
+	if err := wrapper.ms.Add(ctx, m); err != nil {
+		return err
+	}
+	wrapper.hub.Publish(m)
+	return nil
+}
+
+func (wrapper *BroadcastMetricWrapper) BulkAdd(ctx context.Context, metricList []metrics.Metrics) error {
+	if err := wrapper.ms.BulkAdd(ctx, metricList); err != nil {
+		return err
+	}
+	wrapper.hub.Publish(metricList...)
+	return nil
+}
+
+// BulkAddOnce publishes the batch only when it is applied, a repeated batch is not published again.
+func (wrapper *BroadcastMetricWrapper) BulkAddOnce(ctx context.Context, key string, metricList []metrics.Metrics) (bool, error) {
+	is, ok := wrapper.ms.(repositories.IdempotentStorage)
+	if !ok {
+		return false, repositories.ErrIdempotencyDisabled
+	}
+	applied, err := is.BulkAddOnce(ctx, key, metricList)
+	if err != nil || !applied {
+		return applied, err
+	}
+	wrapper.hub.Publish(metricList...)
+	return true, nil
+}
+
+func (wrapper *BroadcastMetricWrapper) Get(ctx context.Context, metric *metrics.Metrics) error {
+	return wrapper.ms.Get(ctx, metric)
+}
+
+func (wrapper *BroadcastMetricWrapper) List(ctx context.Context) ([]metrics.Metrics, error) {
+	return wrapper.ms.List(ctx)
+}
+
+func (wrapper *BroadcastMetricWrapper) Query(ctx context.Context, q metrics.Query) (metrics.Page, error) {
+	return wrapper.ms.Query(ctx, q)
+}
+
+func (wrapper *BroadcastMetricWrapper) Ping(ctx context.Context) bool {
+	return wrapper.ms.Ping(ctx)
+}
+
+func (wrapper *BroadcastMetricWrapper) History(ctx context.Context, m metrics.Metrics, from, to time.Time) ([]metrics.Sample, error) {
+	if hs, ok := wrapper.ms.(repositories.HistoryStorage); ok {
+		return hs.History(ctx, m, from, to)
+	}
+	return nil, repositories.ErrHistoryDisabled
+}
+
+func (wrapper *BroadcastMetricWrapper) Subscribe(ctx context.Context, q metrics.Query) (<-chan metrics.Metrics, error) {
+	return wrapper.hub.Subscribe(ctx, q)
+}
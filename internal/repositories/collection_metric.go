@@ -8,8 +8,12 @@ import (
 
 //go:generate minimock -i github.com/screamsoul/go-metrics-tpl/internal/repositories.CollectionMetric -o ./mocks/collection_metric_mock.go -g
 type CollectionMetric interface {
-	Update()
-	UpdateRuntime()
-	UpdateGopsutil()
+	// BulkAdd stores the collected metrics, the gauges are replaced and the counter deltas are added.
+	BulkAdd(ctx context.Context, metricList []metrics.Metrics) error
 	List(ctx context.Context) ([]metrics.Metrics, error)
+	// Take returns the current gauges and the counter deltas accumulated since the previous take,
+	// the taken deltas are reset, so every delta is sent by a single sender.
+	Take(ctx context.Context) ([]metrics.Metrics, error)
+	// Restore adds back the counter deltas of a batch that has not been delivered.
+	Restore(ctx context.Context, m []metrics.Metrics)
 }
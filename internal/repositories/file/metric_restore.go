@@ -114,6 +114,10 @@ func (wrapper *FileRestoreMetricWrapper) List(ctx context.Context) ([]metrics.Me
 	return wrapper.ms.List(ctx)
 }
 
+func (wrapper *FileRestoreMetricWrapper) Query(ctx context.Context, q metrics.Query) (metrics.Page, error) {
+	return wrapper.ms.Query(ctx, q)
+}
+
 func (wrapper *FileRestoreMetricWrapper) Add(ctx context.Context, m metrics.Metrics) error {
 	err := wrapper.ms.Add(ctx, m)
 
@@ -124,6 +128,14 @@ func (wrapper *FileRestoreMetricWrapper) Add(ctx context.Context, m metrics.Metr
 	return err
 }
 
+func (wrapper *FileRestoreMetricWrapper) History(ctx context.Context, m metrics.Metrics, from, to time.Time) ([]metrics.Sample, error) {
+	hs, ok := wrapper.ms.(repositories.HistoryStorage)
+	if !ok {
+		return nil, repositories.ErrHistoryDisabled
+	}
+	return hs.History(ctx, m, from, to)
+}
+
 func (wrapper *FileRestoreMetricWrapper) Ping(ctx context.Context) bool {
 	return wrapper.ms.Ping(ctx)
 }
@@ -131,3 +143,11 @@ func (wrapper *FileRestoreMetricWrapper) Ping(ctx context.Context) bool {
 func (wrapper *FileRestoreMetricWrapper) BulkAdd(ctx context.Context, metricList []metrics.Metrics) error {
 	return wrapper.ms.BulkAdd(ctx, metricList)
 }
+
+func (wrapper *FileRestoreMetricWrapper) BulkAddOnce(ctx context.Context, key string, metricList []metrics.Metrics) (bool, error) {
+	is, ok := wrapper.ms.(repositories.IdempotentStorage)
+	if !ok {
+		return false, repositories.ErrIdempotencyDisabled
+	}
+	return is.BulkAddOnce(ctx, key, metricList)
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/gojuno/minimock/v3"
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
 	"github.com/screamsoul/go-metrics-tpl/internal/repositories/file"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -126,3 +128,31 @@ func TestListMetricSuccessfully(t *testing.T) {
 
 	assert.NoError(t, err)
 }
+
+// The names with the characters escaped in the series keys survive the save and restore cycles unchanged.
+func TestSaveLoadEscapedNames(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	names := []string{`a{b`, `c\d`}
+
+	store := memory.NewMemStorage()
+	for _, name := range names {
+		value := 1.5
+		require.NoError(t, store.Add(ctx, metrics.Metrics{ID: name, MType: metrics.Gauge, Value: &value}))
+	}
+
+	for i := 0; i < 2; i++ {
+		file.NewFileRestoreMetricWrapper(ctx, store, path, 0, false).Save(ctx)
+
+		store = memory.NewMemStorage()
+		file.NewFileRestoreMetricWrapper(ctx, store, path, 0, true)
+
+		list, err := store.List(ctx)
+		require.NoError(t, err)
+		listed := make([]string, 0, len(list))
+		for _, m := range list {
+			listed = append(listed, m.ID)
+		}
+		assert.ElementsMatch(t, names, listed)
+	}
+}
@@ -0,0 +1,19 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+// ErrHistoryDisabled is returned when the repository does not keep a history of updates.
+var ErrHistoryDisabled = errors.New("metric history is disabled")
+
+// HistoryStorage is an interface for a repository that appends every accepted update as a timestamped sample.
+type HistoryStorage interface {
+	// History returns samples of the series identified by name, type and labels
+	// in the [from, to] interval ordered by time, zero bounds are not applied.
+	History(ctx context.Context, m metrics.Metrics, from, to time.Time) ([]metrics.Sample, error)
+}
@@ -0,0 +1,18 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+// ErrIdempotencyDisabled is returned when the repository does not remember the keys of the applied batches.
+var ErrIdempotencyDisabled = errors.New("idempotent updates are disabled")
+
+// IdempotentStorage is an interface for a repository that applies a batch of updates at most once per key.
+type IdempotentStorage interface {
+	// BulkAddOnce stores the batch and remembers its key in one step. A batch with a remembered key
+	// is not stored again and applied is false. The key is not remembered if storing fails.
+	BulkAddOnce(ctx context.Context, key string, m []metrics.Metrics) (applied bool, err error)
+}
@@ -1,14 +1,12 @@
 package memory
 
 import (
-	"fmt"
-	"runtime"
-	"time"
+	"context"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
 )
 
+// CollectionMetricStorage accumulates the metrics of the agent collectors between the reports.
 type CollectionMetricStorage struct {
 	MemStorage
 }
@@ -19,66 +17,40 @@ func NewCollectionMetricStorage() *CollectionMetricStorage {
 	}
 }
 
-func (collection *CollectionMetricStorage) Update() {
+// Take returns the current gauges and the nonzero counter deltas accumulated since the previous take
+// and resets the deltas.
+func (collection *CollectionMetricStorage) Take(ctx context.Context) ([]metrics.Metrics, error) {
 	collection.Lock()
 	defer collection.Unlock()
 
-	collection.gauge["RandomValue"] = float64(time.Now().UnixNano()) / float64(time.Second)
-	collection.counter["PollCount"]++
-}
-
-func (collection *CollectionMetricStorage) UpdateRuntime() {
-	collection.Lock()
-	defer collection.Unlock()
-
-	var mem runtime.MemStats
-	runtime.ReadMemStats(&mem)
-	collection.gauge["Alloc"] = float64(mem.Alloc)
-	collection.gauge["BuckHashSys"] = float64(mem.BuckHashSys)
-	collection.gauge["Frees"] = float64(mem.Frees)
-	collection.gauge["GCCPUFraction"] = mem.GCCPUFraction
-	collection.gauge["GCSys"] = float64(mem.GCSys)
-	collection.gauge["HeapAlloc"] = float64(mem.HeapAlloc)
-	collection.gauge["HeapIdle"] = float64(mem.HeapIdle)
-	collection.gauge["HeapInuse"] = float64(mem.HeapInuse)
-	collection.gauge["HeapObjects"] = float64(mem.HeapObjects)
-	collection.gauge["HeapReleased"] = float64(mem.HeapReleased)
-	collection.gauge["HeapSys"] = float64(mem.HeapSys)
-	collection.gauge["LastGC"] = float64(mem.LastGC)
-	collection.gauge["Lookups"] = float64(mem.Lookups)
-	collection.gauge["MCacheInuse"] = float64(mem.MCacheInuse)
-	collection.gauge["MCacheSys"] = float64(mem.MCacheSys)
-	collection.gauge["MSpanInuse"] = float64(mem.MSpanInuse)
-	collection.gauge["MSpanSys"] = float64(mem.MSpanSys)
-	collection.gauge["Mallocs"] = float64(mem.Mallocs)
-	collection.gauge["NextGC"] = float64(mem.NextGC)
-	collection.gauge["NumForcedGC"] = float64(mem.NumForcedGC)
-	collection.gauge["NumGC"] = float64(mem.NumGC)
-	collection.gauge["OtherSys"] = float64(mem.OtherSys)
-	collection.gauge["PauseTotalNs"] = float64(mem.PauseTotalNs)
-	collection.gauge["StackInuse"] = float64(mem.StackInuse)
-	collection.gauge["StackSys"] = float64(mem.StackSys)
-	collection.gauge["Sys"] = float64(mem.Sys)
-	collection.gauge["TotalAlloc"] = float64(mem.TotalAlloc)
+	list := make([]metrics.Metrics, 0, len(collection.gauge)+len(collection.counter))
+	for k, v := range collection.gauge {
+		m := collection.newMetric(k, metrics.Gauge)
+		m.Value = &v
+		list = append(list, m)
+	}
+	for k, v := range collection.counter {
+		if v == 0 {
+			continue
+		}
+		m := collection.newMetric(k, metrics.Counter)
+		m.Delta = &v
+		list = append(list, m)
+	}
+	clear(collection.counter)
+	return list, nil
 }
 
-func (collection *CollectionMetricStorage) UpdateGopsutil() {
+// Restore adds the counter deltas of the batch back to the deltas accumulated since it was taken.
+func (collection *CollectionMetricStorage) Restore(ctx context.Context, list []metrics.Metrics) {
 	collection.Lock()
 	defer collection.Unlock()
 
-	memory, err := mem.VirtualMemory()
-	if err != nil {
-		return
-	}
-
-	collection.gauge["TotalMemory"] = float64(memory.Total)
-	collection.gauge["FreeMemory"] = float64(memory.Free)
-
-	cpuPercents, err := cpu.Percent(0, false)
-	if err != nil {
-		return
-	}
-	for i, percent := range cpuPercents {
-		collection.gauge[fmt.Sprintf("CPUutilization%d", i+1)] = percent
+	for _, m := range list {
+		if m.MType == metrics.Counter && m.Delta != nil {
+			key := m.SeriesKey()
+			collection.track(key, m)
+			collection.counter[key] += *m.Delta
+		}
 	}
 }
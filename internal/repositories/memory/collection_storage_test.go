@@ -1,65 +1,60 @@
 package memory
 
 import (
-	"fmt"
-	"math"
-	"runtime"
+	"context"
 	"testing"
 
-	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestUpdate(t *testing.T) {
-	collection := NewCollectionMetricStorage()
-	initialCount := collection.counter["PollCount"]
-
-	collection.Update()
-
-	if collection.counter["PollCount"] != initialCount+1 {
-		t.Errorf("expected PollCount to be %d, got %d", initialCount+1, collection.counter["PollCount"])
-	}
+// poll stores the metrics of a single poll of the random collector.
+func poll(t *testing.T, collection *CollectionMetricStorage) {
+	t.Helper()
+	delta, value := int64(1), 0.5
+	require.NoError(t, collection.BulkAdd(context.Background(), []metrics.Metrics{
+		{ID: "PollCount", MType: metrics.Counter, Delta: &delta},
+		{ID: "RandomValue", MType: metrics.Gauge, Value: &value},
+	}))
 }
 
-func AbsPercentageChange[T ~int | ~float64](old, new T) (delta float64) {
-	diff := float64(new - old)
-	delta = (diff / float64(old)) * 100
-	delta = math.Abs(delta)
-	return
-}
-
-func TestUpdateRuntime(t *testing.T) {
+func TestTakeAndRestore(t *testing.T) {
+	ctx := context.Background()
 	collection := NewCollectionMetricStorage()
 
-	collection.UpdateRuntime()
-
-	var mem runtime.MemStats
-	runtime.ReadMemStats(&mem)
-
-	if AbsPercentageChange(collection.gauge["Alloc"], float64(mem.Alloc)) > 20 {
-		t.Errorf("Expected Alloc to be %v, got %v", float64(mem.Alloc), collection.gauge["Alloc"])
+	pollCount := func(list []metrics.Metrics) int64 {
+		for _, m := range list {
+			if m.ID == "PollCount" && m.MType == metrics.Counter {
+				return *m.Delta
+			}
+		}
+		return 0
 	}
-}
-
-func TestUpdateGopsutil(t *testing.T) {
-	collection := NewCollectionMetricStorage()
 
-	collection.UpdateGopsutil()
+	poll(t, collection)
+	poll(t, collection)
 
-	memory, err := mem.VirtualMemory()
+	batch, err := collection.Take(ctx)
 	require.NoError(t, err)
+	assert.Equal(t, int64(2), pollCount(batch))
+	assert.Contains(t, collection.gauge, "RandomValue")
 
-	assert.True(
-		t,
-		AbsPercentageChange(collection.gauge["TotalMemory"], float64(memory.Total)) < 20,
-		fmt.Sprintf("Expected TotalMemory to be %v, got %v", float64(memory.Total), collection.gauge["TotalMemory"]),
-	)
+	// the taken deltas are not sent again, the gauges are
+	poll(t, collection)
+	next, err := collection.Take(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pollCount(next))
 
-	assert.True(
-		t,
-		AbsPercentageChange(collection.gauge["FreeMemory"], float64(memory.Free)) < 20,
-		fmt.Sprintf("Expected FreeMemory to be %v, got %v", float64(memory.Free), collection.gauge["FreeMemory"]),
-	)
+	empty, err := collection.Take(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pollCount(empty))
+	assert.Len(t, empty, 1)
 
+	// the undelivered deltas are added to the new ones
+	collection.Restore(ctx, batch)
+	poll(t, collection)
+	restored, err := collection.Take(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), pollCount(restored))
 }
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+// sampleRing a bounded ring buffer of series samples, the oldest sample is overwritten when full.
+type sampleRing struct {
+	samples []metrics.Sample
+	start   int
+	size    int
+}
+
+func newSampleRing(capacity int) *sampleRing {
+	return &sampleRing{samples: make([]metrics.Sample, capacity)}
+}
+
+func (r *sampleRing) push(s metrics.Sample) {
+	end := (r.start + r.size) % len(r.samples)
+	r.samples[end] = s
+
+	if r.size < len(r.samples) {
+		r.size++
+	} else {
+		r.start = (r.start + 1) % len(r.samples)
+	}
+}
+
+// between returns the samples in the [from, to] interval, zero bounds are not applied.
+func (r *sampleRing) between(from, to time.Time) []metrics.Sample {
+	result := make([]metrics.Sample, 0, r.size)
+	for i := 0; i < r.size; i++ {
+		s := r.samples[(r.start+i)%len(r.samples)]
+		if !from.IsZero() && s.Timestamp.Before(from) {
+			continue
+		}
+		if !to.IsZero() && s.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
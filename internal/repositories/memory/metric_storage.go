@@ -3,25 +3,107 @@ package memory
 import (
 	"context"
 	"errors"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"github.com/screamsoul/go-metrics-tpl/pkg/idempotency"
 	"github.com/screamsoul/go-metrics-tpl/pkg/logging"
 	"go.uber.org/zap"
 )
 
+// series identity of the stored values, the value maps are keyed by metrics.Metrics.SeriesKey.
+type series struct {
+	name   string
+	labels metrics.Labels
+}
+
 type MemStorage struct {
 	sync.Mutex
-	gauge   map[string]float64
-	counter map[string]int64
-	logger  *zap.Logger
+	gauge     map[string]float64
+	counter   map[string]int64
+	histogram map[string]*metrics.HistogramValue
+	series    map[string]series
+	logger    *zap.Logger
+
+	historySize int
+	history     map[string]*sampleRing
+
+	batchKeys *idempotency.Cache
 }
 
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
-		counter: make(map[string]int64),
-		gauge:   make(map[string]float64),
-		logger:  logging.GetLogger(),
+		counter:   make(map[string]int64),
+		gauge:     make(map[string]float64),
+		histogram: make(map[string]*metrics.HistogramValue),
+		series:    make(map[string]series),
+		logger:    logging.GetLogger(),
+		history:   make(map[string]*sampleRing),
+	}
+}
+
+// EnableHistory turns on keeping the last size accepted updates of every series.
+func (db *MemStorage) EnableHistory(size int) {
+	db.Lock()
+	defer db.Unlock()
+
+	db.historySize = size
+}
+
+// EnableIdempotency turns on remembering the keys of the applied batches for ttl, at most size keys.
+func (db *MemStorage) EnableIdempotency(ttl time.Duration, size int) {
+	db.Lock()
+	defer db.Unlock()
+
+	db.batchKeys = idempotency.NewCache(ttl, size)
+}
+
+func historyKey(mType metrics.MetricType, key string) string {
+	return string(mType) + ":" + key
+}
+
+// appendSample records the current value of the series into its history.
+func (db *MemStorage) appendSample(key string, mType metrics.MetricType) {
+	if db.historySize <= 0 {
+		return
+	}
+
+	sample := metrics.Sample{Timestamp: time.Now()}
+	switch mType {
+	case metrics.Gauge:
+		v := db.gauge[key]
+		sample.Value = &v
+	case metrics.Counter:
+		v := db.counter[key]
+		sample.Delta = &v
+	case metrics.Histogram:
+		sample.Histogram = db.histogram[key].Copy()
+	default:
+		return
+	}
+
+	hKey := historyKey(mType, key)
+	ring, ok := db.history[hKey]
+	if !ok {
+		ring = newSampleRing(db.historySize)
+		db.history[hKey] = ring
+	}
+	ring.push(sample)
+}
+
+// newMetric restores the metric identity by the series key recorded by track.
+func (db *MemStorage) newMetric(key string, mType metrics.MetricType) metrics.Metrics {
+	s := db.series[key]
+	return metrics.Metrics{ID: s.name, MType: mType, Labels: s.labels.Copy()}
+}
+
+// track records the identity of the series, the key is escaped and cannot be turned back into the name.
+func (db *MemStorage) track(key string, m metrics.Metrics) {
+	if _, ok := db.series[key]; !ok {
+		db.series[key] = series{name: m.ID, labels: m.Labels.Copy()}
 	}
 }
 
@@ -29,51 +111,90 @@ func (db *MemStorage) Add(ctx context.Context, m metrics.Metrics) error {
 	db.Lock()
 	defer db.Unlock()
 
+	return db.add(m)
+}
+
+// add stores the metric, the caller holds the lock.
+func (db *MemStorage) add(m metrics.Metrics) error {
+	key := m.SeriesKey()
+	db.track(key, m)
+
 	switch m.MType {
 	case metrics.Gauge:
-		db.gauge[m.ID] = *m.Value
+		db.gauge[key] = *m.Value
 	case metrics.Counter:
-		db.counter[m.ID] += *m.Delta
+		db.counter[key] += *m.Delta
+	case metrics.Histogram:
+		h, ok := db.histogram[key]
+		if !ok {
+			db.histogram[key] = m.Histogram.Copy()
+		} else if err := h.Merge(m.Histogram); err != nil {
+			return err
+		}
 	}
+
+	db.appendSample(key, m.MType)
 	return nil
 }
 
 func (db *MemStorage) Get(ctx context.Context, metric *metrics.Metrics) error {
+	db.Lock()
+	defer db.Unlock()
+
+	key := metric.SeriesKey()
+
 	switch metric.MType {
 	case metrics.Gauge:
-		if v, ok := db.gauge[metric.ID]; ok {
+		if v, ok := db.gauge[key]; ok {
 			metric.Value = &v
 			return nil
 		}
 	case metrics.Counter:
-		if v, ok := db.counter[metric.ID]; ok {
+		if v, ok := db.counter[key]; ok {
 			metric.Delta = &v
 			return nil
 		}
+	case metrics.Histogram:
+		if v, ok := db.histogram[key]; ok {
+			metric.Histogram = v.Copy()
+			return nil
+		}
 	}
 
-	return errors.New("not found")
+	return repositories.ErrNotFound
 }
 
 func (db *MemStorage) List(ctx context.Context) ([]metrics.Metrics, error) {
-	metics := make([]metrics.Metrics, 0, len(db.counter)+len(db.gauge))
-	for n, v := range db.gauge {
-		metics = append(metics, metrics.Metrics{
-			ID:    n,
-			MType: metrics.Gauge,
-			Value: &v,
-		})
-	}
-	for n, v := range db.counter {
-		metics = append(metics, metrics.Metrics{
-			ID:    n,
-			MType: metrics.Counter,
-			Delta: &v,
-		})
+	db.Lock()
+	defer db.Unlock()
+
+	metics := make([]metrics.Metrics, 0, len(db.counter)+len(db.gauge)+len(db.histogram))
+	for k, v := range db.gauge {
+		m := db.newMetric(k, metrics.Gauge)
+		m.Value = &v
+		metics = append(metics, m)
+	}
+	for k, v := range db.counter {
+		m := db.newMetric(k, metrics.Counter)
+		m.Delta = &v
+		metics = append(metics, m)
+	}
+	for k, v := range db.histogram {
+		m := db.newMetric(k, metrics.Histogram)
+		m.Histogram = v.Copy()
+		metics = append(metics, m)
 	}
 	return metics, nil
 }
 
+func (db *MemStorage) Query(ctx context.Context, q metrics.Query) (metrics.Page, error) {
+	list, err := db.List(ctx)
+	if err != nil {
+		return metrics.Page{}, err
+	}
+	return q.Apply(list)
+}
+
 func (db *MemStorage) Ping(ctx context.Context) bool {
 	return true
 }
@@ -86,3 +207,70 @@ func (db *MemStorage) BulkAdd(ctx context.Context, metricList []metrics.Metrics)
 	}
 	return nil
 }
+
+func (db *MemStorage) BulkAddOnce(ctx context.Context, key string, metricList []metrics.Metrics) (bool, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if db.batchKeys == nil {
+		return false, repositories.ErrIdempotencyDisabled
+	}
+	if db.batchKeys.Contains(key) {
+		return false, nil
+	}
+	// the batch is applied entirely or not at all, so its retry does not add the counters twice
+	if err := db.check(metricList); err != nil {
+		return false, err
+	}
+	for _, metric := range metricList {
+		if err := db.add(metric); err != nil {
+			return false, err
+		}
+	}
+	db.batchKeys.Add(key)
+	return true, nil
+}
+
+// check verifies that every metric of the batch can be stored, the caller holds the lock.
+func (db *MemStorage) check(metricList []metrics.Metrics) error {
+	bounds := make(map[string][]float64)
+	for _, m := range metricList {
+		if err := m.ValidateValue(); err != nil {
+			return err
+		}
+		if m.MType != metrics.Histogram {
+			continue
+		}
+
+		key := m.SeriesKey()
+		known, ok := bounds[key]
+		if !ok {
+			if h, exists := db.histogram[key]; exists {
+				known, ok = h.Bounds, true
+			}
+		}
+		if !ok {
+			bounds[key] = m.Histogram.Bounds
+			continue
+		}
+		if !slices.Equal(known, m.Histogram.Bounds) {
+			return errors.New("histogram bounds mismatch")
+		}
+	}
+	return nil
+}
+
+func (db *MemStorage) History(ctx context.Context, m metrics.Metrics, from, to time.Time) ([]metrics.Sample, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if db.historySize <= 0 {
+		return nil, repositories.ErrHistoryDisabled
+	}
+
+	ring, ok := db.history[historyKey(m.MType, m.SeriesKey())]
+	if !ok {
+		return nil, repositories.ErrNotFound
+	}
+	return ring.between(from, to), nil
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -37,6 +39,66 @@ func (s *MemStorageSuite) SetupTest() {
 func (s *MemStorageSuite) TearDownTest() {
 	s.storage.gauge = make(map[string]float64)
 	s.storage.counter = make(map[string]int64)
+	s.storage.histogram = make(map[string]*metrics.HistogramValue)
+	s.storage.series = make(map[string]series)
+	s.storage.history = make(map[string]*sampleRing)
+}
+
+func (s *MemStorageSuite) TestBulkAddOnce() {
+	ctx := context.Background()
+	batch := []metrics.Metrics{
+		{ID: "PollCount", MType: metrics.Counter, Delta: newInt64(3)},
+		{ID: "Alloc", MType: metrics.Gauge, Value: newFloat64(1.5)},
+	}
+
+	_, err := s.storage.BulkAddOnce(ctx, "batch1", batch)
+	s.ErrorIs(err, repositories.ErrIdempotencyDisabled)
+
+	s.storage.EnableIdempotency(time.Minute, 10)
+
+	applied, err := s.storage.BulkAddOnce(ctx, "batch1", batch)
+	s.NoError(err)
+	s.True(applied)
+
+	// the retried batch is not applied again
+	applied, err = s.storage.BulkAddOnce(ctx, "batch1", batch)
+	s.NoError(err)
+	s.False(applied)
+
+	applied, err = s.storage.BulkAddOnce(ctx, "batch2", batch[:1])
+	s.NoError(err)
+	s.True(applied)
+
+	// the failed batch is not applied partly and its key is not remembered
+	broken := []metrics.Metrics{
+		{ID: "PollCount", MType: metrics.Counter, Delta: newInt64(100)},
+		{ID: "latency", MType: metrics.Histogram, Histogram: metrics.NewHistogramValue(1)},
+		{ID: "latency", MType: metrics.Histogram, Histogram: metrics.NewHistogramValue(2)},
+	}
+	_, err = s.storage.BulkAddOnce(ctx, "batch3", broken)
+	s.Error(err)
+	s.False(s.storage.batchKeys.Contains("batch3"))
+
+	counter := metrics.Metrics{ID: "PollCount", MType: metrics.Counter}
+	s.NoError(s.storage.Get(ctx, &counter))
+	s.Equal(int64(6), *counter.Delta)
+	s.ErrorIs(s.storage.Get(ctx, &metrics.Metrics{ID: "latency", MType: metrics.Histogram}), repositories.ErrNotFound)
+
+	// the histogram conflicting with the stored one
+	s.NoError(s.storage.Add(ctx, metrics.Metrics{ID: "latency", MType: metrics.Histogram, Histogram: metrics.NewHistogramValue(1)}))
+	_, err = s.storage.BulkAddOnce(ctx, "batch4", []metrics.Metrics{broken[0], broken[2]})
+	s.Error(err)
+	_, err = s.storage.BulkAddOnce(ctx, "batch5", []metrics.Metrics{{ID: "Alloc", MType: metrics.Gauge}})
+	s.Error(err)
+	s.NoError(s.storage.Get(ctx, &counter))
+	s.Equal(int64(6), *counter.Delta)
+
+	// the corrected batch is applied with the same key
+	applied, err = s.storage.BulkAddOnce(ctx, "batch3", broken[:2])
+	s.NoError(err)
+	s.True(applied)
+	s.NoError(s.storage.Get(ctx, &counter))
+	s.Equal(int64(106), *counter.Delta)
 }
 
 func (s *MemStorageSuite) TestAdd() {
@@ -69,6 +131,96 @@ func (s *MemStorageSuite) TestAdd() {
 	}
 }
 
+func (s *MemStorageSuite) TestAddHistogramMerge() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := metrics.NewHistogramValue(0.1, 1)
+	first.Observe(0.05)
+	first.Observe(0.5)
+
+	second := metrics.NewHistogramValue(0.1, 1)
+	second.Observe(0.5)
+	second.Observe(5)
+
+	s.Require().NoError(s.storage.Add(ctx, metrics.Metrics{ID: "latency", MType: metrics.Histogram, Histogram: first}))
+	s.Require().NoError(s.storage.Add(ctx, metrics.Metrics{ID: "latency", MType: metrics.Histogram, Histogram: second}))
+
+	h := s.storage.histogram["latency"]
+	s.Equal([]int64{1, 2, 1}, h.Counts)
+	s.Equal(int64(4), h.Count)
+	s.InDelta(6.05, h.Sum, 1e-9)
+
+	// the stored histogram must not share memory with the update
+	s.Equal([]int64{1, 1, 0}, first.Counts)
+
+	err := s.storage.Add(ctx, metrics.Metrics{ID: "latency", MType: metrics.Histogram, Histogram: metrics.NewHistogramValue(2)})
+	s.Error(err)
+}
+
+func (s *MemStorageSuite) TestLabeledSeries() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hostA := metrics.Labels{"host": "a", "service": "api"}
+	hostB := metrics.Labels{"host": "b", "service": "api"}
+
+	s.Require().NoError(s.storage.Add(ctx, metrics.Metrics{ID: "cpu", MType: metrics.Gauge, Value: newFloat64(1), Labels: hostA}))
+	s.Require().NoError(s.storage.Add(ctx, metrics.Metrics{ID: "cpu", MType: metrics.Gauge, Value: newFloat64(2), Labels: hostB}))
+	s.Require().NoError(s.storage.Add(ctx, metrics.Metrics{ID: "cpu", MType: metrics.Gauge, Value: newFloat64(3)}))
+
+	metric := metrics.Metrics{ID: "cpu", MType: metrics.Gauge, Labels: metrics.Labels{"service": "api", "host": "b"}}
+	s.Require().NoError(s.storage.Get(ctx, &metric))
+	s.Equal(2.0, *metric.Value)
+
+	metric = metrics.Metrics{ID: "cpu", MType: metrics.Gauge}
+	s.Require().NoError(s.storage.Get(ctx, &metric))
+	s.Equal(3.0, *metric.Value)
+
+	metric = metrics.Metrics{ID: "cpu", MType: metrics.Gauge, Labels: metrics.Labels{"host": "c"}}
+	s.Error(s.storage.Get(ctx, &metric))
+
+	list, err := s.storage.List(ctx)
+	s.Require().NoError(err)
+	s.ElementsMatch([]metrics.Metrics{
+		{ID: "cpu", MType: metrics.Gauge, Value: newFloat64(1), Labels: hostA},
+		{ID: "cpu", MType: metrics.Gauge, Value: newFloat64(2), Labels: hostB},
+		{ID: "cpu", MType: metrics.Gauge, Value: newFloat64(3)},
+	}, list)
+}
+
+func (s *MemStorageSuite) TestHistory() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metric := metrics.Metrics{ID: "requests", MType: metrics.Counter}
+
+	_, err := s.storage.History(ctx, metric, time.Time{}, time.Time{})
+	s.Require().ErrorIs(err, repositories.ErrHistoryDisabled)
+
+	s.storage.EnableHistory(3)
+	defer s.storage.EnableHistory(0)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		s.Require().NoError(s.storage.Add(ctx, metrics.Metrics{ID: "requests", MType: metrics.Counter, Delta: newInt64(1)}))
+	}
+
+	samples, err := s.storage.History(ctx, metric, start, time.Time{})
+	s.Require().NoError(err)
+	s.Require().Len(samples, 3)
+	for i, sample := range samples {
+		s.Equal(int64(i+3), *sample.Delta)
+	}
+
+	samples, err = s.storage.History(ctx, metric, time.Time{}, start.Add(-time.Second))
+	s.Require().NoError(err)
+	s.Empty(samples)
+
+	_, err = s.storage.History(ctx, metrics.Metrics{ID: "unknown", MType: metrics.Counter}, time.Time{}, time.Time{})
+	s.Error(err)
+}
+
 func (s *MemStorageSuite) TestGet() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -123,8 +275,8 @@ func (s *MemStorageSuite) TestList() {
 	}{
 		{
 			initDB: func() {
-				s.storage.gauge["gauge1"] = 1.1
-				s.storage.counter["counter1"] = 1
+				s.NoError(s.storage.Add(ctx, metrics.Metrics{ID: "gauge1", MType: metrics.Gauge, Value: newFloat64(1.1)}))
+				s.NoError(s.storage.Add(ctx, metrics.Metrics{ID: "counter1", MType: metrics.Counter, Delta: newInt64(1)}))
 			},
 			expect: []metrics.Metrics{
 				{ID: "gauge1", MType: metrics.Gauge, Value: newFloat64(1.1)},
@@ -143,3 +295,41 @@ func (s *MemStorageSuite) TestList() {
 		s.TearDownTest()
 	}
 }
+
+// The escaping of the series key does not leak into the names of the listed metrics.
+func (s *MemStorageSuite) TestListEscapedNames() {
+	ctx := context.Background()
+	names := []string{`a{b`, `c\d`, `e{f="g"}`}
+	for _, name := range names {
+		s.NoError(s.storage.Add(ctx, metrics.Metrics{ID: name, MType: metrics.Counter, Delta: newInt64(1)}))
+	}
+
+	list, err := s.storage.List(ctx)
+	s.NoError(err)
+	listed := make([]string, 0, len(list))
+	for _, m := range list {
+		listed = append(listed, m.ID)
+	}
+	s.ElementsMatch(names, listed)
+}
+
+func (s *MemStorageSuite) TestQuery() {
+	ctx := context.Background()
+	for _, name := range []string{"b", "a", "c"} {
+		s.Require().NoError(s.storage.Add(ctx, metrics.Metrics{ID: name, MType: metrics.Gauge, Value: newFloat64(1)}))
+	}
+	s.Require().NoError(s.storage.Add(ctx, metrics.Metrics{ID: "a", MType: metrics.Counter, Delta: newInt64(1)}))
+
+	page, err := s.storage.Query(ctx, metrics.Query{Types: []metrics.MetricType{metrics.Gauge}, Limit: 2})
+	s.Require().NoError(err)
+	s.Require().Len(page.Metrics, 2)
+	s.Equal("a", page.Metrics[0].ID)
+	s.Equal("b", page.Metrics[1].ID)
+	s.NotEmpty(page.NextCursor)
+
+	page, err = s.storage.Query(ctx, metrics.Query{Types: []metrics.MetricType{metrics.Gauge}, Limit: 2, Cursor: page.NextCursor})
+	s.Require().NoError(err)
+	s.Require().Len(page.Metrics, 1)
+	s.Equal("c", page.Metrics[0].ID)
+	s.Empty(page.NextCursor)
+}
@@ -2,10 +2,14 @@ package repositories
 
 import (
 	"context"
+	"errors"
 
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
 )
 
+// ErrNotFound is returned when the requested series is not stored in the repository.
+var ErrNotFound = errors.New("not found")
+
 // MatrixStorage is the main interface defining methods for interacting with the repository.
 //
 //go:generate minimock -i github.com/screamsoul/go-metrics-tpl/internal/repositories.MetricStorage -o ./mocks/metric_storage_mock.go -g
@@ -15,5 +19,7 @@ type MetricStorage interface {
 
 	Get(ctx context.Context, m *metrics.Metrics) error
 	List(ctx context.Context) ([]metrics.Metrics, error)
+	// Query returns a page of metrics filtered, sorted and paginated according to the query.
+	Query(ctx context.Context, q metrics.Query) (metrics.Page, error)
 	Ping(ctx context.Context) bool
 }
@@ -3,13 +3,16 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
 	"github.com/screamsoul/go-metrics-tpl/pkg/backoff"
 	"github.com/screamsoul/go-metrics-tpl/pkg/logging"
 	"github.com/screamsoul/go-metrics-tpl/pkg/utils"
@@ -21,29 +24,61 @@ type PostgresStorage struct {
 	db               *sqlx.DB
 	logging          *zap.Logger
 	backoffInteraval []time.Duration
+	historySize      int // количество хранимых значений истории каждой серии, 0 - история отключена
+	idempotencyTTL   time.Duration
 }
 
 func NewPostgresStorage(dataSourceName string, backoffInteraval []time.Duration) *PostgresStorage {
 	db := sqlx.MustOpen("pgx", dataSourceName)
 
-	return &PostgresStorage{db, logging.GetLogger(), backoffInteraval}
+	return &PostgresStorage{db, logging.GetLogger(), backoffInteraval, 0, 0}
+}
+
+// EnableHistory turns on appending every accepted update to the metric_samples table,
+// RunHistoryPruning keeps the size newest samples of every series.
+func (storage *PostgresStorage) EnableHistory(size int) {
+	storage.historySize = size
+}
+
+// EnableIdempotency turns on remembering the keys of the applied batches in the idempotency_keys table for ttl.
+func (storage *PostgresStorage) EnableIdempotency(ttl time.Duration) {
+	storage.idempotencyTTL = ttl
+}
+
+// withHistory wraps the upsert query so that the resulting row is also appended to metric_samples.
+func (storage *PostgresStorage) withHistory(upsert string) string {
+	if storage.historySize <= 0 {
+		return upsert
+	}
+	return fmt.Sprintf(`
+		WITH upserted AS (%s
+			RETURNING name, m_type, labels, delta, value, histogram
+		)
+		INSERT INTO metric_samples (name, m_type, labels, delta, value, histogram)
+		SELECT name, m_type, labels, delta, value, histogram FROM upserted;
+	`, strings.TrimRight(strings.TrimSpace(upsert), ";"))
 }
 
 func (storage *PostgresStorage) Add(ctx context.Context, metric metrics.Metrics) error {
-	stmt, err := storage.db.PrepareContext(ctx, `
-		INSERT INTO metrics (name, m_type, delta, value)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (name) DO UPDATE SET
+	if metric.MType == metrics.Histogram {
+		// merging histograms requires reading the stored buckets inside a transaction
+		return storage.BulkAdd(ctx, []metrics.Metrics{metric})
+	}
+
+	stmt, err := storage.db.PrepareContext(ctx, storage.withHistory(`
+		INSERT INTO metrics (name, m_type, labels, delta, value)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name, m_type, labels) DO UPDATE SET
 			delta = CASE WHEN metrics.m_type = 'counter' THEN metrics.delta + excluded.delta ELSE excluded.delta END,
 			value = excluded.value;
-	`)
+	`))
 	if err != nil {
 		return err
 	}
 	defer utils.CloseForse(stmt)
 
 	exec := func() error {
-		_, err = stmt.ExecContext(ctx, metric.ID, metric.MType, metric.Delta, metric.Value)
+		_, err = stmt.ExecContext(ctx, metric.ID, metric.MType, metric.Labels, metric.Delta, metric.Value)
 		return err
 	}
 
@@ -55,18 +90,19 @@ func (storage *PostgresStorage) Add(ctx context.Context, metric metrics.Metrics)
 }
 
 func (storage *PostgresStorage) Get(ctx context.Context, metric *metrics.Metrics) error {
-	query := `SELECT value, delta FROM metrics WHERE name = $1 AND m_type = $2`
+	query := `SELECT value, delta, histogram FROM metrics WHERE name = $1 AND m_type = $2 AND labels = $3`
 	var value sql.NullFloat64
 	var delta sql.NullInt64
+	var histogram *metrics.HistogramValue
 
-	row := storage.db.QueryRowContext(ctx, query, metric.ID, metric.MType)
+	row := storage.db.QueryRowContext(ctx, query, metric.ID, metric.MType, metric.Labels)
 	var err error
 
 	exec := func() error {
-		scanErr := row.Scan(&value, &delta)
+		scanErr := row.Scan(&value, &delta, &histogram)
 
 		if scanErr == sql.ErrNoRows {
-			return fmt.Errorf("metric with Name %s not found", metric.ID)
+			return fmt.Errorf("metric with Name %s %w", metric.ID, repositories.ErrNotFound)
 		}
 		return scanErr
 	}
@@ -86,12 +122,15 @@ func (storage *PostgresStorage) Get(ctx context.Context, metric *metrics.Metrics
 	if delta.Valid {
 		metric.Delta = &delta.Int64
 	}
+	if histogram != nil {
+		metric.Histogram = histogram
+	}
 
 	return nil
 }
 
 func (storage *PostgresStorage) List(ctx context.Context) (metricsList []metrics.Metrics, err error) {
-	query := `SELECT name, m_type, delta, value FROM metrics`
+	query := `SELECT name, m_type, labels, delta, value, histogram FROM metrics`
 	exec := func() error {
 		return storage.db.SelectContext(ctx, &metricsList, query)
 	}
@@ -104,6 +143,73 @@ func (storage *PostgresStorage) List(ctx context.Context) (metricsList []metrics
 	return
 }
 
+// Query filters, sorts and paginates metrics in SQL, the page is keyset-paginated
+// by (name, m_type, labels) in the byte order of the names.
+func (storage *PostgresStorage) Query(ctx context.Context, q metrics.Query) (page metrics.Page, err error) {
+	if err = q.Validate(); err != nil {
+		return
+	}
+
+	var conditions []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if pattern := q.NameRegexp(); pattern != "" {
+		conditions = append(conditions, "name ~ "+arg(pattern))
+	}
+	if len(q.Types) > 0 {
+		types := make([]string, 0, len(q.Types))
+		for _, t := range q.Types {
+			types = append(types, arg(string(t)))
+		}
+		conditions = append(conditions, fmt.Sprintf("m_type::text IN (%s)", strings.Join(types, ", ")))
+	}
+
+	cmp, order := ">", "ASC"
+	if q.Order == metrics.Desc {
+		cmp, order = "<", "DESC"
+	}
+	if after, _ := q.After(); after != nil {
+		conditions = append(conditions, fmt.Sprintf(
+			`(name COLLATE "C", m_type::text, labels::text) %s (%s COLLATE "C", %s, %s::jsonb::text)`,
+			cmp, arg(after.ID), arg(string(after.MType)), arg(after.Labels),
+		))
+	}
+
+	query := `SELECT name, m_type, labels, delta, value, histogram FROM metrics`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(` ORDER BY name COLLATE "C" %[1]s, m_type::text %[1]s, labels::text %[1]s`, order)
+	if q.Limit > 0 {
+		// one extra row tells whether there is a next page
+		query += " LIMIT " + arg(q.Limit+1)
+	}
+
+	exec := func() error {
+		page.Metrics = nil
+		return storage.db.SelectContext(ctx, &page.Metrics, query, args...)
+	}
+
+	err = backoff.RetryWithBackoff(storage.backoffInteraval, IsTemporaryConnectionError, exec)
+	if err != nil {
+		err = fmt.Errorf("failed retries db request, %w", err)
+		return
+	}
+
+	if page.Metrics == nil {
+		page.Metrics = []metrics.Metrics{}
+	}
+	if q.Limit > 0 && len(page.Metrics) > q.Limit {
+		page.Metrics = page.Metrics[:q.Limit]
+		page.NextCursor = metrics.NewCursor(page.Metrics[q.Limit-1])
+	}
+	return
+}
+
 func (storage *PostgresStorage) Ping(ctx context.Context) bool {
 	err := storage.db.PingContext(ctx)
 	if err != nil {
@@ -124,19 +230,148 @@ func (storage *PostgresStorage) BulkAdd(ctx context.Context, metricList []metric
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			storage.logging.Warn("rollback transaction error", zap.Error(rollbackErr))
+	defer storage.rollback(tx)
+
+	if err := storage.bulkAddTx(ctx, tx, metricList); err != nil {
+		return err
+	}
+	return storage.commit(tx)
+}
+
+// BulkAddOnce stores the batch in the transaction that inserts its key into idempotency_keys,
+// a concurrent batch with the same key waits for the transaction and is not applied if it is committed.
+// A key older than the idempotency ttl by the database clock is treated as a new one.
+func (storage *PostgresStorage) BulkAddOnce(ctx context.Context, key string, metricList []metrics.Metrics) (bool, error) {
+	if storage.idempotencyTTL <= 0 {
+		return false, repositories.ErrIdempotencyDisabled
+	}
+
+	tx, err := storage.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer storage.rollback(tx)
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (key, created_at) VALUES ($1, now())
+		ON CONFLICT (key) DO UPDATE SET created_at = excluded.created_at
+		WHERE idempotency_keys.created_at < now() - make_interval(secs => $2);
+	`, key, storage.idempotencyTTL.Seconds())
+	if err != nil {
+		return false, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if inserted == 0 {
+		return false, nil
+	}
+
+	if err := storage.bulkAddTx(ctx, tx, metricList); err != nil {
+		return false, err
+	}
+	if err := storage.commit(tx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PruneIdempotencyKeys deletes the keys older than the idempotency ttl by the database clock.
+func (storage *PostgresStorage) PruneIdempotencyKeys(ctx context.Context) (int64, error) {
+	res, err := storage.db.ExecContext(ctx, `
+		DELETE FROM idempotency_keys WHERE created_at < now() - make_interval(secs => $1);
+	`, storage.idempotencyTTL.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunIdempotencyPruning prunes the expired idempotency keys every interval until ctx is done,
+// so the ingest transactions do not contend for the locks of the deleted rows.
+func (storage *PostgresStorage) RunIdempotencyPruning(ctx context.Context, interval time.Duration) {
+	storage.runPruning(ctx, interval, "idempotency keys", storage.PruneIdempotencyKeys)
+}
+
+// PruneHistory deletes the samples of every series except the history size newest ones.
+func (storage *PostgresStorage) PruneHistory(ctx context.Context) (int64, error) {
+	res, err := storage.db.ExecContext(ctx, `
+		DELETE FROM metric_samples WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (
+					PARTITION BY name, m_type, labels ORDER BY created_at DESC, id DESC
+				) AS position
+				FROM metric_samples
+			) ranked
+			WHERE position > $1
+		);
+	`, storage.historySize)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunHistoryPruning prunes the history every interval until ctx is done,
+// a series may exceed the history size by the samples added since the last pruning.
+func (storage *PostgresStorage) RunHistoryPruning(ctx context.Context, interval time.Duration) {
+	storage.runPruning(ctx, interval, "history samples", storage.PruneHistory)
+}
+
+func (storage *PostgresStorage) runPruning(
+	ctx context.Context,
+	interval time.Duration,
+	name string,
+	prune func(ctx context.Context) (int64, error),
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pruned, err := prune(ctx)
+		if err != nil {
+			storage.logging.Error("prune "+name+" error", zap.Error(err))
+			continue
 		}
-	}()
+		storage.logging.Debug(name+" pruned", zap.Int64("count", pruned))
+	}
+}
+
+func (storage *PostgresStorage) rollback(tx *sqlx.Tx) {
+	if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		storage.logging.Warn("rollback transaction error", zap.Error(err))
+	}
+}
+
+func (storage *PostgresStorage) commit(tx *sqlx.Tx) error {
+	exec := func() error {
+		return tx.Commit()
+	}
+
+	err := backoff.RetryWithBackoff(storage.backoffInteraval, IsTemporaryConnectionError, exec)
+	if err != nil {
+		err = fmt.Errorf("failed retries db request, %w", err)
+	}
+	return err
+}
 
-	stmt, err := tx.PreparexContext(ctx, `
-		INSERT INTO metrics (name, m_type, delta, value)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (name) DO UPDATE SET
+// bulkAddTx upserts the metrics within the transaction.
+func (storage *PostgresStorage) bulkAddTx(ctx context.Context, tx *sqlx.Tx, metricList []metrics.Metrics) error {
+	stmt, err := tx.PreparexContext(ctx, storage.withHistory(`
+		INSERT INTO metrics (name, m_type, labels, delta, value, histogram)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name, m_type, labels) DO UPDATE SET
 			delta = CASE WHEN metrics.m_type = 'counter' THEN metrics.delta + excluded.delta ELSE metrics.delta END,
-			value = CASE WHEN metrics.m_type = 'gauge' THEN excluded.value ELSE metrics.value END;
-	`)
+			value = CASE WHEN metrics.m_type = 'gauge' THEN excluded.value ELSE metrics.value END,
+			histogram = CASE WHEN metrics.m_type = 'histogram' THEN excluded.histogram ELSE metrics.histogram END;
+	`))
 	if err != nil {
 		return err
 	}
@@ -156,19 +391,81 @@ func (storage *PostgresStorage) BulkAdd(ctx context.Context, metricList []metric
 			value.Valid = true
 		}
 
-		_, err = stmt.ExecContext(ctx, metric.ID, metric.MType, delta, value)
+		histogram := metric.Histogram
+		if metric.MType == metrics.Histogram {
+			histogram, err = storage.mergeHistogram(ctx, tx, metric)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = stmt.ExecContext(ctx, metric.ID, metric.MType, metric.Labels, delta, value, histogram)
 		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+func (storage *PostgresStorage) History(ctx context.Context, metric metrics.Metrics, from, to time.Time) (samples []metrics.Sample, err error) {
+	if storage.historySize <= 0 {
+		return nil, repositories.ErrHistoryDisabled
+	}
+
+	query := `
+		SELECT created_at, delta, value, histogram FROM metric_samples
+		WHERE name = $1 AND m_type = $2 AND labels = $3
+			AND ($4::timestamptz IS NULL OR created_at >= $4)
+			AND ($5::timestamptz IS NULL OR created_at <= $5)
+		ORDER BY created_at, id
+	`
+	fromArg := sql.NullTime{Time: from, Valid: !from.IsZero()}
+	toArg := sql.NullTime{Time: to, Valid: !to.IsZero()}
 
 	exec := func() error {
-		return tx.Commit()
+		return storage.db.SelectContext(ctx, &samples, query, metric.ID, metric.MType, metric.Labels, fromArg, toArg)
 	}
 
 	err = backoff.RetryWithBackoff(storage.backoffInteraval, IsTemporaryConnectionError, exec)
 	if err != nil {
 		err = fmt.Errorf("failed retries db request, %w", err)
 	}
-	return err
+
+	return
+}
+
+// mergeHistogram locks the stored histogram row and returns it merged with the incoming histogram.
+func (storage *PostgresStorage) mergeHistogram(ctx context.Context, tx *sqlx.Tx, metric metrics.Metrics) (*metrics.HistogramValue, error) {
+	var stored *metrics.HistogramValue
+
+	// the row of the new series is inserted first, so the concurrent first writes lock it
+	// and merge one after another instead of overwriting each other
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO metrics (name, m_type, labels) VALUES ($1, 'histogram', $2) ON CONFLICT (name, m_type, labels) DO NOTHING`,
+		metric.ID,
+		metric.Labels,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowxContext(
+		ctx,
+		`SELECT histogram FROM metrics WHERE name = $1 AND m_type = 'histogram' AND labels = $2 FOR UPDATE`,
+		metric.ID,
+		metric.Labels,
+	).Scan(&stored)
+
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && stored == nil) {
+		return metric.Histogram, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stored.Merge(metric.Histogram); err != nil {
+		return nil, fmt.Errorf("merge histogram %s: %w", metric.ID, err)
+	}
+	return stored, nil
 }
@@ -0,0 +1,9 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+ALTER TYPE metric_type ADD VALUE IF NOT EXISTS 'histogram';
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS histogram JSONB;
+
+-- +goose Down
+-- enum values can not be removed, the 'histogram' value stays in metric_type.
+DELETE FROM metrics WHERE m_type = 'histogram';
+ALTER TABLE metrics DROP COLUMN IF EXISTS histogram;
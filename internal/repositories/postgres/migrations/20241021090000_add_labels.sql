@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE metrics ADD COLUMN labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE metrics DROP CONSTRAINT metrics_pkey;
+ALTER TABLE metrics ADD COLUMN id BIGSERIAL PRIMARY KEY;
+CREATE UNIQUE INDEX metrics_name_type_labels_idx ON metrics (name, m_type, labels);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM metrics WHERE labels <> '{}'::jsonb;
+DELETE FROM metrics a USING metrics b WHERE a.name = b.name AND a.id > b.id;
+DROP INDEX IF EXISTS metrics_name_type_labels_idx;
+ALTER TABLE metrics DROP COLUMN id;
+ALTER TABLE metrics DROP COLUMN labels;
+ALTER TABLE metrics ADD PRIMARY KEY (name);
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS metric_samples (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    m_type metric_type NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}'::jsonb,
+    delta BIGINT,
+    value DOUBLE PRECISION,
+    histogram JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS metric_samples_series_idx ON metric_samples (name, m_type, labels, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS metric_samples;
+-- +goose StatementEnd
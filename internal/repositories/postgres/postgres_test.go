@@ -12,6 +12,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/jmoiron/sqlx"
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
 	"github.com/screamsoul/go-metrics-tpl/pkg/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -64,7 +65,7 @@ func (suite *PostgresStorageTestSuite) SetupSuite() {
 	suite.mock = mock
 
 	suite.storage = &PostgresStorage{
-		suite.mockDB, zap.NewNop(), []time.Duration{},
+		suite.mockDB, zap.NewNop(), []time.Duration{}, 0, 0,
 	}
 }
 
@@ -80,7 +81,7 @@ func (suite *PostgresStorageTestSuite) TestAdd() {
 	// Expecting INSERT statement
 	suite.mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO metrics`))
 	suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO metrics`)).
-		WithArgs(metric.ID, metric.MType, metric.Delta, metric.Value).
+		WithArgs(metric.ID, metric.MType, metric.Labels, metric.Delta, metric.Value).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Execute the Add method
@@ -91,12 +92,12 @@ func (suite *PostgresStorageTestSuite) TestAdd() {
 }
 
 func (suite *PostgresStorageTestSuite) TestGet() {
-	rows := sqlmock.NewRows([]string{"value", "delta"}).AddRow(123.45, 6789)
+	rows := sqlmock.NewRows([]string{"value", "delta", "histogram"}).AddRow(123.45, 6789, nil)
 
-	metric := &metrics.Metrics{ID: "test_id", MType: metrics.Gauge}
+	metric := &metrics.Metrics{ID: "test_id", MType: metrics.Gauge, Labels: metrics.Labels{"host": "a"}}
 	suite.mock.
-		ExpectQuery(regexp.QuoteMeta(`SELECT value, delta`)).
-		WithArgs(metric.ID, metric.MType).
+		ExpectQuery(regexp.QuoteMeta(`SELECT value, delta, histogram`)).
+		WithArgs(metric.ID, metric.MType, `{"host":"a"}`).
 		WillReturnRows(rows)
 
 	err := suite.storage.Get(context.Background(), metric)
@@ -117,15 +118,15 @@ func (suite *PostgresStorageTestSuite) TestList() {
 		m = getRandomMetric()
 		metricsExpected[i] = m
 		valuesExpected[i] = []driver.Value{
-			m.ID, string(m.MType), m.Delta, m.Value,
+			m.ID, string(m.MType), "{}", m.Delta, m.Value, nil,
 		}
 	}
 
-	rows := sqlmock.NewRows([]string{"name", "m_type", "delta", "value"}).
+	rows := sqlmock.NewRows([]string{"name", "m_type", "labels", "delta", "value", "histogram"}).
 		AddRows(valuesExpected...)
 
 	suite.mock.
-		ExpectQuery(regexp.QuoteMeta(`SELECT name, m_type, delta, value`)).
+		ExpectQuery(regexp.QuoteMeta(`SELECT name, m_type, labels, delta, value, histogram`)).
 		WillReturnRows(rows)
 
 	metricsActual, err := suite.storage.List(context.Background())
@@ -136,6 +137,39 @@ func (suite *PostgresStorageTestSuite) TestList() {
 	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
 }
 
+func (suite *PostgresStorageTestSuite) TestQuery() {
+	value := 1.5
+	rows := sqlmock.NewRows([]string{"name", "m_type", "labels", "delta", "value", "histogram"}).
+		AddRow("HeapAlloc", "gauge", "{}", nil, value, nil).
+		AddRow("HeapIdle", "gauge", `{"host": "a"}`, nil, value, nil)
+
+	q := metrics.Query{
+		Name:   "Heap*",
+		Types:  []metrics.MetricType{metrics.Gauge},
+		Order:  metrics.Desc,
+		Limit:  1,
+		Cursor: metrics.NewCursor(metrics.Metrics{ID: "HeapSys", MType: metrics.Gauge}),
+	}
+
+	suite.mock.
+		ExpectQuery(regexp.QuoteMeta(`SELECT name, m_type, labels, delta, value, histogram FROM metrics `+
+			`WHERE name ~ $1 AND m_type::text IN ($2) `+
+			`AND (name COLLATE "C", m_type::text, labels::text) < ($3 COLLATE "C", $4, $5::jsonb::text) `+
+			`ORDER BY name COLLATE "C" DESC, m_type::text DESC, labels::text DESC LIMIT $6`)).
+		WithArgs(`^Heap.*$`, "gauge", "HeapSys", "gauge", "{}", 2).
+		WillReturnRows(rows)
+
+	page, err := suite.storage.Query(context.Background(), q)
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), []metrics.Metrics{{ID: "HeapAlloc", MType: metrics.Gauge, Value: &value}}, page.Metrics)
+	assert.Equal(suite.T(), metrics.NewCursor(page.Metrics[0]), page.NextCursor)
+	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
+
+	_, err = suite.storage.Query(context.Background(), metrics.Query{Types: []metrics.MetricType{"timer"}})
+	assert.Error(suite.T(), err)
+}
+
 func (suite *PostgresStorageTestSuite) TestPing() {
 	suite.mock.ExpectPing()
 
@@ -156,10 +190,10 @@ func (suite *PostgresStorageTestSuite) TestBulkAdd() {
 	}
 
 	suite.mock.ExpectBegin()
-	suite.mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, delta, value)`))
+	suite.mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, labels, delta, value, histogram)`))
 	for i := 0; i < count; i++ {
-		suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, delta, value)`)).
-			WithArgs(metricsExpected[i].ID, metricsExpected[i].MType, metricsExpected[i].Delta, metricsExpected[i].Value).
+		suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, labels, delta, value, histogram)`)).
+			WithArgs(metricsExpected[i].ID, metricsExpected[i].MType, "{}", metricsExpected[i].Delta, metricsExpected[i].Value, nil).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 	}
 	suite.mock.ExpectCommit()
@@ -168,3 +202,157 @@ func (suite *PostgresStorageTestSuite) TestBulkAdd() {
 	require.NoError(suite.T(), err)
 	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
 }
+
+func (suite *PostgresStorageTestSuite) TestBulkAddOnce() {
+	ctx := context.Background()
+	delta := int64(3)
+	batch := []metrics.Metrics{{ID: "PollCount", MType: metrics.Counter, Delta: &delta}}
+
+	_, err := suite.storage.BulkAddOnce(ctx, "batch", batch)
+	require.ErrorIs(suite.T(), err, repositories.ErrIdempotencyDisabled)
+
+	suite.storage.EnableIdempotency(time.Hour)
+	defer func() {
+		suite.storage.idempotencyTTL = 0
+	}()
+
+	// the first batch is stored in the transaction of its key
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO idempotency_keys (key, created_at)`)).
+		WithArgs("batch", time.Hour.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	suite.mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, labels, delta, value, histogram)`))
+	suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, labels, delta, value, histogram)`)).
+		WithArgs("PollCount", metrics.Counter, "{}", &delta, nil, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	suite.mock.ExpectCommit()
+
+	applied, err := suite.storage.BulkAddOnce(ctx, "batch", batch)
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), applied)
+
+	// the repeated batch is not stored
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO idempotency_keys (key, created_at)`)).
+		WithArgs("batch", time.Hour.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.mock.ExpectRollback()
+
+	applied, err = suite.storage.BulkAddOnce(ctx, "batch", batch)
+	require.NoError(suite.T(), err)
+	assert.False(suite.T(), applied)
+	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresStorageTestSuite) TestPruneIdempotencyKeys() {
+	suite.storage.EnableIdempotency(time.Hour)
+	defer func() {
+		suite.storage.idempotencyTTL = 0
+	}()
+
+	suite.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM idempotency_keys WHERE created_at < now() - make_interval(secs => $1)`)).
+		WithArgs(time.Hour.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	pruned, err := suite.storage.PruneIdempotencyKeys(context.Background())
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(2), pruned)
+	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresStorageTestSuite) TestAddHistogram() {
+	stored := &metrics.HistogramValue{Bounds: []float64{1}, Counts: []int64{1, 1}, Sum: 3, Count: 2}
+	incoming := &metrics.HistogramValue{Bounds: []float64{1}, Counts: []int64{2, 0}, Sum: 1, Count: 2}
+	merged := &metrics.HistogramValue{Bounds: []float64{1}, Counts: []int64{3, 1}, Sum: 4, Count: 4}
+
+	storedJSON, err := stored.Value()
+	require.NoError(suite.T(), err)
+
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, labels, delta, value, histogram)`))
+	suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, labels) VALUES ($1, 'histogram', $2) ON CONFLICT`)).
+		WithArgs("latency", "{}").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	suite.mock.ExpectQuery(regexp.QuoteMeta(`SELECT histogram FROM metrics`)).
+		WithArgs("latency", "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"histogram"}).AddRow(storedJSON))
+	suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, labels, delta, value, histogram)`)).
+		WithArgs("latency", metrics.Histogram, "{}", nil, nil, merged).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	suite.mock.ExpectCommit()
+
+	err = suite.storage.Add(context.Background(), metrics.Metrics{ID: "latency", MType: metrics.Histogram, Histogram: incoming})
+	require.NoError(suite.T(), err)
+
+	// the first write of the series inserts the row to lock before reading it
+	suite.mock.ExpectBegin()
+	suite.mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, labels, delta, value, histogram)`))
+	suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, labels) VALUES ($1, 'histogram', $2) ON CONFLICT`)).
+		WithArgs("size", "{}").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	suite.mock.ExpectQuery(regexp.QuoteMeta(`SELECT histogram FROM metrics`)).
+		WithArgs("size", "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"histogram"}).AddRow(nil))
+	suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO metrics (name, m_type, labels, delta, value, histogram)`)).
+		WithArgs("size", metrics.Histogram, "{}", nil, nil, incoming).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	suite.mock.ExpectCommit()
+
+	err = suite.storage.Add(context.Background(), metrics.Metrics{ID: "size", MType: metrics.Histogram, Histogram: incoming})
+	require.NoError(suite.T(), err)
+	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresStorageTestSuite) TestHistory() {
+	ctx := context.Background()
+	metric := metrics.Metrics{ID: "cpu", MType: metrics.Gauge}
+
+	_, err := suite.storage.History(ctx, metric, time.Time{}, time.Time{})
+	require.ErrorIs(suite.T(), err, repositories.ErrHistoryDisabled)
+
+	suite.storage.EnableHistory(10)
+	defer func() {
+		suite.storage.historySize = 0
+	}()
+
+	suite.mock.ExpectPrepare(regexp.QuoteMeta(`INSERT INTO metric_samples`))
+	suite.mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO metric_samples`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	value := 1.5
+	metric.Value = &value
+	require.NoError(suite.T(), suite.storage.Add(ctx, metric))
+
+	now := time.Now().UTC()
+	rows := sqlmock.NewRows([]string{"created_at", "delta", "value", "histogram"}).
+		AddRow(now, nil, value, nil)
+
+	from := now.Add(-time.Minute)
+	suite.mock.
+		ExpectQuery(regexp.QuoteMeta(`SELECT created_at, delta, value, histogram FROM metric_samples`)).
+		WithArgs("cpu", metrics.Gauge, "{}", from, nil).
+		WillReturnRows(rows)
+
+	samples, err := suite.storage.History(ctx, metric, from, time.Time{})
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), samples, 1)
+	assert.Equal(suite.T(), now, samples[0].Timestamp)
+	assert.Equal(suite.T(), value, *samples[0].Value)
+	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
+}
+
+func (suite *PostgresStorageTestSuite) TestPruneHistory() {
+	suite.storage.EnableHistory(10)
+	defer func() {
+		suite.storage.historySize = 0
+	}()
+
+	suite.mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM metric_samples WHERE id IN (`)).
+		WithArgs(10).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	pruned, err := suite.storage.PruneHistory(context.Background())
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(3), pruned)
+	assert.NoError(suite.T(), suite.mock.ExpectationsWereMet())
+}
@@ -0,0 +1,14 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+)
+
+// StreamStorage is an interface for a repository that publishes every accepted update to subscribers.
+type StreamStorage interface {
+	// Subscribe returns a channel of accepted updates matching the name and type filter of the query.
+	// The channel is closed when ctx is done or when the subscriber falls behind and is dropped.
+	Subscribe(ctx context.Context, q metrics.Query) (<-chan metrics.Metrics, error)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"go.uber.org/zap"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+var dashboardTemplate = template.Must(template.ParseFS(templatesFS, "templates/dashboard.html"))
+
+const (
+	dashboardRefresh = 10 // интервал автообновления страницы по умолчанию, в секундах
+	chartWidth       = 120
+	chartHeight      = 24
+	chartPadding     = 2
+)
+
+// dashboardHistoryWindow the period shown on the charts, it bounds the history query of every series
+// which runs on each refresh of the page.
+const dashboardHistoryWindow = time.Hour
+
+// dashboardMaxCharts the maximum number of the charted series of the page, every chart is a history query.
+const dashboardMaxCharts = 20
+
+type dashboardSeries struct {
+	Name   string
+	Labels string
+	Value  string
+	Points string // точки графика истории в формате svg polyline
+}
+
+type dashboardGroup struct {
+	Title  string
+	Series []dashboardSeries
+}
+
+type dashboardPage struct {
+	Groups      []dashboardGroup
+	Query       string
+	Sort        string
+	NextSort    string
+	Refresh     int
+	History     bool
+	ChartWidth  int
+	ChartHeight int
+}
+
+var dashboardGroups = []struct {
+	mType metrics.MetricType
+	title string
+}{
+	{metrics.Gauge, "Gauges"},
+	{metrics.Counter, "Counters"},
+	{metrics.Histogram, "Histograms"},
+}
+
+// wantsJSON reports whether the client asked for the json representation.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// sampleValue returns the numeric value of the sample drawn on the chart.
+func sampleValue(s metrics.Sample) (float64, bool) {
+	switch {
+	case s.Value != nil:
+		return *s.Value, true
+	case s.Delta != nil:
+		return float64(*s.Delta), true
+	case s.Histogram != nil:
+		return float64(s.Histogram.Count), true
+	}
+	return 0, false
+}
+
+// chartPoints scales the samples into the svg polyline points, empty if there is nothing to draw.
+func chartPoints(samples []metrics.Sample) string {
+	values := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		if v, ok := sampleValue(s); ok {
+			values = append(values, v)
+		}
+	}
+	if len(values) < 2 {
+		return ""
+	}
+
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+
+	var sb strings.Builder
+	stepX := float64(chartWidth-2*chartPadding) / float64(len(values)-1)
+	for i, v := range values {
+		y := float64(chartHeight) / 2
+		if hi > lo {
+			y = chartPadding + (hi-v)/(hi-lo)*float64(chartHeight-2*chartPadding)
+		}
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%.1f,%.1f", chartPadding+float64(i)*stepX, y)
+	}
+	return sb.String()
+}
+
+// seriesHistory returns the chart points of the series for the last dashboardHistoryWindow,
+// false if the storage keeps no history. The series is not charted if its history cannot be read.
+func (ms *MetricServer) seriesHistory(ctx context.Context, m metrics.Metrics) (string, bool) {
+	hs, ok := ms.store.(repositories.HistoryStorage)
+	if !ok {
+		return "", false
+	}
+	samples, err := hs.History(ctx, m, time.Now().Add(-dashboardHistoryWindow), time.Time{})
+	if errors.Is(err, repositories.ErrHistoryDisabled) {
+		return "", false
+	}
+	if err != nil {
+		ms.logger.Error("Error read metric history", zap.String("series", m.SeriesKey()), zap.Error(err))
+		return "", true
+	}
+	return chartPoints(samples), true
+}
+
+// renderDashboard writes the html page with metrics grouped by type.
+//
+// Query parameters: `q` filters by a name substring, `sort=desc` reverses the name order,
+// `refresh` sets the auto-refresh interval in seconds, 0 disables it.
+// The history is charted for the first dashboardMaxCharts series, `q` narrows them down.
+func (ms *MetricServer) renderDashboard(w http.ResponseWriter, r *http.Request, metricList []metrics.Metrics) {
+	query := r.URL.Query()
+
+	page := dashboardPage{
+		Query:       query.Get("q"),
+		Sort:        "asc",
+		NextSort:    "desc",
+		Refresh:     dashboardRefresh,
+		ChartWidth:  chartWidth,
+		ChartHeight: chartHeight,
+	}
+	if query.Get("sort") == "desc" {
+		page.Sort, page.NextSort = "desc", "asc"
+	}
+	if refresh, err := strconv.Atoi(query.Get("refresh")); err == nil && refresh >= 0 {
+		page.Refresh = refresh
+	}
+
+	// only the first dashboardMaxCharts series are charted, the history is not read once it is disabled
+	charts, history := 0, true
+	filter := strings.ToLower(page.Query)
+	sort.Slice(metricList, func(i, j int) bool {
+		a, b := metricList[i].SeriesKey(), metricList[j].SeriesKey()
+		if page.Sort == "desc" {
+			return a > b
+		}
+		return a < b
+	})
+
+	for _, group := range dashboardGroups {
+		g := dashboardGroup{Title: group.title}
+		for _, m := range metricList {
+			if m.MType != group.mType || !strings.Contains(strings.ToLower(m.ID), filter) {
+				continue
+			}
+
+			series := dashboardSeries{Name: m.ID, Labels: m.Labels.String()}
+			if m.MType == metrics.Histogram {
+				series.Value = fmt.Sprintf("count=%d sum=%s", m.Histogram.Count, strconv.FormatFloat(m.Histogram.Sum, 'f', -1, 64))
+			} else {
+				series.Value = m.GetValue()
+			}
+			if history && charts < dashboardMaxCharts {
+				charts++
+				series.Points, history = ms.seriesHistory(r.Context(), m)
+				page.History = page.History || history
+			}
+
+			g.Series = append(g.Series, series)
+		}
+		if len(g.Series) > 0 {
+			page.Groups = append(page.Groups, g)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		ms.logger.Error("Error writing response", zap.Error(err))
+	}
+}
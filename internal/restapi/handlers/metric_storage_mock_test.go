@@ -46,6 +46,12 @@ type MetricStorageMock struct {
 	afterPingCounter  uint64
 	beforePingCounter uint64
 	PingMock          mMetricStorageMockPing
+
+	funcQuery          func(ctx context.Context, q metrics.Query) (p1 metrics.Page, err error)
+	inspectFuncQuery   func(ctx context.Context, q metrics.Query)
+	afterQueryCounter  uint64
+	beforeQueryCounter uint64
+	QueryMock          mMetricStorageMockQuery
 }
 
 // NewMetricStorageMock returns a mock for repositories.MetricStorage
@@ -71,6 +77,9 @@ func NewMetricStorageMock(t minimock.Tester) *MetricStorageMock {
 	m.PingMock = mMetricStorageMockPing{mock: m}
 	m.PingMock.callArgs = []*MetricStorageMockPingParams{}
 
+	m.QueryMock = mMetricStorageMockQuery{mock: m}
+	m.QueryMock.callArgs = []*MetricStorageMockQueryParams{}
+
 	t.Cleanup(m.MinimockFinish)
 
 	return m
@@ -1160,6 +1169,224 @@ func (m *MetricStorageMock) MinimockPingInspect() {
 	}
 }
 
+type mMetricStorageMockQuery struct {
+	mock               *MetricStorageMock
+	defaultExpectation *MetricStorageMockQueryExpectation
+	expectations       []*MetricStorageMockQueryExpectation
+
+	callArgs []*MetricStorageMockQueryParams
+	mutex    sync.RWMutex
+}
+
+// MetricStorageMockQueryExpectation specifies expectation struct of the MetricStorage.Query
+type MetricStorageMockQueryExpectation struct {
+	mock    *MetricStorageMock
+	params  *MetricStorageMockQueryParams
+	results *MetricStorageMockQueryResults
+	Counter uint64
+}
+
+// MetricStorageMockQueryParams contains parameters of the MetricStorage.Query
+type MetricStorageMockQueryParams struct {
+	ctx context.Context
+	q   metrics.Query
+}
+
+// MetricStorageMockQueryResults contains results of the MetricStorage.Query
+type MetricStorageMockQueryResults struct {
+	p1  metrics.Page
+	err error
+}
+
+// Expect sets up expected params for MetricStorage.Query
+func (mmQuery *mMetricStorageMockQuery) Expect(ctx context.Context, q metrics.Query) *mMetricStorageMockQuery {
+	if mmQuery.mock.funcQuery != nil {
+		mmQuery.mock.t.Fatalf("MetricStorageMock.Query mock is already set by Set")
+	}
+
+	if mmQuery.defaultExpectation == nil {
+		mmQuery.defaultExpectation = &MetricStorageMockQueryExpectation{}
+	}
+
+	mmQuery.defaultExpectation.params = &MetricStorageMockQueryParams{ctx, q}
+	for _, e := range mmQuery.expectations {
+		if minimock.Equal(e.params, mmQuery.defaultExpectation.params) {
+			mmQuery.mock.t.Fatalf("Expectation set by When has same params: %#v", *mmQuery.defaultExpectation.params)
+		}
+	}
+
+	return mmQuery
+}
+
+// Inspect accepts an inspector function that has same arguments as the MetricStorage.Query
+func (mmQuery *mMetricStorageMockQuery) Inspect(f func(ctx context.Context, q metrics.Query)) *mMetricStorageMockQuery {
+	if mmQuery.mock.inspectFuncQuery != nil {
+		mmQuery.mock.t.Fatalf("Inspect function is already set for MetricStorageMock.Query")
+	}
+
+	mmQuery.mock.inspectFuncQuery = f
+
+	return mmQuery
+}
+
+// Return sets up results that will be returned by MetricStorage.Query
+func (mmQuery *mMetricStorageMockQuery) Return(p1 metrics.Page, err error) *MetricStorageMock {
+	if mmQuery.mock.funcQuery != nil {
+		mmQuery.mock.t.Fatalf("MetricStorageMock.Query mock is already set by Set")
+	}
+
+	if mmQuery.defaultExpectation == nil {
+		mmQuery.defaultExpectation = &MetricStorageMockQueryExpectation{mock: mmQuery.mock}
+	}
+	mmQuery.defaultExpectation.results = &MetricStorageMockQueryResults{p1, err}
+	return mmQuery.mock
+}
+
+// Set uses given function f to mock the MetricStorage.Query method
+func (mmQuery *mMetricStorageMockQuery) Set(f func(ctx context.Context, q metrics.Query) (p1 metrics.Page, err error)) *MetricStorageMock {
+	if mmQuery.defaultExpectation != nil {
+		mmQuery.mock.t.Fatalf("Default expectation is already set for the MetricStorage.Query method")
+	}
+
+	if len(mmQuery.expectations) > 0 {
+		mmQuery.mock.t.Fatalf("Some expectations are already set for the MetricStorage.Query method")
+	}
+
+	mmQuery.mock.funcQuery = f
+	return mmQuery.mock
+}
+
+// When sets expectation for the MetricStorage.Query which will trigger the result defined by the following
+// Then helper
+func (mmQuery *mMetricStorageMockQuery) When(ctx context.Context, q metrics.Query) *MetricStorageMockQueryExpectation {
+	if mmQuery.mock.funcQuery != nil {
+		mmQuery.mock.t.Fatalf("MetricStorageMock.Query mock is already set by Set")
+	}
+
+	expectation := &MetricStorageMockQueryExpectation{
+		mock:   mmQuery.mock,
+		params: &MetricStorageMockQueryParams{ctx, q},
+	}
+	mmQuery.expectations = append(mmQuery.expectations, expectation)
+	return expectation
+}
+
+// Then sets up MetricStorage.Query return parameters for the expectation previously defined by the When method
+func (e *MetricStorageMockQueryExpectation) Then(p1 metrics.Page, err error) *MetricStorageMock {
+	e.results = &MetricStorageMockQueryResults{p1, err}
+	return e.mock
+}
+
+// Query implements repositories.MetricStorage
+func (mmQuery *MetricStorageMock) Query(ctx context.Context, q metrics.Query) (p1 metrics.Page, err error) {
+	mm_atomic.AddUint64(&mmQuery.beforeQueryCounter, 1)
+	defer mm_atomic.AddUint64(&mmQuery.afterQueryCounter, 1)
+
+	if mmQuery.inspectFuncQuery != nil {
+		mmQuery.inspectFuncQuery(ctx, q)
+	}
+
+	mm_params := MetricStorageMockQueryParams{ctx, q}
+
+	// Record call args
+	mmQuery.QueryMock.mutex.Lock()
+	mmQuery.QueryMock.callArgs = append(mmQuery.QueryMock.callArgs, &mm_params)
+	mmQuery.QueryMock.mutex.Unlock()
+
+	for _, e := range mmQuery.QueryMock.expectations {
+		if minimock.Equal(*e.params, mm_params) {
+			mm_atomic.AddUint64(&e.Counter, 1)
+			return e.results.p1, e.results.err
+		}
+	}
+
+	if mmQuery.QueryMock.defaultExpectation != nil {
+		mm_atomic.AddUint64(&mmQuery.QueryMock.defaultExpectation.Counter, 1)
+		mm_want := mmQuery.QueryMock.defaultExpectation.params
+		mm_got := MetricStorageMockQueryParams{ctx, q}
+		if mm_want != nil && !minimock.Equal(*mm_want, mm_got) {
+			mmQuery.t.Errorf("MetricStorageMock.Query got unexpected parameters, want: %#v, got: %#v%s\n", *mm_want, mm_got, minimock.Diff(*mm_want, mm_got))
+		}
+
+		mm_results := mmQuery.QueryMock.defaultExpectation.results
+		if mm_results != nil {
+			return (*mm_results).p1, (*mm_results).err
+		}
+		mmQuery.t.Fatal("No results are set for the MetricStorageMock.Query")
+
+	}
+	if mmQuery.funcQuery != nil {
+		return mmQuery.funcQuery(ctx, q)
+	}
+	mmQuery.t.Fatalf("Unexpected call to MetricStorageMock.Query. %v %v", ctx, q)
+	return
+}
+
+// QueryAfterCounter returns a count of finished MetricStorageMock.Query invocations
+func (mmQuery *MetricStorageMock) QueryAfterCounter() uint64 {
+	return mm_atomic.LoadUint64(&mmQuery.afterQueryCounter)
+}
+
+// QueryBeforeCounter returns a count of MetricStorageMock.Query invocations
+func (mmQuery *MetricStorageMock) QueryBeforeCounter() uint64 {
+	return mm_atomic.LoadUint64(&mmQuery.beforeQueryCounter)
+}
+
+// Calls returns a list of arguments used in each call to MetricStorageMock.Query.
+// The list is in the same order as the calls were made (i.e. recent calls have a higher index)
+func (mmQuery *mMetricStorageMockQuery) Calls() []*MetricStorageMockQueryParams {
+	mmQuery.mutex.RLock()
+
+	argCopy := make([]*MetricStorageMockQueryParams, len(mmQuery.callArgs))
+	copy(argCopy, mmQuery.callArgs)
+
+	mmQuery.mutex.RUnlock()
+
+	return argCopy
+}
+
+// MinimockQueryDone returns true if the count of the Query invocations corresponds
+// the number of defined expectations
+func (m *MetricStorageMock) MinimockQueryDone() bool {
+	for _, e := range m.QueryMock.expectations {
+		if mm_atomic.LoadUint64(&e.Counter) < 1 {
+			return false
+		}
+	}
+
+	// if default expectation was set then invocations count should be greater than zero
+	if m.QueryMock.defaultExpectation != nil && mm_atomic.LoadUint64(&m.afterQueryCounter) < 1 {
+		return false
+	}
+	// if func was set then invocations count should be greater than zero
+	if m.funcQuery != nil && mm_atomic.LoadUint64(&m.afterQueryCounter) < 1 {
+		return false
+	}
+	return true
+}
+
+// MinimockQueryInspect logs each unmet expectation
+func (m *MetricStorageMock) MinimockQueryInspect() {
+	for _, e := range m.QueryMock.expectations {
+		if mm_atomic.LoadUint64(&e.Counter) < 1 {
+			m.t.Errorf("Expected call to MetricStorageMock.Query with params: %#v", *e.params)
+		}
+	}
+
+	// if default expectation was set then invocations count should be greater than zero
+	if m.QueryMock.defaultExpectation != nil && mm_atomic.LoadUint64(&m.afterQueryCounter) < 1 {
+		if m.QueryMock.defaultExpectation.params == nil {
+			m.t.Error("Expected call to MetricStorageMock.Query")
+		} else {
+			m.t.Errorf("Expected call to MetricStorageMock.Query with params: %#v", *m.QueryMock.defaultExpectation.params)
+		}
+	}
+	// if func was set then invocations count should be greater than zero
+	if m.funcQuery != nil && mm_atomic.LoadUint64(&m.afterQueryCounter) < 1 {
+		m.t.Error("Expected call to MetricStorageMock.Query")
+	}
+}
+
 // MinimockFinish checks that all mocked methods have been called the expected number of times
 func (m *MetricStorageMock) MinimockFinish() {
 	m.finishOnce.Do(func() {
@@ -1173,6 +1400,8 @@ func (m *MetricStorageMock) MinimockFinish() {
 			m.MinimockListInspect()
 
 			m.MinimockPingInspect()
+
+			m.MinimockQueryInspect()
 			m.t.FailNow()
 		}
 	})
@@ -1201,5 +1430,6 @@ func (m *MetricStorageMock) minimockDone() bool {
 		m.MinimockBulkAddDone() &&
 		m.MinimockGetDone() &&
 		m.MinimockListDone() &&
-		m.MinimockPingDone()
+		m.MinimockPingDone() &&
+		m.MinimockQueryDone()
 }
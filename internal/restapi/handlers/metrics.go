@@ -1,18 +1,33 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/protocols"
+	"github.com/screamsoul/go-metrics-tpl/internal/protocols/influx"
+	"github.com/screamsoul/go-metrics-tpl/internal/protocols/prometheus"
 	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"github.com/screamsoul/go-metrics-tpl/pkg/idempotency"
 	"github.com/screamsoul/go-metrics-tpl/pkg/logging"
 	"go.uber.org/zap"
 )
 
+// bulkChunkSize the maximum number of metrics passed to MetricStorage.BulkAdd at once.
+const bulkChunkSize = 100
+
 type MetricServer struct {
 	store  repositories.MetricStorage
 	logger *zap.Logger
+	// сериализует импорт Prometheus, чтобы приращения счетчиков считались от сохраненных значений
+	prometheusMu sync.Mutex
 }
 
 func NewMetricServer(metricRepo repositories.MetricStorage) *MetricServer {
@@ -21,6 +36,39 @@ func NewMetricServer(metricRepo repositories.MetricStorage) *MetricServer {
 	return &MetricServer{store: metricRepo, logger: logger}
 }
 
+// labelsFromQuery reads the series labels from the url query parameters,
+// the reserved parameters are not treated as labels.
+func labelsFromQuery(r *http.Request, reserved ...string) (metrics.Labels, error) {
+	query := r.URL.Query()
+	for _, name := range reserved {
+		query.Del(name)
+	}
+	if len(query) == 0 {
+		return nil, nil
+	}
+
+	labels := make(metrics.Labels, len(query))
+	for name, values := range query {
+		labels[name] = values[len(values)-1]
+	}
+	return labels, labels.Validate()
+}
+
+// parseTimeParam parses the time in RFC3339 format or as unix seconds, empty value gives zero time.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time `%s` must be in RFC3339 format or unix seconds", value)
+	}
+	return t, nil
+}
+
 // PingStorage checks the connection to the database.
 func (ms *MetricServer) PingStorage(w http.ResponseWriter, r *http.Request) {
 	if !ms.store.Ping(r.Context()) {
@@ -30,6 +78,8 @@ func (ms *MetricServer) PingStorage(w http.ResponseWriter, r *http.Request) {
 }
 
 // UpdateMetricBulk handler, updates metrics, can accept multiple metrics in json format at once.
+// A batch with the idempotency.Header is applied at most once, a repeated batch is answered
+// with the same success response and the idempotency.ReplayedHeader.
 func (ms *MetricServer) UpdateMetricBulk(w http.ResponseWriter, r *http.Request) {
 	contentType := r.Header.Get("Content-Type")
 	if contentType != "application/json" {
@@ -37,6 +87,14 @@ func (ms *MetricServer) UpdateMetricBulk(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	batchKey := r.Header.Get(idempotency.Header)
+	if batchKey != "" {
+		if err := idempotency.Validate(batchKey); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	var metricsListChunk []metrics.Metrics
 
 	decoder := json.NewDecoder(r.Body)
@@ -58,7 +116,8 @@ func (ms *MetricServer) UpdateMetricBulk(w http.ResponseWriter, r *http.Request)
 		}
 
 		metricsListChunk = append(metricsListChunk, currentMetric)
-		if len(metricsListChunk) == 100 {
+		// a batch with a key is stored at once when the whole body is read
+		if batchKey == "" && len(metricsListChunk) == bulkChunkSize {
 			if err := ms.store.BulkAdd(r.Context(), metricsListChunk); err != nil {
 				ms.logger.Error("Error update metrics chunk", zap.Error(err))
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -66,7 +125,8 @@ func (ms *MetricServer) UpdateMetricBulk(w http.ResponseWriter, r *http.Request)
 			metricsListChunk = metricsListChunk[:0]
 		}
 	}
-	if len(metricsListChunk) > 0 {
+
+	if batchKey == "" && len(metricsListChunk) > 0 {
 		if err := ms.store.BulkAdd(r.Context(), metricsListChunk); err != nil {
 			ms.logger.Error("Error update metrics chunk", zap.Error(err))
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -77,6 +137,138 @@ func (ms *MetricServer) UpdateMetricBulk(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "bad json body", http.StatusBadRequest)
 		return
 	}
+
+	if batchKey != "" {
+		applied, err := ms.bulkAddOnce(r.Context(), batchKey, metricsListChunk)
+		if err != nil {
+			ms.logger.Error("Error update metrics batch", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !applied {
+			w.Header().Set(idempotency.ReplayedHeader, "true")
+		}
+	}
+}
+
+// bulkAddOnce stores the batch at most once per key, the batch is stored without the check
+// when the repository does not support idempotent updates.
+func (ms *MetricServer) bulkAddOnce(ctx context.Context, key string, metricList []metrics.Metrics) (bool, error) {
+	if is, ok := ms.store.(repositories.IdempotentStorage); ok {
+		applied, err := is.BulkAddOnce(ctx, key, metricList)
+		if !errors.Is(err, repositories.ErrIdempotencyDisabled) {
+			return applied, err
+		}
+	}
+	return true, ms.bulkAdd(ctx, metricList)
+}
+
+// bulkAdd stores the metrics through MetricStorage.BulkAdd in chunks of bulkChunkSize.
+func (ms *MetricServer) bulkAdd(ctx context.Context, metricList []metrics.Metrics) error {
+	for i := 0; i < len(metricList); i += bulkChunkSize {
+		end := min(i+bulkChunkSize, len(metricList))
+		if err := ms.store.BulkAdd(ctx, metricList[i:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeIngestReport stores the parsed metrics and responds with the ingest report in json format,
+// the request is considered bad if no metric was accepted but some lines were rejected.
+func (ms *MetricServer) writeIngestReport(
+	w http.ResponseWriter,
+	r *http.Request,
+	metricList []metrics.Metrics,
+	report *protocols.Report,
+) {
+	if err := ms.bulkAdd(r.Context(), metricList); err != nil {
+		ms.logger.Error("Error update metrics chunk", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report.Accepted = len(metricList)
+	if report.Rejected == nil {
+		report.Rejected = []protocols.LineError{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Accepted == 0 && len(report.Rejected) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		ms.logger.Error("Error writing response", zap.Error(err))
+	}
+}
+
+// UpdateMetricPrometheus handler, updates metrics passed in the Prometheus text exposition format,
+// responds with the number of accepted metrics and the rejected lines.
+func (ms *MetricServer) UpdateMetricPrometheus(w http.ResponseWriter, r *http.Request) {
+	var report protocols.Report
+
+	metricList, err := prometheus.Parse(r.Body, &report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ms.prometheusMu.Lock()
+	defer ms.prometheusMu.Unlock()
+
+	if err := ms.counterIncrements(r.Context(), metricList); err != nil {
+		ms.logger.Error("Error read stored counters", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ms.writeIngestReport(w, r, metricList, &report)
+}
+
+// counterIncrements replaces the cumulative totals of the counters by the increments since the stored totals,
+// so the repeated scrape does not add the same total again. A total below the stored one means
+// the counter has been reset and is added whole.
+func (ms *MetricServer) counterIncrements(ctx context.Context, metricList []metrics.Metrics) error {
+	totals := make(map[string]int64)
+	for i := range metricList {
+		m := &metricList[i]
+		if m.MType != metrics.Counter {
+			continue
+		}
+
+		key := m.SeriesKey()
+		total := *m.Delta
+		previous, ok := totals[key]
+		if !ok {
+			stored := metrics.Metrics{ID: m.ID, MType: m.MType, Labels: m.Labels}
+			err := ms.store.Get(ctx, &stored)
+			if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+				return err
+			}
+			if err == nil {
+				previous, ok = *stored.Delta, true
+			}
+		}
+		totals[key] = total
+
+		if ok && total >= previous {
+			increment := total - previous
+			m.Delta = &increment
+		}
+	}
+	return nil
+}
+
+// UpdateMetricInflux handler, updates metrics passed in the InfluxDB line protocol,
+// responds with the number of accepted metrics and the rejected lines.
+func (ms *MetricServer) UpdateMetricInflux(w http.ResponseWriter, r *http.Request) {
+	var report protocols.Report
+
+	metricList, err := influx.Parse(r.Body, &report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ms.writeIngestReport(w, r, metricList, &report)
 }
 
 // UpdateMetric handler, updates one metric.
@@ -99,6 +291,11 @@ func (ms *MetricServer) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		metriObjPoint.Labels, err = labelsFromQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		metricObj = *metriObjPoint
 	}
 
@@ -114,7 +311,8 @@ func (ms *MetricServer) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GetMetricValue handler, returns the metric value by type and name.
+// GetMetricValue handler, returns the metric value by type and name,
+// the series labels are passed as query parameters.
 func (ms *MetricServer) GetMetricValue(w http.ResponseWriter, r *http.Request) {
 
 	metricObj, err := metrics.NewMetric(
@@ -127,6 +325,12 @@ func (ms *MetricServer) GetMetricValue(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	metricObj.Labels, err = labelsFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	err = ms.store.Get(r.Context(), metricObj)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
@@ -162,7 +366,124 @@ func (ms *MetricServer) GetMetricJSON(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// ListMetrics handler, returns all current metrics
+// GetMetricHistory handler, returns the samples of the series in the [from, to] interval in json format,
+// the series labels are passed as query parameters.
+func (ms *MetricServer) GetMetricHistory(w http.ResponseWriter, r *http.Request) {
+	metricObj, err := metrics.NewMetric(
+		r.PathValue("metric_type"),
+		r.PathValue("metric_name"),
+		"",
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metricObj.Labels, err = labelsFromQuery(r, "from", "to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, err := parseTimeParam(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hs, ok := ms.store.(repositories.HistoryStorage)
+	if !ok {
+		http.Error(w, repositories.ErrHistoryDisabled.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	samples, err := hs.History(r.Context(), *metricObj, from, to)
+	if errors.Is(err, repositories.ErrHistoryDisabled) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if errors.Is(err, repositories.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ms.logger.Error("error read history", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(samples); err != nil {
+		ms.logger.Error("Error writing response", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ExportPrometheus handler, returns all current metrics in the Prometheus text exposition format
+func (ms *MetricServer) ExportPrometheus(w http.ResponseWriter, r *http.Request) {
+
+	metricList, err := ms.store.List(r.Context())
+	if err != nil {
+		ms.logger.Error("error read metrics", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", prometheus.ContentType)
+
+	if err := prometheus.Write(w, metricList); err != nil {
+		ms.logger.Error("Error writing response", zap.Error(err))
+	}
+}
+
+// QueryMetrics handler, returns a page of metrics in json format.
+//
+// Query parameters: `name` name prefix or glob pattern, `type` metric type (can be repeated
+// or comma separated), `order` asc or desc, `limit` page size, `cursor` next_cursor of the previous page.
+func (ms *MetricServer) QueryMetrics(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	q := metrics.Query{
+		Name:   params.Get("name"),
+		Types:  typesFromQuery(r),
+		Order:  metrics.Order(params.Get("order")),
+		Limit:  metrics.DefaultQueryLimit,
+		Cursor: params.Get("cursor"),
+	}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > metrics.MaxQueryLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", metrics.MaxQueryLimit), http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+	if err := q.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := ms.store.Query(r.Context(), q)
+	if err != nil {
+		ms.logger.Error("error query metrics", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		ms.logger.Error("Error writing response", zap.Error(err))
+	}
+}
+
+// ListMetrics handler, returns all current metrics as an html dashboard,
+// or in json format if the client accepts application/json.
 func (ms *MetricServer) ListMetrics(w http.ResponseWriter, r *http.Request) {
 
 	metrics, err := ms.store.List(r.Context())
@@ -173,7 +494,12 @@ func (ms *MetricServer) ListMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html")
+	if !wantsJSON(r) {
+		ms.renderDashboard(w, r, metrics)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 
 	if err := json.NewEncoder(w).Encode(metrics); err != nil {
 		ms.logger.Error("Error writing response", zap.Error(err))
@@ -7,13 +7,18 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/gojuno/minimock/v3"
 	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
 	"github.com/screamsoul/go-metrics-tpl/internal/restapi/handlers"
 	"github.com/screamsoul/go-metrics-tpl/internal/restapi/routers"
+	"github.com/screamsoul/go-metrics-tpl/pkg/idempotency"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -116,6 +121,28 @@ func (s *MetricRouterSuite) TestUpdateRouter() {
 			method: "POST",
 			status: http.StatusBadRequest,
 		},
+		{
+			name: "update histogram ok",
+			body: map[string]interface{}{"type": "histogram", "id": "latency", "histogram": map[string]interface{}{
+				"bounds": []float64{0.1, 1}, "counts": []int64{1, 2, 0}, "sum": 1.3, "count": 3,
+			}},
+			method: "POST",
+			status: http.StatusOK,
+		},
+		{
+			name: "update histogram inconsistent counts",
+			body: map[string]interface{}{"type": "histogram", "id": "latency", "histogram": map[string]interface{}{
+				"bounds": []float64{0.1, 1}, "counts": []int64{1, 2}, "sum": 1.3, "count": 3,
+			}},
+			method: "POST",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "update histogram without value",
+			body:   map[string]interface{}{"type": "histogram", "id": "latency"},
+			method: "POST",
+			status: http.StatusBadRequest,
+		},
 	}
 	for _, v := range testTable {
 		s.Suite.Run(v.name, func() {
@@ -226,6 +253,62 @@ func (s *MetricRouterSuite) TestUpdateBulkRouter() {
 	}
 }
 
+func (s *MetricRouterSuite) TestUpdateBulkIdempotency() {
+	memS := memory.NewMemStorage()
+	memS.EnableIdempotency(time.Minute, 10)
+
+	server := httptest.NewServer(routers.NewMetricRouter(handlers.NewMetricServer(memS)))
+	defer server.Close()
+
+	body := []map[string]interface{}{
+		{"type": "counter", "delta": 3, "id": "PollCount"},
+	}
+	send := func(key string) *resty.Response {
+		resp, err := resty.New().R().
+			SetHeader("Content-Type", "application/json").
+			SetHeader(idempotency.Header, key).
+			SetBody(body).
+			Post(server.URL + "/updates/")
+		s.Require().NoError(err)
+		return resp
+	}
+
+	var testTable = []struct {
+		name         string
+		key          string
+		status       int
+		replayed     bool
+		expectedPoll int64
+	}{
+		{name: "new batch", key: "batch1", status: http.StatusOK, expectedPoll: 3},
+		{name: "retried batch", key: "batch1", status: http.StatusOK, replayed: true, expectedPoll: 3},
+		{name: "next batch", key: "batch2", status: http.StatusOK, expectedPoll: 6},
+		{name: "invalid key", key: "batch 3", status: http.StatusBadRequest, expectedPoll: 6},
+		{name: "without key", key: "", status: http.StatusOK, expectedPoll: 9},
+		{name: "repeated without key", key: "", status: http.StatusOK, expectedPoll: 12},
+	}
+	for _, v := range testTable {
+		s.Suite.Run(v.name, func() {
+			resp := send(v.key)
+			s.Require().Equal(v.status, resp.StatusCode(), string(resp.Body()))
+			s.Equal(v.replayed, resp.Header().Get(idempotency.ReplayedHeader) == "true")
+
+			counter := metrics.Metrics{ID: "PollCount", MType: metrics.Counter}
+			s.Require().NoError(memS.Get(context.Background(), &counter))
+			s.Equal(v.expectedPoll, *counter.Delta)
+		})
+	}
+
+	// the storage without idempotent updates applies every batch
+	s.mockDB.BulkAddMock.Return(nil)
+	resp := s.serverRequest("POST", "/updates/", body, http.Header{
+		"Content-Type":     {"application/json"},
+		idempotency.Header: {"batch1"},
+	})
+	s.Equal(http.StatusOK, resp.StatusCode())
+	s.Equal(uint64(1), s.mockDB.BulkAddAfterCounter())
+}
+
 func (s *MetricRouterSuite) TestUpdateFromPath() {
 	s.mockDB.AddMock.Set(
 		func(ctx context.Context, m metrics.Metrics) error {
@@ -375,6 +458,131 @@ func (s *MetricRouterSuite) TestGetMetric() {
 
 }
 
+func (s *MetricRouterSuite) TestGetMetricWithLabels() {
+
+	s.mockDB.GetMock.Set(func(ctx context.Context, m *metrics.Metrics) (err error) {
+		var intValue int64 = 7
+		if m.MType == metrics.Counter && m.ID == "requests" && m.Labels["host"] == "a" && m.Labels["service"] == "api" {
+			m.Delta = &intValue
+			return nil
+		}
+		return errors.New("not found")
+	})
+
+	var testTable = []struct {
+		name           string
+		path           string
+		body           map[string]interface{}
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "value by query labels",
+			path:           "/value/counter/requests?host=a&service=api",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "7",
+		},
+		{
+			name:           "value without labels",
+			path:           "/value/counter/requests",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid label name",
+			path:           "/value/counter/requests?1host=a",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, v := range testTable {
+		s.Suite.Run(v.name, func() {
+			resp := s.serverRequest("GET", v.path, nil)
+			s.Require().Equal(v.expectedStatus, resp.StatusCode(), fmt.Sprintf("Resp body: %s", string(resp.Body())))
+			if v.expectedBody != "" {
+				s.Equal(v.expectedBody, string(resp.Body()))
+			}
+		})
+	}
+
+	resp := s.serverRequest(
+		"POST",
+		"/value/",
+		map[string]interface{}{"type": "counter", "id": "requests", "labels": map[string]string{"host": "a", "service": "api"}},
+		http.Header{"Content-Type": {"application/json"}},
+	)
+	s.Require().Equal(http.StatusOK, resp.StatusCode())
+	s.JSONEq(`{"type": "counter", "id": "requests", "delta": 7, "labels": {"host": "a", "service": "api"}}`, string(resp.Body()))
+}
+
+func (s *MetricRouterSuite) TestGetMetricHistory() {
+	resp := s.serverRequest("GET", "/history/gauge/load", nil)
+	s.Require().Equal(http.StatusNotImplemented, resp.StatusCode(), "storage without history")
+
+	memS := memory.NewMemStorage()
+	memS.EnableHistory(2)
+	for _, v := range []float64{1, 2, 3} {
+		value := v
+		s.Require().NoError(memS.Add(context.Background(), metrics.Metrics{
+			ID: "load", MType: metrics.Gauge, Value: &value, Labels: metrics.Labels{"host": "a"},
+		}))
+	}
+
+	server := httptest.NewServer(routers.NewMetricRouter(handlers.NewMetricServer(memS)))
+	defer server.Close()
+
+	var testTable = []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedValues []float64
+	}{
+		{
+			name:           "last samples of series",
+			path:           "/history/gauge/load?host=a",
+			expectedStatus: http.StatusOK,
+			expectedValues: []float64{2, 3},
+		},
+		{
+			name:           "interval in unix seconds",
+			path:           "/history/gauge/load?host=a&from=0&to=1",
+			expectedStatus: http.StatusOK,
+			expectedValues: []float64{},
+		},
+		{
+			name:           "unknown series",
+			path:           "/history/gauge/load",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid time",
+			path:           "/history/gauge/load?host=a&from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid type",
+			path:           "/history/unknown/load",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, v := range testTable {
+		s.Suite.Run(v.name, func() {
+			resp, err := resty.New().R().Get(server.URL + v.path)
+			s.Require().NoError(err)
+			s.Require().Equal(v.expectedStatus, resp.StatusCode(), fmt.Sprintf("Resp body: %s", string(resp.Body())))
+			if v.expectedValues == nil {
+				return
+			}
+
+			var samples []metrics.Sample
+			s.Require().NoError(json.Unmarshal(resp.Body(), &samples))
+			values := make([]float64, 0, len(samples))
+			for _, sample := range samples {
+				values = append(values, *sample.Value)
+			}
+			s.Equal(v.expectedValues, values)
+		})
+	}
+}
+
 func (s *MetricRouterSuite) TestListMetrics() {
 
 	var intValue int64 = 1
@@ -411,9 +619,10 @@ func (s *MetricRouterSuite) TestListMetrics() {
 	for _, v := range testTable {
 		s.Suite.Run(v.name, func() {
 			v.mock()
-			resp := s.serverRequest(v.method, "/", nil)
+			resp := s.serverRequest(v.method, "/", nil, http.Header{"Accept": {"application/json"}})
 			s.Require().Equal(v.status, resp.StatusCode())
 			if v.status == http.StatusOK {
+				s.Equal("application/json", resp.Header().Get("Content-Type"))
 				var mList []metrics.Metrics
 				err := json.Unmarshal(resp.Body(), &mList)
 				s.Require().NoError(err, string(resp.Body()))
@@ -425,6 +634,226 @@ func (s *MetricRouterSuite) TestListMetrics() {
 
 }
 
+func (s *MetricRouterSuite) TestListMetricsDashboard() {
+	var gaugeValue = 0.5
+	var counterValue int64 = 10
+
+	s.mockDB.ListMock.Set(func(ctx context.Context) ([]metrics.Metrics, error) {
+		return []metrics.Metrics{
+			{ID: "Alloc", MType: metrics.Gauge, Value: &gaugeValue},
+			{ID: "Frees", MType: metrics.Gauge, Value: &gaugeValue},
+			{ID: "PollCount", MType: metrics.Counter, Delta: &counterValue, Labels: metrics.Labels{"host": "a"}},
+		}, nil
+	})
+
+	resp := s.serverRequest("GET", "/", nil, http.Header{"Accept": {"text/html"}})
+	s.Require().Equal(http.StatusOK, resp.StatusCode())
+	s.Equal("text/html; charset=utf-8", resp.Header().Get("Content-Type"))
+	body := string(resp.Body())
+	s.Contains(body, `<meta http-equiv="refresh" content="10">`)
+	s.Contains(body, "<h2>Gauges (2)</h2>")
+	s.Contains(body, "<h2>Counters (1)</h2>")
+	s.Contains(body, `host=&#34;a&#34;`)
+	s.NotContains(body, "<th>history</th>")
+	s.Less(strings.Index(body, "Alloc"), strings.Index(body, "Frees"))
+
+	resp = s.serverRequest("GET", "/?q=free&sort=desc&refresh=0", nil)
+	s.Require().Equal(http.StatusOK, resp.StatusCode())
+	body = string(resp.Body())
+	s.NotContains(body, "http-equiv")
+	s.Contains(body, "Frees")
+	s.NotContains(body, "Alloc")
+	s.NotContains(body, "Counters")
+}
+
+func (s *MetricRouterSuite) TestListMetricsDashboardHistory() {
+	memS := memory.NewMemStorage()
+	memS.EnableHistory(10)
+	for _, v := range []float64{1, 3, 2} {
+		value := v
+		s.Require().NoError(memS.Add(context.Background(), metrics.Metrics{ID: "load", MType: metrics.Gauge, Value: &value}))
+	}
+
+	store := &historyRecorder{MemStorage: memS}
+	server := httptest.NewServer(routers.NewMetricRouter(handlers.NewMetricServer(store)))
+	defer server.Close()
+
+	resp, err := resty.New().R().Get(server.URL + "/")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode())
+	s.Contains(string(resp.Body()), "<th>history</th>")
+	s.Contains(string(resp.Body()), `<polyline points="2.0,22.0 60.0,2.0 118.0,12.0"/>`)
+
+	// the history of the series is read for the recent period only
+	s.Require().Len(store.from, 1)
+	s.WithinDuration(time.Now().Add(-time.Hour), store.from[0], time.Minute)
+}
+
+// The number of the history queries of the page is bounded, the failed query does not break the page.
+func (s *MetricRouterSuite) TestListMetricsDashboardHistoryLimit() {
+	memS := memory.NewMemStorage()
+	memS.EnableHistory(10)
+	for i := 0; i < 30; i++ {
+		value := float64(i)
+		s.Require().NoError(memS.Add(context.Background(), metrics.Metrics{ID: fmt.Sprintf("load%02d", i), MType: metrics.Gauge, Value: &value}))
+	}
+
+	store := &historyRecorder{MemStorage: memS, err: errors.New("connection refused")}
+	server := httptest.NewServer(routers.NewMetricRouter(handlers.NewMetricServer(store)))
+	defer server.Close()
+
+	resp, err := resty.New().R().Get(server.URL + "/")
+	s.Require().NoError(err)
+	s.Require().Equal(http.StatusOK, resp.StatusCode())
+	s.Contains(string(resp.Body()), "load29")
+	s.Contains(string(resp.Body()), "<th>history</th>")
+	s.Len(store.from, 20)
+}
+
+// historyRecorder records the start of the requested history periods, the history fails with err if it is set.
+type historyRecorder struct {
+	*memory.MemStorage
+	from []time.Time
+	err  error
+}
+
+func (r *historyRecorder) History(ctx context.Context, m metrics.Metrics, from, to time.Time) ([]metrics.Sample, error) {
+	r.from = append(r.from, from)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.MemStorage.History(ctx, m, from, to)
+}
+
+func (s *MetricRouterSuite) TestQueryMetrics() {
+	var gaugeValue = 0.5
+	cursor := metrics.NewCursor(metrics.Metrics{ID: "Alloc", MType: metrics.Gauge})
+
+	s.mockDB.QueryMock.Set(func(ctx context.Context, q metrics.Query) (metrics.Page, error) {
+		s.Equal(metrics.Query{
+			Name:  "Heap*",
+			Types: []metrics.MetricType{metrics.Gauge, metrics.Counter},
+			Order: metrics.Desc,
+			Limit: 1,
+		}, q)
+		return metrics.Page{
+			Metrics:    []metrics.Metrics{{ID: "Alloc", MType: metrics.Gauge, Value: &gaugeValue}},
+			NextCursor: cursor,
+		}, nil
+	})
+
+	resp := s.serverRequest("GET", "/query?name=Heap*&type=gauge,counter&order=desc&limit=1", nil)
+	s.Require().Equal(http.StatusOK, resp.StatusCode(), string(resp.Body()))
+	s.JSONEq(fmt.Sprintf(`{"metrics": [{"id": "Alloc", "type": "gauge", "value": 0.5}], "next_cursor": %q}`, cursor), string(resp.Body()))
+
+	for _, path := range []string{
+		"/query?type=timer",
+		"/query?order=up",
+		"/query?limit=0",
+		"/query?limit=100000",
+		"/query?cursor=bad",
+	} {
+		resp := s.serverRequest("GET", path, nil)
+		s.Equal(http.StatusBadRequest, resp.StatusCode(), path)
+	}
+}
+
+func (s *MetricRouterSuite) TestExportPrometheus() {
+	var gaugeValue = 0.5
+	var counterValue int64 = 10
+
+	s.mockDB.ListMock.Return([]metrics.Metrics{
+		{ID: "Alloc", MType: metrics.Gauge, Value: &gaugeValue},
+		{ID: "Poll.Count", MType: metrics.Counter, Delta: &counterValue, Labels: metrics.Labels{"host": "a"}},
+	}, nil)
+
+	resp := s.serverRequest("GET", "/metrics", nil)
+	s.Require().Equal(http.StatusOK, resp.StatusCode())
+	s.Equal("text/plain; version=0.0.4; charset=utf-8", resp.Header().Get("Content-Type"))
+	s.Equal("# TYPE Alloc gauge\nAlloc 0.5\n# TYPE Poll_Count counter\nPoll_Count{host=\"a\"} 10\n", string(resp.Body()))
+}
+
+func (s *MetricRouterSuite) TestUpdatePrometheus() {
+	var stored []metrics.Metrics
+	s.mockDB.BulkAddMock.Set(func(ctx context.Context, m []metrics.Metrics) error {
+		s.LessOrEqual(len(m), 100)
+		stored = append(stored, m...)
+		return nil
+	})
+
+	var payload strings.Builder
+	payload.WriteString("# TYPE temperature gauge\n")
+	for i := 0; i < 150; i++ {
+		fmt.Fprintf(&payload, "temperature{sensor=\"%d\"} 21.5\n", i)
+	}
+	payload.WriteString("bad line\n")
+
+	resp := s.serverRequest("POST", "/import/prometheus", payload.String())
+	s.Require().Equal(http.StatusOK, resp.StatusCode(), string(resp.Body()))
+	s.JSONEq(`{"accepted": 150, "rejected": [{"line": 152, "error": "value `+"`line`"+` is not valid"}]}`, string(resp.Body()))
+	s.Len(stored, 150)
+
+	resp = s.serverRequest("POST", "/import/prometheus", "temperature 1\n")
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+}
+
+// The counter samples are cumulative, the repeated push of the same total does not increase the counter.
+func (s *MetricRouterSuite) TestUpdatePrometheusCounter() {
+	counters := make(map[string]int64)
+	s.mockDB.GetMock.Set(func(ctx context.Context, m *metrics.Metrics) error {
+		total, ok := counters[m.SeriesKey()]
+		if !ok {
+			return repositories.ErrNotFound
+		}
+		m.Delta = &total
+		return nil
+	})
+	s.mockDB.BulkAddMock.Set(func(ctx context.Context, list []metrics.Metrics) error {
+		for _, m := range list {
+			counters[m.SeriesKey()] += *m.Delta
+		}
+		return nil
+	})
+
+	pushes := []struct {
+		payload string
+		want    int64
+	}{
+		{"requests_total{code=\"200\"} 10\n", 10},
+		{"requests_total{code=\"200\"} 10\n", 10},
+		{"requests_total{code=\"200\"} 15\n", 15},
+		{"requests_total{code=\"200\"} 17\nrequests_total{code=\"200\"} 20\n", 20},
+		// the counter has been reset by the restart of the exporter
+		{"requests_total{code=\"200\"} 3\n", 23},
+	}
+	series := metrics.Metrics{ID: "requests_total", Labels: metrics.Labels{"code": "200"}}
+	for _, push := range pushes {
+		resp := s.serverRequest("POST", "/import/prometheus", "# TYPE requests counter\n"+push.payload)
+		s.Require().Equal(http.StatusOK, resp.StatusCode(), string(resp.Body()))
+		s.Equal(push.want, counters[series.SeriesKey()], push.payload)
+	}
+}
+
+func (s *MetricRouterSuite) TestUpdateInflux() {
+	var stored []metrics.Metrics
+	s.mockDB.BulkAddMock.Set(func(ctx context.Context, m []metrics.Metrics) error {
+		stored = append(stored, m...)
+		return nil
+	})
+
+	payload := "cpu,host=a usage=0.5,ticks=10i 1700000000000000000\ncpu usage=\n"
+
+	resp := s.serverRequest("POST", "/write", payload)
+	s.Require().Equal(http.StatusOK, resp.StatusCode(), string(resp.Body()))
+	s.JSONEq(`{"accepted": 2, "rejected": [{"line": 2, "error": "field `+"`usage=`"+` must be in key=value format"}]}`, string(resp.Body()))
+	s.Require().Len(stored, 2)
+	s.Equal(metrics.Gauge, stored[0].MType)
+	s.Equal(metrics.Counter, stored[1].MType)
+
+	resp = s.serverRequest("POST", "/write", "cpu\n")
+	s.Equal(http.StatusBadRequest, resp.StatusCode())
+}
+
 func (s *MetricRouterSuite) TestPingStorage() {
 
 	var testTable = []struct {
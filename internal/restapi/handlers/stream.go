@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"github.com/screamsoul/go-metrics-tpl/internal/restapi/streaming"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+// streamKeepAlive the interval of keep-alive comments in the event stream.
+const streamKeepAlive = 15 * time.Second
+
+// hijackableWriter exposes the hijacking of the wrapped response writers
+// to the websocket server, which requires http.Hijacker.
+type hijackableWriter struct {
+	http.ResponseWriter
+}
+
+func (w hijackableWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// typesFromQuery reads the metric types from the repeated or comma separated `type` parameter.
+func typesFromQuery(r *http.Request) []metrics.MetricType {
+	var types []metrics.MetricType
+	for _, value := range r.URL.Query()["type"] {
+		for _, t := range strings.Split(value, ",") {
+			types = append(types, metrics.MetricType(t))
+		}
+	}
+	return types
+}
+
+// StreamMetrics handler, pushes every accepted update matching the optional `name` prefix or glob
+// and `type` filter. WebSocket is used for upgrade requests, Server-Sent Events for the requests
+// accepting text/event-stream, other requests are answered with 406, so the middlewares never
+// buffer the stream. Counter updates carry the accepted delta, not the accumulated value.
+func (ms *MetricServer) StreamMetrics(w http.ResponseWriter, r *http.Request) {
+	ss, ok := ms.store.(repositories.StreamStorage)
+	if !ok {
+		http.Error(w, "metric stream is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	q := metrics.Query{Name: r.URL.Query().Get("name"), Types: typesFromQuery(r)}
+	if err := q.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !streaming.IsStreaming(r) {
+		http.Error(w, "WebSocket upgrade or Accept: "+streaming.EventStreamType+" is required", http.StatusNotAcceptable)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	updates, err := ss.Subscribe(ctx, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if streaming.IsWebSocket(r) {
+		ms.streamWebSocket(ctx, cancel, updates, w, r)
+		return
+	}
+	ms.streamSSE(ctx, updates, w)
+}
+
+func (ms *MetricServer) streamSSE(ctx context.Context, updates <-chan metrics.Metrics, w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", streaming.EventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		ms.logger.Error("stream flush", zap.Error(err))
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			_, err = w.Write([]byte(": keep-alive\n\n"))
+		case m, ok := <-updates:
+			if !ok {
+				// the subscriber was dropped for falling behind
+				_, _ = w.Write([]byte("event: dropped\ndata: {}\n\n"))
+				_ = rc.Flush()
+				return
+			}
+			var data []byte
+			data, err = json.Marshal(m)
+			if err == nil {
+				_, err = w.Write([]byte("event: metric\ndata: " + string(data) + "\n\n"))
+			}
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (ms *MetricServer) streamWebSocket(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	updates <-chan metrics.Metrics,
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	// the server without handshake accepts connections from any origin, access is restricted by the middlewares.
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		// the client is not expected to send anything, reading only detects the closed connection
+		go func() {
+			defer cancel()
+			var msg string
+			for websocket.Message.Receive(ws, &msg) == nil {
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-updates:
+				if !ok {
+					return
+				}
+				if err := websocket.JSON.Send(ws, m); err != nil {
+					return
+				}
+			}
+		}
+	}}
+	server.ServeHTTP(hijackableWriter{w}, r)
+}
@@ -0,0 +1,112 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/broadcast"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/screamsoul/go-metrics-tpl/internal/restapi/handlers"
+	"github.com/screamsoul/go-metrics-tpl/internal/restapi/middlewares"
+	"github.com/screamsoul/go-metrics-tpl/internal/restapi/routers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func newStreamServer(t *testing.T) (*httptest.Server, *broadcast.BroadcastMetricWrapper) {
+	store := broadcast.NewBroadcastMetricWrapper(memory.NewMemStorage(), broadcast.NewHub())
+	server := httptest.NewServer(routers.NewMetricRouter(
+		handlers.NewMetricServer(store),
+		middlewares.LoggingMiddleware,
+		middlewares.GzipCompressMiddleware,
+	))
+	t.Cleanup(server.Close)
+	return server, store
+}
+
+func TestStreamMetricsSSE(t *testing.T) {
+	server, store := newStreamServer(t)
+
+	req, err := http.NewRequest("GET", server.URL+"/stream?name=cpu*&type=gauge", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+	value := 0.5
+	delta := int64(1)
+	ctx := context.Background()
+	require.NoError(t, store.Add(ctx, metrics.Metrics{ID: "mem", MType: metrics.Gauge, Value: &value}))
+	require.NoError(t, store.BulkAdd(ctx, []metrics.Metrics{
+		{ID: "cpu0", MType: metrics.Counter, Delta: &delta},
+		{ID: "cpu0", MType: metrics.Gauge, Value: &value},
+	}))
+
+	reader := bufio.NewReader(resp.Body)
+	event, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event: metric\n", event)
+
+	data, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	var m metrics.Metrics
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &m))
+	assert.Equal(t, metrics.Metrics{ID: "cpu0", MType: metrics.Gauge, Value: &value}, m)
+}
+
+func TestStreamMetricsWebSocket(t *testing.T) {
+	server, store := newStreamServer(t)
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/stream?type=counter", "", server.URL)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	value := 0.5
+	delta := int64(3)
+	ctx := context.Background()
+	require.NoError(t, store.Add(ctx, metrics.Metrics{ID: "mem", MType: metrics.Gauge, Value: &value}))
+	require.NoError(t, store.Add(ctx, metrics.Metrics{ID: "hits", MType: metrics.Counter, Delta: &delta}))
+
+	var m metrics.Metrics
+	require.NoError(t, websocket.JSON.Receive(ws, &m))
+	assert.Equal(t, metrics.Metrics{ID: "hits", MType: metrics.Counter, Delta: &delta}, m)
+}
+
+// The request which is neither an upgrade nor accepts the event stream would be buffered by the middlewares.
+func TestStreamMetricsNotAcceptable(t *testing.T) {
+	server, _ := newStreamServer(t)
+
+	resp, err := http.Get(server.URL + "/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotAcceptable, resp.StatusCode)
+}
+
+func TestStreamMetricsDisabled(t *testing.T) {
+	server := httptest.NewServer(routers.NewMetricRouter(handlers.NewMetricServer(memory.NewMemStorage())))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	server2, _ := newStreamServer(t)
+	resp2, err := http.Get(server2.URL + "/stream?type=timer")
+	require.NoError(t, err)
+	defer resp2.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp2.StatusCode)
+}
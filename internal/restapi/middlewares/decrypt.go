@@ -2,35 +2,51 @@ package middlewares
 
 import (
 	"bytes"
-	"crypto/rand"
 	"crypto/rsa"
 	"io"
 	"net/http"
+
+	"github.com/screamsoul/go-metrics-tpl/pkg/envelope"
 )
 
-func NewDecryptMiddleware(privateKey *rsa.PrivateKey) func(next http.Handler) http.Handler {
+// NewDecryptMiddleware opens the hybrid encryption envelope of the body marked with the envelope.Header.
+// Any of the private keys is accepted, so the key can be rotated while agents still use the previous one.
+// The unmarked requests and the empty bodies are passed unchanged.
+func NewDecryptMiddleware(privateKeys ...*rsa.PrivateKey) func(next http.Handler) http.Handler {
+	keyring := envelope.NewKeyring(privateKeys...)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if privateKey != nil {
-				ciphertext, err := io.ReadAll(r.Body)
-				if err != nil {
-					http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-					return
-				}
+			scheme := r.Header.Get(envelope.Header)
+			if keyring.Len() == 0 || scheme == "" || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if scheme != envelope.Scheme {
+				http.Error(w, "Unsupported encryption", http.StatusBadRequest)
+				return
+			}
 
-				// Decrypt the ciphertext
-				plaintext, err := rsa.DecryptPKCS1v15(rand.Reader, privateKey, ciphertext)
+			ciphertext, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+				return
+			}
+			if len(ciphertext) > 0 {
+				plaintext, err := keyring.Open(ciphertext)
 				if err != nil {
 					http.Error(w, "Decryption failed", http.StatusBadRequest)
 					return
 				}
-
-				// Replace r.Body with a new reader reading from plaintext
-				r.Body = io.NopCloser(bytes.NewReader(plaintext))
+				ciphertext = plaintext
 			}
 
-			next.ServeHTTP(w, r)
+			// Replace r.Body with a new reader reading from plaintext
+			r.Body = io.NopCloser(bytes.NewReader(ciphertext))
+			r.Header.Del(envelope.Header)
+			r.ContentLength = int64(len(ciphertext))
 
+			next.ServeHTTP(w, r)
 		})
 	}
 }
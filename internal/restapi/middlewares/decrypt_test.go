@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/screamsoul/go-metrics-tpl/pkg/envelope"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,16 +24,17 @@ func TestNewDecryptMiddleware(t *testing.T) {
 
 	// Encrypt some plaintext
 	plaintext := []byte("test plaintext")
-	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, plaintext)
+	ciphertext, err := envelope.Seal(publicKey, plaintext)
 	if err != nil {
 		t.Fatalf("Failed to encrypt plaintext: %v", err)
 	}
 
 	// Create a mock request with the ciphertext as body
-	req, err := http.NewRequest("GET", "/", io.NopCloser(bytes.NewReader(ciphertext)))
+	req, err := http.NewRequest("POST", "/updates/", io.NopCloser(bytes.NewReader(ciphertext)))
 	if err != nil {
 		t.Fatalf("Failed to create mock request: %v", err)
 	}
+	req.Header.Set(envelope.Header, envelope.Scheme)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -51,3 +53,95 @@ func TestNewDecryptMiddleware(t *testing.T) {
 	// Check if the body has been correctly decrypted and echoed back
 	assert.Equal(t, string(plaintext), rr.Body.String())
 }
+
+func TestNewDecryptMiddlewareKeyRotation(t *testing.T) {
+	currentKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	previousKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	unknownKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	// a batch larger than a single RSA block
+	plaintext := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1}`), 100)
+	legacy, err := rsa.EncryptPKCS1v15(rand.Reader, &currentKey.PublicKey, plaintext[:100])
+	assert.NoError(t, err)
+
+	seal := func(key *rsa.PrivateKey) []byte {
+		ciphertext, err := envelope.Seal(&key.PublicKey, plaintext)
+		assert.NoError(t, err)
+		return ciphertext
+	}
+
+	tests := []struct {
+		name       string
+		body       []byte
+		wantStatus int
+	}{
+		{"current key", seal(currentKey), http.StatusOK},
+		{"previous key", seal(previousKey), http.StatusOK},
+		{"unknown key", seal(unknownKey), http.StatusBadRequest},
+		{"raw rsa", legacy, http.StatusBadRequest},
+	}
+
+	handler := NewDecryptMiddleware(currentKey, previousKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.Copy(w, r.Body)
+		assert.NoError(t, err)
+	}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			req.Header.Set(envelope.Header, envelope.Scheme)
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, string(plaintext), rr.Body.String())
+			}
+		})
+	}
+}
+
+// Only the bodies marked as the envelope are decrypted when the key is configured.
+func TestNewDecryptMiddlewarePlaintext(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	handler := NewDecryptMiddleware(privateKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.Copy(w, r.Body)
+		assert.NoError(t, err)
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		scheme     string
+		wantStatus int
+	}{
+		{"get", http.MethodGet, "/value/counter/PollCount", "", "", http.StatusOK},
+		{"ping", http.MethodGet, "/ping", "", "", http.StatusOK},
+		{"plaintext write", http.MethodPost, "/write", "cpu,host=a value=1", "", http.StatusOK},
+		{"plaintext update", http.MethodPost, "/update/", `{"id":"Alloc","type":"gauge","value":1}`, "", http.StatusOK},
+		{"empty envelope", http.MethodPost, "/updates/", "", envelope.Scheme, http.StatusOK},
+		{"not an envelope", http.MethodPost, "/updates/", "[]", envelope.Scheme, http.StatusBadRequest},
+		{"unknown scheme", http.MethodPost, "/updates/", "[]", "pgp", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, bytes.NewBufferString(tt.body))
+			if tt.scheme != "" {
+				req.Header.Set(envelope.Header, tt.scheme)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.body, rr.Body.String())
+			}
+		})
+	}
+}
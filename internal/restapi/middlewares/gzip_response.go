@@ -6,6 +6,7 @@ import (
 	"slices"
 	"strings"
 
+	"github.com/screamsoul/go-metrics-tpl/internal/restapi/streaming"
 	"github.com/screamsoul/go-metrics-tpl/pkg/utils"
 )
 
@@ -39,7 +40,8 @@ func (grw *gzipResponseWriter) Close() error {
 func GzipCompressMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ow := w
-		supportsGzip := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+		// streaming responses must reach the client without buffering in the compressor
+		supportsGzip := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") && !streaming.IsStreaming(r)
 		gzipContentType := slices.Contains(
 			[]string{"application/json", "text/html", ""},
 			r.Header.Get("Content-Type"),
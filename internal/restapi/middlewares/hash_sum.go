@@ -1,42 +1,118 @@
 package middlewares
 
 import (
-	"crypto/sha256"
-	"fmt"
+	"bytes"
+	"errors"
 	"io"
 	"net/http"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/restapi/streaming"
+	"github.com/screamsoul/go-metrics-tpl/pkg/replay"
+	"github.com/screamsoul/go-metrics-tpl/pkg/signature"
 )
 
-func NewHashSumHeaderMiddleware(hashKey string) func(next http.Handler) http.Handler {
+// signingResponseWriter buffers the response to sign the whole body before it is sent.
+type signingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *signingResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *signingResponseWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+// flush signs the buffered body and sends the response.
+func (w *signingResponseWriter) flush(keys signature.Keys) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.Header().Set(signature.Header, keys.Sign(w.body.Bytes()))
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(w.body.Bytes())
+}
+
+// readOnlyMethods the methods which do not change the storage and may be sent without the signature.
+var readOnlyMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+// NewHashSumHeaderMiddleware verifies the signature of the request, see package signature
+// for the scheme. It must be placed before the decryption and decompression middlewares,
+// so it checks the bytes as they are transmitted. When the keys are set, only the read requests
+// (GET, HEAD, OPTIONS) may come without the signature, unsigned writes are rejected with 401.
+//
+// When the guard is set, signed requests outside of its clock skew window or with a nonce
+// already seen are rejected.
+func NewHashSumHeaderMiddleware(keys signature.Keys, guard *replay.Guard) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			bodyHash := r.Header.Get("HashSHA256")
-
-			if bodyHash == "" || hashKey == "" {
+			if !keys.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			bodyHash := r.Header.Get(signature.Header)
+			if bodyHash == "" {
+				if !readOnlyMethods[r.Method] {
+					http.Error(w, "signature is required", http.StatusUnauthorized)
+					return
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			h := sha256.New()
-
-			buf := make([]byte, 4096) // 4KB buffer
-
-			if _, err := io.CopyBuffer(h, r.Body, buf); err != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
 				http.Error(w, "", http.StatusInternalServerError)
 				return
 			}
-
-			h.Write([]byte(hashKey))
-
-			dst := h.Sum(nil)
-
-			if bodyHash != fmt.Sprintf("%x", dst) {
+			timestamp, nonce := r.Header.Get(signature.TimestampHeader), r.Header.Get(signature.NonceHeader)
+			parts := signature.HTTPRequestParts(r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+			if !keys.Verify(bodyHash, parts...) {
 				http.Error(w, "The data is corrupted", http.StatusBadRequest)
 				return
 			}
 
+			if guard != nil {
+				err := guard.Check(signature.ParseTimestamp(timestamp), nonce)
+				if errors.Is(err, replay.ErrCacheFull) {
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
 			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NewSignResponseMiddleware signs the response body with the current key. It must be placed after
+// the compression middleware, so the body is signed before the Content-Encoding is applied.
+// Streaming responses are not signed.
+func NewSignResponseMiddleware(keys signature.Keys) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !keys.Enabled() || streaming.IsStreaming(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
 
+			sw := &signingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			sw.flush(keys)
 		})
 	}
 }
@@ -47,3 +47,8 @@ func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
 	lw.size += size
 	return size, err
 }
+
+// Unwrap gives http.ResponseController access to the flushing and hijacking of the original writer.
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
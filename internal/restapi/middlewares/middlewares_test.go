@@ -8,9 +8,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/screamsoul/go-metrics-tpl/pkg/ipmask"
+	"github.com/screamsoul/go-metrics-tpl/pkg/replay"
+	"github.com/screamsoul/go-metrics-tpl/pkg/signature"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGzipDecompressMiddleware(t *testing.T) {
@@ -169,9 +173,23 @@ func TestNewHashSumHeaderMiddleware(t *testing.T) {
 			name:           "Valid hash",
 			hashKey:        "testKey",
 			requestBody:    "testBody",
-			headerHash:     "33899393cccd71ea35f6340d8a70b2e1910d4de0f2c1c5c0befea38b27aecfca", // SHA256("testBodytestKey")
+			headerHash:     signature.Sign("testKey", signature.HTTPRequestParts("GET", "/", "", "", []byte("testBody"))...),
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name:           "Previous key",
+			hashKey:        "newKey,testKey",
+			requestBody:    "testBody",
+			headerHash:     signature.Sign("testKey", signature.HTTPRequestParts("GET", "/", "", "", []byte("testBody"))...),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Retired key",
+			hashKey:        "newKey",
+			requestBody:    "testBody",
+			headerHash:     signature.Sign("testKey", signature.HTTPRequestParts("GET", "/", "", "", []byte("testBody"))...),
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name:           "Invalid hash",
 			hashKey:        "testKey",
@@ -209,7 +227,7 @@ func TestNewHashSumHeaderMiddleware(t *testing.T) {
 			rr := httptest.NewRecorder()
 
 			// Create the middleware with the test case's hash key
-			middleware := NewHashSumHeaderMiddleware(tc.hashKey)
+			middleware := NewHashSumHeaderMiddleware(signature.ParseKeys(tc.hashKey), nil)
 
 			// Wrap the ResponseRecorder in the middleware
 			handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -225,6 +243,160 @@ func TestNewHashSumHeaderMiddleware(t *testing.T) {
 	}
 }
 
+// Only the read requests may come without the signature when the keys are set.
+func TestNewHashSumHeaderMiddlewareUnsigned(t *testing.T) {
+	handler := NewHashSumHeaderMiddleware(signature.Keys{"testKey"}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		method         string
+		target         string
+		expectedStatus int
+	}{
+		{http.MethodPost, "/update/", http.StatusUnauthorized},
+		{http.MethodPost, "/updates/", http.StatusUnauthorized},
+		{http.MethodPost, "/write", http.StatusUnauthorized},
+		{http.MethodPost, "/import/prometheus", http.StatusUnauthorized},
+		{http.MethodPost, "/update/counter/PollCount/1", http.StatusUnauthorized},
+		{http.MethodGet, "/value/counter/PollCount", http.StatusOK},
+		{http.MethodGet, "/ping", http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.target, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.target, bytes.NewBufferString("PollCount 1")))
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+		})
+	}
+}
+
+// The signature of a write is bound to its target, the path-style updates have no body to sign.
+func TestNewHashSumHeaderMiddlewareTarget(t *testing.T) {
+	keys := signature.Keys{"testKey"}
+	handler := NewHashSumHeaderMiddleware(keys, replay.NewGuard(time.Minute, 100))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	ts := signature.FormatTimestamp(time.Now())
+	signed := func(method, target, nonce string) string {
+		return keys.Sign(signature.HTTPRequestParts(method, target, ts, nonce, nil)...)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		nonce          string
+		sig            string
+		expectedStatus int
+	}{
+		{"signed", http.MethodPost, "/update/counter/PollCount/1", "a", signed(http.MethodPost, "/update/counter/PollCount/1", "a"), http.StatusOK},
+		{"another value", http.MethodPost, "/update/counter/PollCount/1000", "b", signed(http.MethodPost, "/update/counter/PollCount/1", "b"), http.StatusBadRequest},
+		{"another name", http.MethodPost, "/update/counter/Other/1", "c", signed(http.MethodPost, "/update/counter/PollCount/1", "c"), http.StatusBadRequest},
+		{"another query", http.MethodPost, "/update/counter/PollCount/1?host=b", "d", signed(http.MethodPost, "/update/counter/PollCount/1?host=a", "d"), http.StatusBadRequest},
+		{"another method", http.MethodPut, "/update/counter/PollCount/1", "e", signed(http.MethodPost, "/update/counter/PollCount/1", "e"), http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			req.Header.Set(signature.TimestampHeader, ts)
+			req.Header.Set(signature.NonceHeader, tc.nonce)
+			req.Header.Set(signature.Header, tc.sig)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestNewHashSumHeaderMiddlewareReplay(t *testing.T) {
+	keys := signature.Keys{"testKey"}
+	handler := NewHashSumHeaderMiddleware(keys, replay.NewGuard(time.Minute, 2))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "testBody", string(body))
+	}))
+
+	newRequest := func(timestamp time.Time, nonce string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString("testBody"))
+		ts := signature.FormatTimestamp(timestamp)
+		req.Header.Set(signature.TimestampHeader, ts)
+		req.Header.Set(signature.NonceHeader, nonce)
+		req.Header.Set(signature.Header, keys.Sign(signature.HTTPRequestParts(http.MethodPost, "/updates/", ts, nonce, []byte("testBody"))...))
+		return req
+	}
+
+	tests := []struct {
+		name           string
+		req            *http.Request
+		expectedStatus int
+	}{
+		{"fresh", newRequest(time.Now(), "a"), http.StatusOK},
+		{"replayed", newRequest(time.Now(), "a"), http.StatusBadRequest},
+		{"stale", newRequest(time.Now().Add(-2*time.Minute), "b"), http.StatusBadRequest},
+		{"without nonce", newRequest(time.Now(), ""), http.StatusBadRequest},
+		{"second nonce", newRequest(time.Now(), "c"), http.StatusOK},
+		{"cache is full", newRequest(time.Now(), "d"), http.StatusServiceUnavailable},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, tc.req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+		})
+	}
+
+	// the timestamp is a part of the signed material
+	req := newRequest(time.Now(), "e")
+	req.Header.Set(signature.TimestampHeader, signature.FormatTimestamp(time.Now().Add(time.Second)))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "corrupted")
+}
+
+func TestNewSignResponseMiddleware(t *testing.T) {
+	keys := signature.Keys{"newKey", "oldKey"}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":`))
+		_, _ = w.Write([]byte(`"Alloc"}`))
+	}
+
+	// the signature covers the body before the compression
+	chain := GzipCompressMiddleware(NewSignResponseMiddleware(keys)(http.HandlerFunc(handler)))
+
+	req := httptest.NewRequest(http.MethodPost, "/value/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	chain.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	gz, err := gzip.NewReader(rr.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"Alloc"}`, string(body))
+	assert.Equal(t, signature.Sign("newKey", body), rr.Header().Get(signature.Header))
+
+	// streams are sent as they are written
+	req = httptest.NewRequest(http.MethodGet, "/stream", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	rr = httptest.NewRecorder()
+	chain.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get(signature.Header))
+
+	// without keys the response is not signed
+	rr = httptest.NewRecorder()
+	NewSignResponseMiddleware(nil)(http.HandlerFunc(handler)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, rr.Header().Get(signature.Header))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+}
+
 func TestMiddlewareAllowsRequestWithinCIDR(t *testing.T) {
 	cidrip := ipmask.CIDRIP{
 		Network: &net.IPNet{
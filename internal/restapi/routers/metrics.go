@@ -18,10 +18,16 @@ func NewMetricRouter(
 
 	r.Get("/", mServer.ListMetrics)
 	r.Get("/ping", mServer.PingStorage)
+	r.Get("/metrics", mServer.ExportPrometheus)
+	r.Get("/query", mServer.QueryMetrics)
+	r.Get("/stream", mServer.StreamMetrics)
 	r.Post("/value/", mServer.GetMetricJSON)
 	r.Get("/value/{metric_type}/{metric_name}", mServer.GetMetricValue)
+	r.Get("/history/{metric_type}/{metric_name}", mServer.GetMetricHistory)
 	r.Post("/update/", mServer.UpdateMetric)
 	r.Post("/updates/", mServer.UpdateMetricBulk)
+	r.Post("/write", mServer.UpdateMetricInflux)
+	r.Post("/import/prometheus", mServer.UpdateMetricPrometheus)
 	r.Post("/update/{metric_type}/{metric_name}/{metric_value}", mServer.UpdateMetric)
 
 	return r
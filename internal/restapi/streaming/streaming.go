@@ -0,0 +1,26 @@
+// Package streaming tells the streaming requests of the metric stream apart from the regular ones,
+// the responses to them must reach the client unbuffered.
+package streaming
+
+import (
+	"net/http"
+	"strings"
+)
+
+// EventStreamType the content type of Server-Sent Events.
+const EventStreamType = "text/event-stream"
+
+// IsWebSocket reports whether the request asks for the WebSocket upgrade.
+func IsWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// IsEventStream reports whether the request accepts Server-Sent Events.
+func IsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), EventStreamType)
+}
+
+// IsStreaming reports whether the response to the request is streamed.
+func IsStreaming(r *http.Request) bool {
+	return IsWebSocket(r) || IsEventStream(r)
+}
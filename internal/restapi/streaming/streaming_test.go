@@ -0,0 +1,33 @@
+package streaming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsStreaming(t *testing.T) {
+	tests := []struct {
+		name      string
+		headers   map[string]string
+		websocket bool
+		streaming bool
+	}{
+		{"plain", map[string]string{"Accept": "application/json"}, false, false},
+		{"websocket", map[string]string{"Upgrade": "WebSocket", "Connection": "Upgrade"}, true, true},
+		{"other upgrade", map[string]string{"Upgrade": "h2c"}, false, false},
+		{"event stream", map[string]string{"Accept": "text/html, text/event-stream"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/stream", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.websocket, IsWebSocket(r))
+			assert.Equal(t, tt.streaming, IsStreaming(r))
+		})
+	}
+}
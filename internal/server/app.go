@@ -9,19 +9,26 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	pb "github.com/screamsoul/go-metrics-tpl/internal/proto"
+	pbv2 "github.com/screamsoul/go-metrics-tpl/internal/proto/v2"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/interceptors"
 	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/services"
 	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/broadcast"
 	"github.com/screamsoul/go-metrics-tpl/internal/repositories/file"
 	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
 	"github.com/screamsoul/go-metrics-tpl/internal/repositories/postgres"
 	"github.com/screamsoul/go-metrics-tpl/internal/restapi/handlers"
 	"github.com/screamsoul/go-metrics-tpl/internal/restapi/middlewares"
 	"github.com/screamsoul/go-metrics-tpl/internal/restapi/routers"
+	"github.com/screamsoul/go-metrics-tpl/pkg/signature"
+	"github.com/screamsoul/go-metrics-tpl/pkg/utils"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 )
@@ -29,26 +36,35 @@ import (
 var ErrRegularShutdown = errors.New("regular shutdown")
 var ErrUnexpectedShutdown = errors.New("unexpected shutdown")
 
-func StartHTTPServer(
-	ctx context.Context,
-	errorResult chan error,
-	cfg *Config,
-	logger *zap.Logger,
-	metricRepo repositories.MetricStorage,
-) {
+// historyPruneInterval how often the history samples beyond the history size are deleted from Postgres.
+const historyPruneInterval = time.Minute
+
+// NewRouter returns the HTTP API router with the middleware chain configured by cfg.
+func NewRouter(cfg *Config, metricRepo repositories.MetricStorage) chi.Router {
 	var metricServer = handlers.NewMetricServer(
 		metricRepo,
 	)
 
-	var router = routers.NewMetricRouter(
+	return routers.NewMetricRouter(
 		metricServer,
 		middlewares.LoggingMiddleware,
 		middlewares.NewTrustedIPMiddleware(cfg.TrustedSubnetCIDR),
-		middlewares.NewDecryptMiddleware(cfg.CryptoKey.Key),
-		middlewares.NewHashSumHeaderMiddleware(cfg.HashBodyKey),
+		middlewares.NewHashSumHeaderMiddleware(signature.ParseKeys(cfg.HashBodyKey), cfg.NewReplayGuard()),
+		middlewares.NewDecryptMiddleware(cfg.CryptoKey.Keys...),
 		middlewares.GzipDecompressMiddleware,
 		middlewares.GzipCompressMiddleware,
+		middlewares.NewSignResponseMiddleware(signature.ParseKeys(cfg.HashBodyKey)),
 	)
+}
+
+func StartHTTPServer(
+	ctx context.Context,
+	errorResult chan error,
+	cfg *Config,
+	logger *zap.Logger,
+	metricRepo repositories.MetricStorage,
+) {
+	var router = NewRouter(cfg, metricRepo)
 
 	if cfg.Debug {
 		router.Mount("/debug", http.DefaultServeMux)
@@ -103,7 +119,26 @@ func StartGRPCServer(
 		return
 	}
 
-	server := grpc.NewServer(grpc.UnaryInterceptor(interceptors.NewTrustedIPMiddleware(cfg.TrustedSubnetCIDR)))
+	creds, err := grpcCredentials(cfg)
+	if err != nil {
+		logger.Error("grpc tls err", zap.Error(err))
+		utils.CloseForse(listen)
+		errorResult <- ErrUnexpectedShutdown
+		return
+	}
+
+	hashKeys, replayGuard := signature.ParseKeys(cfg.HashBodyKey), cfg.NewReplayGuard()
+	server := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.ChainUnaryInterceptor(
+			interceptors.NewTrustedIPMiddleware(cfg.TrustedSubnetCIDR),
+			interceptors.NewHashSumMiddleware(hashKeys, replayGuard),
+		),
+		grpc.ChainStreamInterceptor(
+			interceptors.NewTrustedIPStreamMiddleware(cfg.TrustedSubnetCIDR),
+			interceptors.NewHashSumStreamMiddleware(hashKeys, replayGuard),
+		),
+	)
 
 	idleConnsClosed := make(chan any)
 	sigint := make(chan os.Signal, 1)
@@ -124,7 +159,9 @@ func StartGRPCServer(
 	}()
 
 	// register server
-	pb.RegisterMetricsServiceServer(server, services.NewMetricServer(metricRepo))
+	metricServer := services.NewMetricServer(metricRepo)
+	pb.RegisterMetricsServiceServer(server, metricServer)
+	pbv2.RegisterMetricsServiceServer(server, services.NewMetricServerV2(metricServer))
 
 	fmt.Println("Сервер gRPC начал работу")
 	// start server
@@ -148,6 +185,12 @@ func Start(cfg *Config, logger *zap.Logger) {
 		// if no connection to the database is specified, the in-memory storage will be used.
 
 		memS := memory.NewMemStorage()
+		if cfg.HistorySize > 0 {
+			memS.EnableHistory(cfg.HistorySize)
+		}
+		if cfg.IdempotencyTTL > 0 {
+			memS.EnableIdempotency(time.Duration(cfg.IdempotencyTTL)*time.Second, cfg.IdempotencyCacheSize)
+		}
 		mStorage = memS
 	} else {
 		postgresS := postgres.NewPostgresStorage(cfg.DatabaseDSN, cfg.BackoffIntervals)
@@ -157,6 +200,15 @@ func Start(cfg *Config, logger *zap.Logger) {
 			panic(err)
 		}
 
+		if cfg.HistorySize > 0 {
+			postgresS.EnableHistory(cfg.HistorySize)
+			go postgresS.RunHistoryPruning(ctx, historyPruneInterval)
+		}
+		if cfg.IdempotencyTTL > 0 {
+			ttl := time.Duration(cfg.IdempotencyTTL) * time.Second
+			postgresS.EnableIdempotency(ttl)
+			go postgresS.RunIdempotencyPruning(ctx, ttl)
+		}
 		mStorage = postgresS
 	}
 
@@ -173,12 +225,40 @@ func Start(cfg *Config, logger *zap.Logger) {
 		defer mStorageRestore.Save(ctx)
 	}
 
-	errorResult := make(chan error)
+	// Publish accepted updates to the stream subscribers.
+	mStorageBroadcast := broadcast.NewBroadcastMetricWrapper(mStorageRestore, broadcast.NewHub())
 
-	go StartHTTPServer(ctx, errorResult, cfg, logger, mStorageRestore)
-	go StartGRPCServer(ctx, errorResult, cfg, logger, mStorageRestore)
+	servers := []func(context.Context, chan error, *Config, *zap.Logger, repositories.MetricStorage){
+		StartHTTPServer,
+		StartGRPCServer,
+	}
+	if cfg.StatsDAddress != "" {
+		servers = append(servers, StartStatsDServer)
+	}
+	if cfg.GraphiteAddress != "" {
+		servers = append(servers, StartGraphiteServer)
+	}
+
+	// every server reports at most two errors (signal and serve failure), so none of them blocks on shutdown.
+	errorResult := make(chan error, 2*len(servers))
+
+	serversCtx, stopServers := context.WithCancel(ctx)
+	defer stopServers()
+
+	var wg sync.WaitGroup
+	for _, start := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start(serversCtx, errorResult, cfg, logger, mStorageBroadcast)
+		}()
+	}
 
 	if err := <-errorResult; err != nil {
 		logger.Info(err.Error())
 	}
+
+	// stop the remaining servers and wait for them before the final save.
+	stopServers()
+	wg.Wait()
 }
@@ -12,6 +12,7 @@ import (
 
 	"github.com/alexflint/go-arg"
 	"github.com/screamsoul/go-metrics-tpl/pkg/ipmask"
+	"github.com/screamsoul/go-metrics-tpl/pkg/replay"
 	"github.com/screamsoul/go-metrics-tpl/pkg/utils"
 )
 
@@ -22,49 +23,85 @@ type Postgres struct {
 }
 
 type CryptoPublicKey struct {
-	Key *rsa.PrivateKey
+	Key  *rsa.PrivateKey   // текущий ключ
+	Keys []*rsa.PrivateKey // все принимаемые ключи, текущий первый
 }
 
 type Config struct {
 	Postgres
-	ListenAddress     string          `arg:"-a,env:ADDRESS" default:"localhost:8080" help:"Адрес и порт сервера" json:"address"`
-	ListenGRPCAddress string          `arg:"--grpc,env:GRPC_ADDRESS" default:"localhost:50051" help:"Адрес и порт сервера GRPC" json:"grpc_address"`
-	LogLevel          string          `arg:"--ll,env:LOG_LEVEL" default:"INFO" help:"Уровень логирования"`
-	StoreInterval     int             `arg:"-i,env:STORE_INTERVAL" default:"300" help:"Интервал времени в секундах, по истечении которого текущие показания сервера сохраняются на диск" json:"store_interval"`
-	FileStoragePath   string          `arg:"-f,env:FILE_STORAGE_PATH" default:"/tmp/metrics-db.json" help:"Полное имя файла, куда сохраняются текущие значения" json:"store_file"`
-	Restore           bool            `arg:"-r,env:RESTORE" default:"true" help:"Загружать или нет ранее сохранённые значения из указанного файла при старте сервера" json:"restore"`
-	HashBodyKey       string          `arg:"-k,env:KEY" default:"" help:"hash key"`
-	Debug             bool            `arg:"--debug,env:DEBUG" default:"false" help:"debug mode"`
-	CryptoKey         CryptoPublicKey `arg:"--crypto-key,env:CRYPTO_KEY" default:"" help:"the path to the file with the public key" json:"crypto_key"`
-	TrustedSubnetCIDR ipmask.CIDRIP   `arg:"-t,env:TRUSTED_SUBNET" default:"" help:"allowed subnet in the classless addressing string format (CIDR)" json:"trusted_subnet"`
+	ListenAddress          string          `arg:"-a,env:ADDRESS" default:"localhost:8080" help:"Адрес и порт сервера" json:"address"`
+	ListenGRPCAddress      string          `arg:"--grpc,env:GRPC_ADDRESS" default:"localhost:50051" help:"Адрес и порт сервера GRPC" json:"grpc_address"`
+	LogLevel               string          `arg:"--ll,env:LOG_LEVEL" default:"INFO" help:"Уровень логирования"`
+	StoreInterval          int             `arg:"-i,env:STORE_INTERVAL" default:"300" help:"Интервал времени в секундах, по истечении которого текущие показания сервера сохраняются на диск" json:"store_interval"`
+	FileStoragePath        string          `arg:"-f,env:FILE_STORAGE_PATH" default:"/tmp/metrics-db.json" help:"Полное имя файла, куда сохраняются текущие значения" json:"store_file"`
+	Restore                bool            `arg:"-r,env:RESTORE" default:"true" help:"Загружать или нет ранее сохранённые значения из указанного файла при старте сервера" json:"restore"`
+	HashBodyKey            string          `arg:"-k,env:KEY" default:"" help:"HMAC-SHA256 signing keys separated by commas, the first is the current one"`
+	Debug                  bool            `arg:"--debug,env:DEBUG" default:"false" help:"debug mode"`
+	CryptoKey              CryptoPublicKey `arg:"--crypto-key,env:CRYPTO_KEY" default:"" help:"the paths to the files with the private keys separated by commas, the first is the current one" json:"crypto_key"`
+	TrustedSubnetCIDR      ipmask.CIDRIP   `arg:"-t,env:TRUSTED_SUBNET" default:"" help:"allowed subnet in the classless addressing string format (CIDR)" json:"trusted_subnet"`
+	StatsDAddress          string          `arg:"--statsd,env:STATSD_ADDRESS" default:"" help:"Адрес и порт UDP для приёма метрик StatsD (пусто - отключено)" json:"statsd_address"`
+	StatsDFlushInterval    int             `arg:"--statsd-flush,env:STATSD_FLUSH_INTERVAL" default:"10" help:"Интервал времени в секундах, за который агрегируются метрики StatsD" json:"statsd_flush_interval"`
+	GraphiteAddress        string          `arg:"--graphite,env:GRAPHITE_ADDRESS" default:"" help:"Адрес и порт TCP для приёма метрик Graphite (пусто - отключено)" json:"graphite_address"`
+	GraphiteMaxConnections int             `arg:"--graphite-max-conns,env:GRAPHITE_MAX_CONNECTIONS" default:"100" help:"Максимальное количество одновременных соединений Graphite" json:"graphite_max_connections"`
+	GraphiteMaxLineLength  int             `arg:"--graphite-max-line,env:GRAPHITE_MAX_LINE_LENGTH" default:"4096" help:"Максимальная длина строки Graphite в байтах, соединение с более длинной строкой закрывается" json:"graphite_max_line_length"`
+	GraphiteIdleTimeout    int             `arg:"--graphite-idle-timeout,env:GRAPHITE_IDLE_TIMEOUT" default:"60" help:"Время в секундах, после которого неактивное соединение Graphite закрывается" json:"graphite_idle_timeout"`
+	GRPCTLSCert            string          `arg:"--grpc-tls-cert,env:GRPC_TLS_CERT" default:"" help:"Путь к сертификату TLS сервера gRPC (пусто - TLS отключен)" json:"grpc_tls_cert"`
+	GRPCTLSKey             string          `arg:"--grpc-tls-key,env:GRPC_TLS_KEY" default:"" help:"Путь к закрытому ключу сертификата TLS сервера gRPC" json:"grpc_tls_key"`
+	GRPCTLSClientCA        string          `arg:"--grpc-tls-client-ca,env:GRPC_TLS_CLIENT_CA" default:"" help:"Путь к сертификату CA клиентов gRPC, если задан - сертификат клиента обязателен (mTLS)" json:"grpc_tls_client_ca"`
+	ReplayWindow           int             `arg:"--replay-window,env:REPLAY_WINDOW" default:"300" help:"Допустимое расхождение времени подписи запроса и сервера в секундах, запросы вне окна и с повторным nonce отклоняются (0 - проверка отключена)" json:"replay_window"`
+	ReplayCacheSize        int             `arg:"--replay-cache-size,env:REPLAY_CACHE_SIZE" default:"100000" help:"Максимальное количество запоминаемых nonce, при заполнении запросы отклоняются до устаревания старых" json:"replay_cache_size"`
+	IdempotencyTTL         int             `arg:"--idempotency-ttl,env:IDEMPOTENCY_TTL" default:"3600" help:"Время в секундах, в течение которого запоминаются ключи идемпотентности примененных пакетов (0 - проверка отключена)" json:"idempotency_ttl"`
+	IdempotencyCacheSize   int             `arg:"--idempotency-cache-size,env:IDEMPOTENCY_CACHE_SIZE" default:"100000" help:"Максимальное количество ключей идемпотентности в памяти, при заполнении забываются самые старые" json:"idempotency_cache_size"`
+	HistorySize            int             `arg:"--history-size,env:HISTORY_SIZE" default:"0" help:"Количество хранимых значений истории каждой серии, в Postgres лишние значения удаляются раз в минуту (0 - история отключена)" json:"history_size"`
 }
 
+// UnmarshalText loads the comma separated paths of private keys, the first key is the current one,
+// the others are kept to decrypt bodies encrypted for the previous keys during rotation.
 func (cpk *CryptoPublicKey) UnmarshalText(b []byte) error {
 	if len(b) == 0 {
 		return nil
 	}
 
-	keyData, err := os.ReadFile(string(b))
+	cpk.Key, cpk.Keys = nil, nil
+	for _, path := range strings.Split(string(b), ",") {
+		key, err := loadPrivateKey(strings.TrimSpace(path))
+		if err != nil {
+			return err
+		}
+		cpk.Keys = append(cpk.Keys, key)
+	}
+	cpk.Key = cpk.Keys[0]
+	return nil
+}
+
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	keyData, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	block, _ := pem.Decode(keyData)
 	if block == nil || !strings.Contains(block.Type, "PRIVATE KEY") {
-		return fmt.Errorf("not find private key in file")
+		return nil, fmt.Errorf("not find private key in file")
 	}
 
 	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	rsaPrivateKey, ok := privateKey.(*rsa.PrivateKey)
 	if !ok {
-		return fmt.Errorf("not an RSA private key")
+		return nil, fmt.Errorf("not an RSA private key")
 	}
+	return rsaPrivateKey, nil
+}
 
-	cpk.Key = rsaPrivateKey
-	return nil
+// NewReplayGuard creates the replay protection of signed requests, nil if it is disabled.
+func (c *Config) NewReplayGuard() *replay.Guard {
+	if c.ReplayWindow <= 0 {
+		return nil
+	}
+	return replay.NewGuard(time.Duration(c.ReplayWindow)*time.Second, c.ReplayCacheSize)
 }
 
 func NewConfig() (*Config, error) {
@@ -85,5 +122,19 @@ func NewConfig() (*Config, error) {
 		cfg.Postgres.BackoffIntervals = nil
 	}
 
+	if cfg.StatsDAddress != "" && cfg.StatsDFlushInterval <= 0 {
+		return nil, fmt.Errorf("statsd flush interval must be positive, got %d", cfg.StatsDFlushInterval)
+	}
+	if cfg.GraphiteAddress != "" {
+		switch {
+		case cfg.GraphiteMaxConnections <= 0:
+			return nil, fmt.Errorf("graphite max connections must be positive, got %d", cfg.GraphiteMaxConnections)
+		case cfg.GraphiteMaxLineLength <= 0:
+			return nil, fmt.Errorf("graphite max line length must be positive, got %d", cfg.GraphiteMaxLineLength)
+		case cfg.GraphiteIdleTimeout <= 0:
+			return nil, fmt.Errorf("graphite idle timeout must be positive, got %d", cfg.GraphiteIdleTimeout)
+		}
+	}
+
 	return &cfg, nil
 }
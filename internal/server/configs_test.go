@@ -5,7 +5,10 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -65,6 +68,97 @@ func TestBackoffIntervalConfig(t *testing.T) {
 	}
 }
 
+func TestStatsDFlushIntervalConfig(t *testing.T) {
+	testTable := []struct {
+		name    string
+		envVars map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "Default interval",
+			envVars: map[string]string{"STATSD_ADDRESS": "localhost:8125"},
+		},
+		{
+			name:    "Zero interval",
+			envVars: map[string]string{"STATSD_ADDRESS": "localhost:8125", "STATSD_FLUSH_INTERVAL": "0"},
+			wantErr: true,
+		},
+		{
+			name:    "Negative interval",
+			envVars: map[string]string{"STATSD_ADDRESS": "localhost:8125", "STATSD_FLUSH_INTERVAL": "-1"},
+			wantErr: true,
+		},
+		{
+			name:    "Zero interval with StatsD disabled",
+			envVars: map[string]string{"STATSD_FLUSH_INTERVAL": "0"},
+		},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Args = os.Args[:1]
+			for k, v := range tt.envVars {
+				t.Setenv(k, v)
+			}
+
+			_, err := server.NewConfig()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGraphiteConfig(t *testing.T) {
+	testTable := []struct {
+		name    string
+		envVars map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "Default limits",
+			envVars: map[string]string{"GRAPHITE_ADDRESS": "localhost:2003"},
+		},
+		{
+			name:    "Zero connections",
+			envVars: map[string]string{"GRAPHITE_ADDRESS": "localhost:2003", "GRAPHITE_MAX_CONNECTIONS": "0"},
+			wantErr: true,
+		},
+		{
+			name:    "Negative line length",
+			envVars: map[string]string{"GRAPHITE_ADDRESS": "localhost:2003", "GRAPHITE_MAX_LINE_LENGTH": "-1"},
+			wantErr: true,
+		},
+		{
+			name:    "Zero idle timeout",
+			envVars: map[string]string{"GRAPHITE_ADDRESS": "localhost:2003", "GRAPHITE_IDLE_TIMEOUT": "0"},
+			wantErr: true,
+		},
+		{
+			name:    "Zero limits with Graphite disabled",
+			envVars: map[string]string{"GRAPHITE_MAX_CONNECTIONS": "0", "GRAPHITE_MAX_LINE_LENGTH": "0", "GRAPHITE_IDLE_TIMEOUT": "0"},
+		},
+	}
+
+	for _, tt := range testTable {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Args = os.Args[:1]
+			for k, v := range tt.envVars {
+				t.Setenv(k, v)
+			}
+
+			_, err := server.NewConfig()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
 func TestUnmarshalText(t *testing.T) {
 	// Generate a new RSA private key
 	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
@@ -100,6 +194,38 @@ func TestUnmarshalText(t *testing.T) {
 	assert.Equal(t, privateKey.N, cryptoPubKey.Key.N)
 }
 
+func TestUnmarshalText__KeyRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	var paths []string
+	var keys []*rsa.PrivateKey
+	for i := 0; i < 2; i++ {
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		privBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		require.NoError(t, err)
+
+		path := filepath.Join(dir, fmt.Sprintf("key%d.pem", i))
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), 0o600))
+
+		paths = append(paths, path)
+		keys = append(keys, privateKey)
+	}
+
+	cryptoPubKey := &server.CryptoPublicKey{}
+	err := cryptoPubKey.UnmarshalText([]byte(strings.Join(paths, ", ")))
+	require.NoError(t, err)
+
+	require.Len(t, cryptoPubKey.Keys, 2)
+	assert.Equal(t, keys[0].N, cryptoPubKey.Key.N)
+	assert.Equal(t, keys[0].N, cryptoPubKey.Keys[0].N)
+	assert.Equal(t, keys[1].N, cryptoPubKey.Keys[1].N)
+
+	// a single broken path fails the whole list
+	err = cryptoPubKey.UnmarshalText([]byte(paths[0] + ",/fake_file"))
+	require.Error(t, err)
+}
+
 func TestUnmarshalText__IncorrectFile(t *testing.T) {
 	// Instantiate CryptoPublicKey and call UnmarshalText
 	cryptoPubKey := &server.CryptoPublicKey{}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/protocols/graphite"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"github.com/screamsoul/go-metrics-tpl/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// graphiteBatchSize the maximum number of metrics passed to MetricStorage.BulkAdd at once.
+const graphiteBatchSize = 100
+
+type graphiteServer struct {
+	cfg        *Config
+	logger     *zap.Logger
+	metricRepo repositories.MetricStorage
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// StartGraphiteServer receives gauges in the Graphite plaintext protocol over TCP.
+//
+// Connections from outside of the trusted subnet are closed right away, the number of
+// simultaneous connections, the line length and the idle time of a connection are limited.
+func StartGraphiteServer(
+	ctx context.Context,
+	errorResult chan error,
+	cfg *Config,
+	logger *zap.Logger,
+	metricRepo repositories.MetricStorage,
+) {
+	listen, err := net.Listen("tcp", cfg.GraphiteAddress)
+	if err != nil {
+		logger.Error("listen tcp err", zap.Error(err))
+		errorResult <- ErrUnexpectedShutdown
+		return
+	}
+
+	logger.Info("starting graphite server", zap.String("GraphiteAddress", cfg.GraphiteAddress))
+
+	s := &graphiteServer{
+		cfg:        cfg,
+		logger:     logger,
+		metricRepo: metricRepo,
+		conns:      make(map[net.Conn]struct{}),
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := listen.Close(); err != nil {
+			logger.Error("graphite close", zap.Error(err))
+		}
+		s.closeConns()
+	}()
+
+	for {
+		conn, err := listen.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if err != nil {
+			logger.Error("graphite accept", zap.Error(err))
+			continue
+		}
+
+		if !s.trusted(conn) {
+			logger.Warn("graphite connection from untrusted address", zap.String("addr", conn.RemoteAddr().String()))
+			utils.CloseForse(conn)
+			continue
+		}
+		if !s.track(conn) {
+			logger.Warn("graphite connections limit reached", zap.String("addr", conn.RemoteAddr().String()))
+			utils.CloseForse(conn)
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.handle(context.WithoutCancel(ctx), conn)
+		}()
+	}
+
+	s.wg.Wait()
+}
+
+func (s *graphiteServer) trusted(conn net.Conn) bool {
+	if s.cfg.TrustedSubnetCIDR.Network == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	return s.cfg.TrustedSubnetCIDR.CheckIPIncluded(host)
+}
+
+// track registers the connection if the connections limit is not reached.
+func (s *graphiteServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil || len(s.conns) >= s.cfg.GraphiteMaxConnections {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *graphiteServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+	utils.CloseForse(conn)
+}
+
+// closeConns interrupts reading of all connections and forbids new ones.
+func (s *graphiteServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		if err := conn.SetReadDeadline(time.Now()); err != nil {
+			utils.CloseForse(conn)
+		}
+	}
+	s.conns = nil
+}
+
+// extendDeadline prolongs reading of the connection by the idle timeout,
+// false if the server is shutting down.
+func (s *graphiteServer) extendDeadline(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil {
+		return false
+	}
+	idleTimeout := time.Duration(s.cfg.GraphiteIdleTimeout) * time.Second
+	return conn.SetReadDeadline(time.Now().Add(idleTimeout)) == nil
+}
+
+func (s *graphiteServer) flush(ctx context.Context, batch []metrics.Metrics) []metrics.Metrics {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := s.metricRepo.BulkAdd(ctx, batch); err != nil {
+		s.logger.Error("graphite update metrics", zap.Error(err))
+	}
+	return batch[:0]
+}
+
+// handle reads the lines of the connection, the batch is flushed when it is full
+// or when no more data is buffered, so a slow sender does not delay its metrics.
+func (s *graphiteServer) handle(ctx context.Context, conn net.Conn) {
+	reader := bufio.NewReaderSize(conn, s.cfg.GraphiteMaxLineLength)
+	batch := make([]metrics.Metrics, 0, graphiteBatchSize)
+
+	for s.extendDeadline(conn) {
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			s.logger.Warn("graphite line too long", zap.String("addr", conn.RemoteAddr().String()))
+			break
+		}
+		// a line interrupted by the deadline may be truncated, so it is dropped.
+		if len(line) > 0 && (err == nil || errors.Is(err, io.EOF)) {
+			m, parseErr := graphite.ParseLine(string(line))
+			if parseErr != nil {
+				s.logger.Debug("graphite bad line", zap.ByteString("line", line), zap.Error(parseErr))
+			} else {
+				batch = append(batch, *m)
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+				s.logger.Error("graphite read", zap.Error(err))
+			}
+			break
+		}
+
+		if len(batch) == graphiteBatchSize || reader.Buffered() == 0 {
+			batch = s.flush(ctx, batch)
+		}
+	}
+
+	s.flush(ctx, batch)
+}
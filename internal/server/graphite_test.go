@@ -0,0 +1,152 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/screamsoul/go-metrics-tpl/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// startGraphite runs the Graphite server on a free port and returns the storage and the stop function.
+func startGraphite(t *testing.T, cfg *server.Config) (*memory.MemStorage, func()) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	cfg.GraphiteAddress = probe.Addr().String()
+	require.NoError(t, probe.Close())
+
+	store := memory.NewMemStorage()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan any)
+	go func() {
+		server.StartGraphiteServer(ctx, make(chan error, 2), cfg, zap.NewNop(), store)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", cfg.GraphiteAddress)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return store, func() {
+		cancel()
+		<-done
+	}
+}
+
+func graphiteConfig() *server.Config {
+	return &server.Config{GraphiteMaxConnections: 2, GraphiteMaxLineLength: 64, GraphiteIdleTimeout: 5}
+}
+
+// closedByServer reports whether the server closed the connection.
+func closedByServer(t *testing.T, conn net.Conn) bool {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err := conn.Read(make([]byte, 1))
+	return err != nil && !strings.Contains(err.Error(), "timeout")
+}
+
+func getGauge(store *memory.MemStorage, name string) (float64, bool) {
+	m := metrics.Metrics{ID: name, MType: metrics.Gauge}
+	if err := store.Get(context.Background(), &m); err != nil {
+		return 0, false
+	}
+	return *m.Value, true
+}
+
+func TestStartGraphiteServer_StoresGauges(t *testing.T) {
+	cfg := graphiteConfig()
+	store, stop := startGraphite(t, cfg)
+
+	conn, err := net.Dial("tcp", cfg.GraphiteAddress)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("jobs.backup.duration 12.5 1700000000\nbad line\njobs.cleanup.duration 3\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, ok := getGauge(store, "jobs.cleanup.duration")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the unterminated line may be truncated, so it is not stored on shutdown
+	_, err = conn.Write([]byte("jobs.last 7"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+
+	value, ok := getGauge(store, "jobs.backup.duration")
+	assert.True(t, ok)
+	assert.Equal(t, 12.5, value)
+	_, ok = getGauge(store, "jobs.last")
+	assert.False(t, ok)
+}
+
+func TestStartGraphiteServer_Limits(t *testing.T) {
+	cfg := graphiteConfig()
+	store, stop := startGraphite(t, cfg)
+	defer stop()
+
+	t.Run("line too long", func(t *testing.T) {
+		conn, err := net.Dial("tcp", cfg.GraphiteAddress)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("long." + strings.Repeat("a", 100) + " 1\n"))
+		require.NoError(t, err)
+		assert.True(t, closedByServer(t, conn))
+	})
+
+	t.Run("connections limit", func(t *testing.T) {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for i := 0; i < cfg.GraphiteMaxConnections; i++ {
+			conn, err := net.Dial("tcp", cfg.GraphiteAddress)
+			require.NoError(t, err)
+			conns = append(conns, conn)
+			// make sure the connection is accepted and tracked
+			_, err = conn.Write([]byte("probe 1\n"))
+			require.NoError(t, err)
+		}
+		require.Eventually(t, func() bool {
+			_, ok := getGauge(store, "probe")
+			return ok
+		}, 5*time.Second, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", cfg.GraphiteAddress)
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.True(t, closedByServer(t, conn))
+	})
+}
+
+func TestStartGraphiteServer_UntrustedSubnet(t *testing.T) {
+	cfg := graphiteConfig()
+	require.NoError(t, cfg.TrustedSubnetCIDR.UnmarshalText([]byte("10.0.0.0/8")))
+	store, stop := startGraphite(t, cfg)
+	defer stop()
+
+	conn, err := net.Dial("tcp", cfg.GraphiteAddress)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, _ = conn.Write([]byte("untrusted 1\n"))
+	assert.True(t, closedByServer(t, conn))
+	_, ok := getGauge(store, "untrusted")
+	assert.False(t, ok)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/grpcapi/services"
+	pbv2 "github.com/screamsoul/go-metrics-tpl/internal/proto/v2"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert issues a certificate signed by the parent, self-signed if the parent is nil.
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(tc.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(tc.keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return tc
+}
+
+// callGRPC serves the ingestion API with the server credentials and makes one call with the client ones.
+func callGRPC(t *testing.T, serverCreds, clientCreds credentials.TransportCredentials) error {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer(grpc.Creds(serverCreds))
+	pbv2.RegisterMetricsServiceServer(server, services.NewMetricServerV2(services.NewMetricServer(memory.NewMemStorage())))
+	go func() {
+		_ = server.Serve(listen)
+	}()
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(listen.Addr().String(), grpc.WithTransportCredentials(clientCreds))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = pbv2.NewMetricsServiceClient(conn).UpdateMetrics(ctx, &pbv2.MetricsRequest{Metrics: []*pbv2.Metric{
+		{Name: "Alloc", Data: &pbv2.Metric_Value{Value: 1}},
+	}})
+	return err
+}
+
+func TestGRPCCredentials(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	serverCert := newTestCert(t, "server", ca)
+	clientCert := newTestCert(t, "client", ca)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientPair, err := tls.LoadX509KeyPair(clientCert.certFile, clientCert.keyFile)
+	require.NoError(t, err)
+
+	tlsClient := credentials.NewTLS(&tls.Config{RootCAs: roots})
+	mtlsClient := credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientPair}})
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		client  credentials.TransportCredentials
+		wantErr bool
+	}{
+		{"plaintext", Config{}, insecure.NewCredentials(), false},
+		{"tls", Config{GRPCTLSCert: serverCert.certFile, GRPCTLSKey: serverCert.keyFile}, tlsClient, false},
+		{"tls plaintext client", Config{GRPCTLSCert: serverCert.certFile, GRPCTLSKey: serverCert.keyFile}, insecure.NewCredentials(), true},
+		{"mtls", Config{GRPCTLSCert: serverCert.certFile, GRPCTLSKey: serverCert.keyFile, GRPCTLSClientCA: ca.certFile}, mtlsClient, false},
+		{"mtls without client cert", Config{GRPCTLSCert: serverCert.certFile, GRPCTLSKey: serverCert.keyFile, GRPCTLSClientCA: ca.certFile}, tlsClient, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := grpcCredentials(&tt.cfg)
+			require.NoError(t, err)
+
+			err = callGRPC(t, creds, tt.client)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGRPCCredentials__InvalidConfig(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"client CA without certificate", Config{GRPCTLSClientCA: ca.certFile}},
+		{"certificate without key", Config{GRPCTLSCert: ca.certFile}},
+		{"missing client CA", Config{GRPCTLSCert: ca.certFile, GRPCTLSKey: ca.keyFile, GRPCTLSClientCA: "/not/exists"}},
+		{"client CA is not a certificate", Config{GRPCTLSCert: ca.certFile, GRPCTLSKey: ca.keyFile, GRPCTLSClientCA: ca.keyFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := grpcCredentials(&tt.cfg)
+			assert.Error(t, err)
+		})
+	}
+}
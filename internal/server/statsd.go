@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/protocols/statsd"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories"
+	"go.uber.org/zap"
+)
+
+// statsDMaxPacketSize the maximum size of a StatsD UDP datagram.
+const statsDMaxPacketSize = 65535
+
+// StartStatsDServer receives StatsD metrics over UDP and flushes them into the storage
+// every StatsDFlushInterval seconds, the last window is flushed on shutdown.
+func StartStatsDServer(
+	ctx context.Context,
+	errorResult chan error,
+	cfg *Config,
+	logger *zap.Logger,
+	metricRepo repositories.MetricStorage,
+) {
+	conn, err := net.ListenPacket("udp", cfg.StatsDAddress)
+	if err != nil {
+		logger.Error("listen udp err", zap.Error(err))
+		errorResult <- ErrUnexpectedShutdown
+		return
+	}
+
+	logger.Info("starting statsd server", zap.String("StatsDAddress", cfg.StatsDAddress))
+
+	aggregator := statsd.NewAggregator()
+	flush := func(ctx context.Context) {
+		if err := aggregator.Flush(ctx, metricRepo); err != nil {
+			logger.Error("statsd flush", zap.Error(err))
+		}
+	}
+
+	flushDone := make(chan any)
+	go func() {
+		defer close(flushDone)
+
+		ticker := time.NewTicker(time.Duration(cfg.StatsDFlushInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				flush(ctx)
+			case <-ctx.Done():
+				if err := conn.Close(); err != nil {
+					logger.Error("statsd close", zap.Error(err))
+				}
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, statsDMaxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if err != nil {
+			logger.Error("statsd read", zap.Error(err))
+			continue
+		}
+
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			update, err := statsd.ParseLine(string(line))
+			if err != nil {
+				logger.Debug("statsd bad line", zap.ByteString("line", line), zap.Error(err))
+				continue
+			}
+			aggregator.Add(update)
+		}
+	}
+
+	<-flushDone
+	flush(context.WithoutCancel(ctx))
+}
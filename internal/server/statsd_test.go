@@ -0,0 +1,56 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/internal/models/metrics"
+	"github.com/screamsoul/go-metrics-tpl/internal/repositories/memory"
+	"github.com/screamsoul/go-metrics-tpl/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStartStatsDServer_FlushesOnShutdown(t *testing.T) {
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := probe.LocalAddr().String()
+	require.NoError(t, probe.Close())
+
+	cfg := &server.Config{StatsDAddress: address, StatsDFlushInterval: 1}
+	store := memory.NewMemStorage()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errorResult := make(chan error, 2)
+	done := make(chan any)
+	go func() {
+		server.StartStatsDServer(ctx, errorResult, cfg, zap.NewNop(), store)
+		close(done)
+	}()
+
+	conn, err := net.Dial("udp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// packets sent before the listener is up are lost, so resend until the first flush.
+	metric := metrics.Metrics{ID: "hits", MType: metrics.Counter}
+	require.Eventually(t, func() bool {
+		_, err := conn.Write([]byte("hits:1|c\nbad\n"))
+		require.NoError(t, err)
+		return store.Get(context.Background(), &metric) == nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	_, err = conn.Write([]byte("hits:1000|c"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	<-done
+
+	require.NoError(t, store.Get(context.Background(), &metric))
+	assert.GreaterOrEqual(t, *metric.Delta, int64(1001))
+	assert.Empty(t, errorResult)
+}
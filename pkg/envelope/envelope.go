@@ -0,0 +1,160 @@
+// Package envelope implements hybrid encryption of request bodies.
+//
+// The body is encrypted with a random AES-256-GCM key, the key is wrapped with RSA-OAEP (SHA-256)
+// for the recipient public key. The envelope layout, integers are big endian:
+//
+//	version   1 byte, Version1
+//	key id    8 bytes, KeyID of the recipient public key
+//	key size  2 bytes, length of the wrapped key
+//	key       wrapped AES key
+//	nonce     12 bytes, GCM nonce
+//	data      ciphertext with the GCM tag
+//
+// The version, key id and wrapped key are authenticated as the GCM additional data.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Version1 the version of the envelope layout.
+const Version1 byte = 1
+
+const (
+	Header = "X-Encryption" // заголовок запроса, тело которого упаковано в конверт
+	Scheme = "envelope"     // значение заголовка Header для конверта
+)
+
+const (
+	keyIDSize   = 8
+	aesKeySize  = 32
+	headerSize  = 1 + keyIDSize + 2
+	gcmNonceLen = 12
+)
+
+var (
+	ErrMalformed       = errors.New("envelope is malformed")
+	ErrUnknownVersion  = errors.New("envelope version is not supported")
+	ErrUnknownKey      = errors.New("envelope is encrypted for an unknown key")
+	ErrDecryptionFails = errors.New("envelope decryption failed")
+)
+
+// KeyID identifies the public key, the first bytes of SHA-256 of its PKCS #1 encoding.
+func KeyID(pub *rsa.PublicKey) [keyIDSize]byte {
+	var id [keyIDSize]byte
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(pub))
+	copy(id[:], sum[:keyIDSize])
+	return id
+}
+
+// Seal encrypts the plaintext for the owner of the public key.
+func Seal(pub *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	id := KeyID(pub)
+
+	key := make([]byte, aesKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrap key: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerSize+len(wrapped))
+	header = append(header, Version1)
+	header = append(header, id[:]...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	nonce := make([]byte, gcmNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, header...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, header), nil
+}
+
+// Keyring the private keys accepted by the recipient, several keys allow rotation
+// while agents still encrypt for the previous key.
+type Keyring struct {
+	keys map[[keyIDSize]byte]*rsa.PrivateKey
+}
+
+// NewKeyring creates the keyring, nil keys are skipped.
+func NewKeyring(keys ...*rsa.PrivateKey) *Keyring {
+	kr := &Keyring{keys: make(map[[keyIDSize]byte]*rsa.PrivateKey, len(keys))}
+	for _, key := range keys {
+		if key != nil {
+			kr.keys[KeyID(&key.PublicKey)] = key
+		}
+	}
+	return kr
+}
+
+// Len returns the number of keys.
+func (kr *Keyring) Len() int {
+	return len(kr.keys)
+}
+
+// Open decrypts the envelope with the key it was sealed for.
+func (kr *Keyring) Open(data []byte) ([]byte, error) {
+	if len(data) < headerSize {
+		return nil, ErrMalformed
+	}
+	if data[0] != Version1 {
+		return nil, ErrUnknownVersion
+	}
+
+	var id [keyIDSize]byte
+	copy(id[:], data[1:1+keyIDSize])
+	key, ok := kr.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	wrappedLen := int(binary.BigEndian.Uint16(data[1+keyIDSize:]))
+	if len(data) < headerSize+wrappedLen+gcmNonceLen {
+		return nil, ErrMalformed
+	}
+	header := data[:headerSize+wrappedLen]
+	nonce := data[len(header) : len(header)+gcmNonceLen]
+	ciphertext := data[len(header)+gcmNonceLen:]
+
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, header[headerSize:], nil)
+	if err != nil || len(aesKey) != aesKeySize {
+		return nil, ErrDecryptionFails
+	}
+	gcm, err := newGCM(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, ErrDecryptionFails
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,79 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func TestSealOpen(t *testing.T) {
+	oldKey, newKey := generateKey(t), generateKey(t)
+
+	// a batch far larger than a single RSA block
+	body := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1.5},`), 1000)
+
+	kr := NewKeyring(newKey, nil, oldKey)
+	assert.Equal(t, 2, kr.Len())
+
+	for _, key := range []*rsa.PrivateKey{oldKey, newKey} {
+		sealed, err := Seal(&key.PublicKey, body)
+		require.NoError(t, err)
+		assert.Equal(t, Version1, sealed[0])
+
+		opened, err := kr.Open(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, body, opened)
+	}
+
+	empty, err := Seal(&newKey.PublicKey, nil)
+	require.NoError(t, err)
+	opened, err := kr.Open(empty)
+	require.NoError(t, err)
+	assert.Empty(t, opened)
+}
+
+func TestOpenErrors(t *testing.T) {
+	key := generateKey(t)
+	kr := NewKeyring(key)
+
+	sealed, err := Seal(&key.PublicKey, []byte("payload"))
+	require.NoError(t, err)
+
+	otherSealed, err := Seal(&generateKey(t).PublicKey, []byte("payload"))
+	require.NoError(t, err)
+
+	modify := func(pos int) []byte {
+		data := bytes.Clone(sealed)
+		data[pos] ^= 0xff
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrMalformed},
+		{"truncated", sealed[:headerSize+10], ErrMalformed},
+		{"unknown version", modify(0), ErrUnknownVersion},
+		{"unknown key", otherSealed, ErrUnknownKey},
+		{"tampered wrapped key", modify(headerSize + 1), ErrDecryptionFails},
+		{"tampered data", modify(len(sealed) - 1), ErrDecryptionFails},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := kr.Open(tt.data)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Package idempotency lets a batch of updates be applied at most once.
+//
+// The agent attaches a random key to every batch and repeats it on every retry of the batch.
+// The server remembers the keys of the committed batches for a while and answers a batch
+// with a remembered key as if it was applied, without applying it again.
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Header the REST API header with the key of the batch.
+const Header = "Idempotency-Key"
+
+// ReplayedHeader the REST API response header set when the batch has been applied before.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// MaxKeyLength the maximum length of a key.
+const MaxKeyLength = 128
+
+var ErrInvalidKey = errors.New("invalid idempotency key")
+
+type contextKey struct{}
+
+// NewKey returns a random key of a batch.
+func NewKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Validate checks that the key is a non-empty string of printable ASCII characters
+// not longer than MaxKeyLength.
+func Validate(key string) error {
+	if key == "" || len(key) > MaxKeyLength {
+		return fmt.Errorf("%w: length must be between 1 and %d", ErrInvalidKey, MaxKeyLength)
+	}
+	for _, c := range []byte(key) {
+		if c < '!' || c > '~' {
+			return fmt.Errorf("%w: only printable ASCII characters are allowed", ErrInvalidKey)
+		}
+	}
+	return nil
+}
+
+// WithKey returns a copy of ctx carrying the key of the batch sent within it.
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key of the batch set by WithKey, empty if there is none.
+func FromContext(ctx context.Context) string {
+	key, _ := ctx.Value(contextKey{}).(string)
+	return key
+}
+
+// Cache remembers the keys for ttl, at most maxSize keys, the oldest key is forgotten
+// when the cache is full. Cache is not safe for concurrent use.
+type Cache struct {
+	ttl     time.Duration
+	maxSize int
+	now     func() time.Time
+
+	seen  map[string]time.Time
+	order []string // ключи в порядке добавления, он же порядок истечения
+}
+
+func NewCache(ttl time.Duration, maxSize int) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		now:     time.Now,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// Contains reports whether the key has been added and is not expired.
+func (c *Cache) Contains(key string) bool {
+	c.purge()
+	_, ok := c.seen[key]
+	return ok
+}
+
+// Add remembers the key.
+func (c *Cache) Add(key string) {
+	c.purge()
+	if _, ok := c.seen[key]; ok {
+		return
+	}
+	for len(c.order) > 0 && len(c.order) >= c.maxSize {
+		c.forgetOldest()
+	}
+	c.seen[key] = c.now().Add(c.ttl)
+	c.order = append(c.order, key)
+}
+
+// Len returns the number of remembered keys.
+func (c *Cache) Len() int {
+	return len(c.seen)
+}
+
+// purge forgets the expired keys.
+func (c *Cache) purge() {
+	now := c.now()
+	for len(c.order) > 0 && !c.seen[c.order[0]].After(now) {
+		c.forgetOldest()
+	}
+}
+
+func (c *Cache) forgetOldest() {
+	delete(c.seen, c.order[0])
+	c.order[0] = ""
+	c.order = c.order[1:]
+}
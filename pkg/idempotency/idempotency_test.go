@@ -0,0 +1,68 @@
+package idempotency
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"generated", NewKey(), false},
+		{"uuid", "7b0e0f3a-5c1e-4a53-9d0b-0c2f5c1f3a11", false},
+		{"empty", "", true},
+		{"too long", strings.Repeat("a", MaxKeyLength+1), true},
+		{"space", "batch 1", true},
+		{"non ascii", "пакет", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.key)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidKey)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+	assert.NotEqual(t, NewKey(), NewKey())
+}
+
+func TestContext(t *testing.T) {
+	assert.Empty(t, FromContext(context.Background()))
+	assert.Equal(t, "batch", FromContext(WithKey(context.Background(), "batch")))
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := NewCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Add("a")
+	assert.True(t, c.Contains("a"))
+	assert.False(t, c.Contains("b"))
+
+	now = now.Add(30 * time.Second)
+	c.Add("b")
+	c.Add("b")
+	assert.Equal(t, 2, c.Len())
+
+	// the oldest key is forgotten when the cache is full
+	c.Add("c")
+	assert.False(t, c.Contains("a"))
+	assert.True(t, c.Contains("b"))
+	assert.True(t, c.Contains("c"))
+
+	// the keys expire after ttl
+	now = now.Add(time.Minute)
+	assert.False(t, c.Contains("b"))
+	assert.False(t, c.Contains("c"))
+	assert.Equal(t, 0, c.Len())
+}
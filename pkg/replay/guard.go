@@ -0,0 +1,102 @@
+// Package replay protects signed requests from being replayed.
+package replay
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStale     = errors.New("request timestamp is outside of the allowed window")
+	ErrReplayed  = errors.New("request nonce has already been used")
+	ErrCacheFull = errors.New("nonce cache is full")
+	ErrNoNonce   = errors.New("request timestamp and nonce are required")
+)
+
+// Guard accepts a request once: its timestamp must be within the clock skew window
+// and its nonce must not have been seen. A nonce is remembered until its timestamp
+// leaves the window, after that the request is rejected as stale anyway.
+type Guard struct {
+	window  time.Duration
+	maxSize int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	seen    map[string]struct{}
+	expires expiryHeap
+}
+
+// NewGuard creates the guard, maxSize bounds the number of remembered nonces. When the cache
+// is full, new requests are rejected until old nonces expire, rather than forgetting nonces
+// which could be replayed.
+func NewGuard(window time.Duration, maxSize int) *Guard {
+	return &Guard{
+		window:  window,
+		maxSize: maxSize,
+		now:     time.Now,
+		seen:    make(map[string]struct{}),
+	}
+}
+
+// Check validates the timestamp and the nonce of the request and remembers the nonce.
+func (g *Guard) Check(timestamp time.Time, nonce string) error {
+	if nonce == "" || timestamp.IsZero() {
+		return ErrNoNonce
+	}
+
+	now := g.now()
+	// the request is stale from the moment its nonce is forgotten
+	if !timestamp.After(now.Add(-g.window)) || timestamp.After(now.Add(g.window)) {
+		return ErrStale
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for len(g.expires) > 0 && !g.expires[0].at.After(now) {
+		delete(g.seen, heap.Pop(&g.expires).(expiry).nonce)
+	}
+
+	if _, ok := g.seen[nonce]; ok {
+		return ErrReplayed
+	}
+	if len(g.seen) >= g.maxSize {
+		return ErrCacheFull
+	}
+
+	g.seen[nonce] = struct{}{}
+	heap.Push(&g.expires, expiry{nonce: nonce, at: timestamp.Add(g.window)})
+	return nil
+}
+
+// Len returns the number of remembered nonces.
+func (g *Guard) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.seen)
+}
+
+type expiry struct {
+	nonce string
+	at    time.Time
+}
+
+// expiryHeap the nonces ordered by the time they can be forgotten.
+type expiryHeap []expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x any) {
+	*h = append(*h, x.(expiry))
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
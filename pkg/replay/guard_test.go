@@ -0,0 +1,58 @@
+package replay
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuardCheck(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := NewGuard(time.Minute, 10)
+	g.now = func() time.Time { return now }
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		nonce     string
+		want      error
+	}{
+		{"accepted", now, "a", nil},
+		{"replayed", now, "a", ErrReplayed},
+		{"clock skew behind", now.Add(-59 * time.Second), "b", nil},
+		{"clock skew ahead", now.Add(59 * time.Second), "c", nil},
+		{"too old", now.Add(-61 * time.Second), "d", ErrStale},
+		{"too new", now.Add(61 * time.Second), "e", ErrStale},
+		{"no nonce", now, "", ErrNoNonce},
+		{"no timestamp", time.Time{}, "f", ErrNoNonce},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, g.Check(tt.timestamp, tt.nonce), tt.want)
+		})
+	}
+	assert.Equal(t, 3, g.Len())
+}
+
+func TestGuardExpiresNonces(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g := NewGuard(time.Minute, 3)
+	g.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, g.Check(now.Add(time.Duration(i)*time.Second), fmt.Sprint(i)))
+	}
+	// the cache is full, new requests are rejected instead of forgetting nonces
+	assert.ErrorIs(t, g.Check(now, "new"), ErrCacheFull)
+
+	// the first nonce leaves the window, its request would be stale anyway
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, g.Check(now.Add(-time.Minute), "0"), ErrStale)
+	assert.NoError(t, g.Check(now, "new"))
+	assert.Equal(t, 3, g.Len())
+
+	// nonces within the window are still remembered
+	assert.ErrorIs(t, g.Check(now.Add(-58*time.Second), "2"), ErrReplayed)
+}
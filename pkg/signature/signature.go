@@ -0,0 +1,120 @@
+// Package signature signs messages with HMAC-SHA256 hex digests.
+//
+// The signing scheme of the REST API, the lowercase hex HMAC-SHA256 is passed in the Header:
+//   - a request is signed over `method " " target "\n" timestamp "\n" nonce "\n" body`, where
+//     the target is the path with the query as sent in the request line, the timestamp is the unix
+//     time in seconds from the TimestampHeader, the nonce is a random string from the NonceHeader
+//     and the body is the exact sequence of bytes transmitted, that is after the gzip compression
+//     and the encryption. The method and the target are signed as the path-style updates carry
+//     the metric in the path and have no body;
+//   - a response is signed over the body without the Content-Encoding applied,
+//     as HTTP clients decode the encoding transparently.
+//
+// Requests are signed by the agent and responses by the server with the current key,
+// signatures made with any of the active keys are accepted, so the keys can be rotated
+// without downtime. The timestamp and the nonce let the server reject replayed requests.
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Header          = "HashSHA256"  // заголовок с подписью
+	TimestampHeader = "X-Timestamp" // заголовок с временем подписи запроса, unix секунды
+	NonceHeader     = "X-Nonce"     // заголовок с уникальной строкой запроса
+)
+
+// NewNonce returns a random nonce of the request.
+func NewNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// FormatTimestamp formats the time of the request signing.
+func FormatTimestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// ParseTimestamp parses the time of the request signing, zero time if it is not valid.
+func ParseTimestamp(s string) time.Time {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// RequestParts returns the signed material of the request.
+func RequestParts(timestamp, nonce string, body []byte) [][]byte {
+	return [][]byte{[]byte(timestamp + "\n" + nonce + "\n"), body}
+}
+
+// HTTPRequestParts returns the signed material of the HTTP request, which also covers
+// the method and the request target, e.g. `/update/counter/PollCount/1?host=a`.
+func HTTPRequestParts(method, target, timestamp, nonce string, body []byte) [][]byte {
+	return append([][]byte{[]byte(method + " " + target + "\n")}, RequestParts(timestamp, nonce, body)...)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the parts concatenated.
+func Sign(key string, parts ...[]byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the hex signature of the parts in constant time.
+func Verify(key, sig string, parts ...[]byte) bool {
+	expected, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return hmac.Equal(expected, mac.Sum(nil))
+}
+
+// Keys the active signing keys, the first one is the current key.
+type Keys []string
+
+// ParseKeys splits the comma separated list of keys, empty items are skipped.
+func ParseKeys(s string) Keys {
+	var keys Keys
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Enabled reports whether any key is set.
+func (k Keys) Enabled() bool {
+	return len(k) > 0
+}
+
+// Sign signs the parts with the current key.
+func (k Keys) Sign(parts ...[]byte) string {
+	return Sign(k[0], parts...)
+}
+
+// Verify checks the signature against all active keys.
+func (k Keys) Verify(sig string, parts ...[]byte) bool {
+	for _, key := range k {
+		if Verify(key, sig, parts...) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package signature
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignVerify(t *testing.T) {
+	// RFC 4231, test case 2
+	assert.Equal(t,
+		"5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843",
+		Sign("Jefe", []byte("what do ya want "), []byte("for nothing?")),
+	)
+
+	sig := Sign("key", []byte("body"))
+	tests := []struct {
+		name string
+		key  string
+		sig  string
+		body string
+		want bool
+	}{
+		{"valid", "key", sig, "body", true},
+		{"other key", "other", sig, "body", false},
+		{"other body", "key", sig, "body2", false},
+		{"not hex", "key", "zz", "body", false},
+		{"empty", "key", "", "body", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Verify(tt.key, tt.sig, []byte(tt.body)))
+		})
+	}
+}
+
+func TestKeys(t *testing.T) {
+	keys := ParseKeys(" current, ,previous,")
+	assert.Equal(t, Keys{"current", "previous"}, keys)
+	assert.True(t, keys.Enabled())
+	assert.False(t, ParseKeys("").Enabled())
+
+	body := []byte("body")
+	assert.Equal(t, Sign("current", body), keys.Sign(body))
+
+	assert.True(t, keys.Verify(Sign("current", body), body))
+	assert.True(t, keys.Verify(Sign("previous", body), body))
+	assert.False(t, keys.Verify(Sign("retired", body), body))
+}
+
+func TestRequestParts(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := FormatTimestamp(now)
+	assert.Equal(t, "1700000000", ts)
+	assert.Equal(t, now, ParseTimestamp(ts))
+	assert.True(t, ParseTimestamp("soon").IsZero())
+
+	nonce := NewNonce()
+	assert.Len(t, nonce, 32)
+	assert.NotEqual(t, nonce, NewNonce())
+
+	body := []byte("body")
+	sig := Sign("key", RequestParts(ts, nonce, body)...)
+	assert.Equal(t, Sign("key", []byte(ts+"\n"+nonce+"\nbody")), sig)
+	assert.False(t, Verify("key", sig, RequestParts(ts, NewNonce(), body)...))
+
+	sig = Sign("key", HTTPRequestParts("POST", "/update/counter/PollCount/1", ts, nonce, nil)...)
+	assert.Equal(t, Sign("key", []byte("POST /update/counter/PollCount/1\n"+ts+"\n"+nonce+"\n")), sig)
+	assert.False(t, Verify("key", sig, HTTPRequestParts("POST", "/update/counter/PollCount/100", ts, nonce, nil)...))
+	assert.False(t, Verify("key", sig, HTTPRequestParts("GET", "/update/counter/PollCount/1", ts, nonce, nil)...))
+}
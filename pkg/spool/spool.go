@@ -0,0 +1,458 @@
+// Package spool is a persistent FIFO queue of records in a directory of append-only segment files.
+//
+// A record is framed as `length(4) | crc32(4) | payload`, both numbers are big-endian.
+// Records are appended to the last segment, a new segment is started when the last one
+// reaches the segment size. The position of the oldest pending record is kept in the cursor file,
+// so the queue survives restarts, a torn record at the end of the last segment is truncated on Open.
+// The oldest segments are dropped when the total size exceeds the limit or when they are older
+// than the maximum age.
+package spool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/screamsoul/go-metrics-tpl/pkg/logging"
+	"go.uber.org/zap"
+)
+
+const (
+	segmentExt = ".seg"   // расширение файлов сегментов
+	cursorFile = "cursor" // файл с позицией первой неподтвержденной записи
+	headerSize = 8        // размер заголовка записи: длина и crc32
+)
+
+var ErrCorrupted = errors.New("spool record is corrupted")
+
+type segment struct {
+	id      uint64
+	size    int64
+	records int       // количество записей в сегменте
+	modTime time.Time // время последней записи
+}
+
+type cursor struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// Position identifies the record returned by Peek.
+type Position struct {
+	segment uint64
+	offset  int64
+}
+
+// Spool is safe for concurrent use.
+type Spool struct {
+	dir         string
+	maxSize     int64
+	segmentSize int64
+	maxAge      time.Duration
+	now         func() time.Time
+	logger      *zap.Logger
+
+	mu       sync.Mutex
+	segments []*segment // сегменты по возрастанию id, последний открыт на запись
+	lastID   uint64     // id последнего созданного сегмента, id не переиспользуются
+	writer   *os.File
+	cursor   cursor
+	consumed int // количество подтвержденных записей первого сегмента
+	dropped  int // количество записей, удаленных из-за ограничений размера и возраста
+}
+
+// Open opens the spool in dir creating the directory if needed. The total size of the segments
+// is kept under maxSize, a segment is at most a tenth of it, zero maxAge means no age limit.
+func Open(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if maxSize <= headerSize {
+		return nil, fmt.Errorf("spool size %d is too small", maxSize)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:         dir,
+		maxSize:     maxSize,
+		segmentSize: max(maxSize/10, headerSize+1),
+		maxAge:      maxAge,
+		now:         time.Now,
+		logger:      logging.GetLogger(),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// load reads the segments and the cursor left by the previous run.
+func (s *Spool) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, &segment{id: id, size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].id < s.segments[j].id })
+	if len(s.segments) > 0 {
+		s.lastID = s.segments[len(s.segments)-1].id
+	}
+
+	for i, seg := range s.segments {
+		valid, records, err := s.scan(seg.id, seg.size)
+		if err != nil {
+			return err
+		}
+		if valid < seg.size {
+			if i < len(s.segments)-1 {
+				s.logger.Warn("spool segment is corrupted, the tail is skipped", zap.Uint64("segment", seg.id))
+			}
+			if err := os.Truncate(s.segmentPath(seg.id), valid); err != nil {
+				return err
+			}
+			seg.size = valid
+		}
+		seg.records = records
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.cursor); err != nil {
+			s.logger.Warn("spool cursor is corrupted, replaying from the start", zap.Error(err))
+			s.cursor = cursor{}
+		}
+	}
+
+	// the segments before the cursor are consumed
+	for len(s.segments) > 0 && s.segments[0].id < s.cursor.Segment {
+		if err := s.removeFirst(); err != nil {
+			return err
+		}
+	}
+	if len(s.segments) == 0 || s.segments[0].id != s.cursor.Segment {
+		s.cursor = cursor{}
+		if len(s.segments) > 0 {
+			s.cursor.Segment = s.segments[0].id
+		}
+	}
+	if len(s.segments) > 0 {
+		first := s.segments[0]
+		s.cursor.Offset = min(s.cursor.Offset, first.size)
+		_, consumed, err := s.scan(first.id, s.cursor.Offset)
+		if err != nil {
+			return err
+		}
+		s.consumed = consumed
+	}
+	return nil
+}
+
+// scan returns the length of the valid records prefix of the segment up to limit and their number.
+func (s *Spool) scan(id uint64, limit int64) (int64, int, error) {
+	f, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var offset int64
+	var records int
+	for offset < limit {
+		_, n, err := readRecord(f, offset)
+		if err != nil {
+			break
+		}
+		offset += n
+		records++
+	}
+	return offset, records, nil
+}
+
+// readRecord reads the record at offset and returns its payload and framed size.
+func readRecord(f *os.File, offset int64) ([]byte, int64, error) {
+	var header [headerSize]byte
+	if _, err := f.ReadAt(header[:], offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	payload := make([]byte, length)
+	if _, err := f.ReadAt(payload, offset+headerSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, ErrCorrupted
+	}
+	return payload, headerSize + int64(length), nil
+}
+
+// Append writes the record to the end of the spool and syncs it to the disk.
+func (s *Spool) Append(payload []byte) error {
+	frameSize := int64(headerSize + len(payload))
+	if frameSize > s.maxSize {
+		return fmt.Errorf("spool record of %d bytes exceeds the spool size", len(payload))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+
+	last := s.last()
+	if last == nil || (last.size > 0 && last.size+frameSize > s.segmentSize) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		last = s.last()
+	}
+
+	frame := make([]byte, frameSize)
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:headerSize], crc32.ChecksumIEEE(payload))
+	copy(frame[headerSize:], payload)
+
+	if _, err := s.writer.Write(frame); err != nil {
+		return err
+	}
+	if err := s.writer.Sync(); err != nil {
+		return err
+	}
+	last.size += frameSize
+	last.records++
+	last.modTime = s.now()
+
+	// the oldest records are dropped to keep the size limit
+	for s.size() > s.maxSize && len(s.segments) > 1 {
+		s.dropFirst("size limit")
+	}
+	return nil
+}
+
+// Peek returns the oldest pending record and its position, ok is false when the spool is empty.
+// A segment with a corrupted record is dropped with the rest of its records.
+func (s *Spool) Peek() (payload []byte, pos Position, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+
+	for len(s.segments) > 0 {
+		first := s.segments[0]
+		if s.cursor.Offset >= first.size {
+			if len(s.segments) == 1 {
+				return nil, Position{}, false, nil
+			}
+			// the first segment is consumed
+			if err := s.removeFirst(); err != nil {
+				return nil, Position{}, false, err
+			}
+			continue
+		}
+
+		f, err := os.Open(s.segmentPath(first.id))
+		if err != nil {
+			return nil, Position{}, false, err
+		}
+		payload, _, err = readRecord(f, s.cursor.Offset)
+		_ = f.Close()
+		if errors.Is(err, ErrCorrupted) || errors.Is(err, io.ErrUnexpectedEOF) {
+			s.dropFirst("corrupted record")
+			continue
+		}
+		if err != nil {
+			return nil, Position{}, false, fmt.Errorf("read spool segment %d: %w", first.id, err)
+		}
+		return payload, Position{segment: first.id, offset: s.cursor.Offset}, true, nil
+	}
+	return nil, Position{}, false, nil
+}
+
+// Commit marks the record at pos as delivered. It does nothing when the record is no longer
+// the oldest pending one, e.g. its segment has been dropped by the limits after Peek.
+func (s *Spool) Commit(pos Position) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 || s.cursor.Segment != pos.segment || s.cursor.Offset != pos.offset {
+		return nil
+	}
+
+	f, err := os.Open(s.segmentPath(s.cursor.Segment))
+	if err != nil {
+		return err
+	}
+	_, n, err := readRecord(f, s.cursor.Offset)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	s.cursor.Offset += n
+	s.consumed++
+	if s.cursor.Offset >= s.segments[0].size && len(s.segments) > 1 {
+		return s.removeFirst()
+	}
+	return s.saveCursor()
+}
+
+// Len returns the number of pending records.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := -s.consumed
+	for _, seg := range s.segments {
+		n += seg.records
+	}
+	return n
+}
+
+// Dropped returns the number of records dropped because of the size and age limits.
+func (s *Spool) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writer == nil {
+		return nil
+	}
+	err := s.writer.Close()
+	s.writer = nil
+	return err
+}
+
+func (s *Spool) last() *segment {
+	if len(s.segments) == 0 || s.writer == nil {
+		return nil
+	}
+	return s.segments[len(s.segments)-1]
+}
+
+func (s *Spool) size() int64 {
+	var size int64
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+	return size
+}
+
+// rotate starts a new segment for writing.
+func (s *Spool) rotate() error {
+	if s.writer != nil {
+		if err := s.writer.Close(); err != nil {
+			return err
+		}
+		s.writer = nil
+	}
+
+	s.lastID++
+	id := s.lastID
+	f, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	s.writer = f
+	s.segments = append(s.segments, &segment{id: id, modTime: s.now()})
+	if len(s.segments) == 1 {
+		s.cursor = cursor{Segment: id}
+		s.consumed = 0
+	}
+	return nil
+}
+
+// expire drops the segments which have not been written for maxAge.
+func (s *Spool) expire() {
+	if s.maxAge <= 0 {
+		return
+	}
+	deadline := s.now().Add(-s.maxAge)
+	for len(s.segments) > 0 && s.segments[0].modTime.Before(deadline) {
+		s.dropFirst("age limit")
+	}
+}
+
+// dropFirst removes the first segment with its pending records.
+func (s *Spool) dropFirst(reason string) {
+	lost := s.segments[0].records - s.consumed
+	s.dropped += lost
+	s.logger.Warn("spool segment dropped", zap.String("reason", reason), zap.Int("records", lost))
+	if err := s.removeFirst(); err != nil {
+		s.logger.Error("remove spool segment error", zap.Error(err))
+	}
+}
+
+// removeFirst deletes the first segment and moves the cursor to the start of the next one.
+func (s *Spool) removeFirst() error {
+	first := s.segments[0]
+	if len(s.segments) == 1 && s.writer != nil {
+		_ = s.writer.Close()
+		s.writer = nil
+	}
+	s.segments = s.segments[1:]
+
+	s.cursor, s.consumed = cursor{}, 0
+	if len(s.segments) > 0 {
+		s.cursor.Segment = s.segments[0].id
+	}
+	if err := s.saveCursor(); err != nil {
+		return err
+	}
+	if err := os.Remove(s.segmentPath(first.id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// saveCursor replaces the cursor file atomically.
+func (s *Spool) saveCursor() error {
+	data, err := json.Marshal(s.cursor)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, cursorFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, cursorFile))
+}
@@ -0,0 +1,248 @@
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func record(i int) []byte {
+	return []byte(fmt.Sprintf("record-%03d", i))
+}
+
+// drain reads and commits all pending records.
+func drain(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var result []string
+	for {
+		payload, pos, ok, err := s.Peek()
+		require.NoError(t, err)
+		if !ok {
+			return result
+		}
+		result = append(result, string(payload))
+		require.NoError(t, s.Commit(pos))
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	return files
+}
+
+func TestSpoolOrder(t *testing.T) {
+	s, err := Open(t.TempDir(), 1000, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, _, ok, err := s.Peek()
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.Append(record(i)))
+	}
+	assert.Equal(t, 3, s.Len())
+
+	// the record stays in the spool until it is committed
+	for i := 0; i < 2; i++ {
+		payload, _, ok, err := s.Peek()
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, record(0), payload)
+	}
+
+	assert.Equal(t, []string{"record-000", "record-001", "record-002"}, drain(t, s))
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestSpoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, 1000, 0)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, s.Append(record(i)))
+	}
+	// the first records are delivered before the restart
+	for i := 0; i < 12; i++ {
+		_, pos, _, err := s.Peek()
+		require.NoError(t, err)
+		require.NoError(t, s.Commit(pos))
+	}
+	require.NoError(t, s.Close())
+
+	s, err = Open(dir, 1000, 0)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 8, s.Len())
+
+	require.NoError(t, s.Append(record(20)))
+
+	var want []string
+	for i := 12; i <= 20; i++ {
+		want = append(want, string(record(i)))
+	}
+	assert.Equal(t, want, drain(t, s))
+}
+
+func TestSpoolSegments(t *testing.T) {
+	dir := t.TempDir()
+	// a segment holds five records of 18 bytes
+	s, err := Open(dir, 900, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	for i := 0; i < 12; i++ {
+		require.NoError(t, s.Append(record(i)))
+	}
+	assert.Len(t, segmentFiles(t, dir), 3)
+
+	// the consumed segments are deleted
+	assert.Len(t, drain(t, s), 12)
+	assert.Len(t, segmentFiles(t, dir), 1)
+}
+
+func TestSpoolSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 180, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	for i := 0; i < 25; i++ {
+		require.NoError(t, s.Append(record(i)))
+	}
+
+	// only the newest records are kept
+	records := drain(t, s)
+	assert.LessOrEqual(t, len(records), 10)
+	assert.Equal(t, string(record(24)), records[len(records)-1])
+	assert.Equal(t, 25-len(records), s.Dropped())
+
+	assert.Error(t, s.Append(make([]byte, 200)))
+}
+
+func TestSpoolAgeLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s, err := Open(t.TempDir(), 900, time.Hour)
+	require.NoError(t, err)
+	defer s.Close()
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 6; i++ {
+		require.NoError(t, s.Append(record(i)))
+	}
+	now = now.Add(50 * time.Minute)
+	require.NoError(t, s.Append(record(6)))
+
+	// the first segment has not been written for an hour
+	now = now.Add(20 * time.Minute)
+	assert.Equal(t, []string{"record-005", "record-006"}, drain(t, s))
+	assert.Equal(t, 5, s.Dropped())
+}
+
+// The record dropped by the limits between Peek and Commit does not make Commit skip the next one.
+func TestSpoolCommitDropped(t *testing.T) {
+	tests := []struct {
+		name   string
+		fill   func(s *Spool, now *time.Time)
+		want   []string
+		maxAge time.Duration
+	}{
+		{
+			name: "size limit",
+			fill: func(s *Spool, _ *time.Time) {
+				for i := 1; i <= 10; i++ {
+					require.NoError(t, s.Append(record(i)))
+				}
+			},
+			want: []string{"record-001", "record-002", "record-003", "record-004", "record-005",
+				"record-006", "record-007", "record-008", "record-009", "record-010"},
+		},
+		{
+			name: "age limit",
+			fill: func(s *Spool, now *time.Time) {
+				*now = now.Add(2 * time.Hour)
+				require.NoError(t, s.Append(record(1)))
+				require.NoError(t, s.Append(record(2)))
+			},
+			want:   []string{"record-001", "record-002"},
+			maxAge: time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a segment holds a single record of 18 bytes
+			s, err := Open(t.TempDir(), 180, tt.maxAge)
+			require.NoError(t, err)
+			defer s.Close()
+			now := time.Unix(1700000000, 0)
+			s.now = func() time.Time { return now }
+
+			require.NoError(t, s.Append(record(0)))
+			payload, pos, ok, err := s.Peek()
+			require.NoError(t, err)
+			require.True(t, ok)
+			assert.Equal(t, record(0), payload)
+
+			tt.fill(s, &now)
+			require.NoError(t, s.Commit(pos))
+			assert.Equal(t, tt.want, drain(t, s))
+		})
+	}
+}
+
+func TestSpoolTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 1000, 0)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, s.Append(record(i)))
+	}
+	require.NoError(t, s.Close())
+
+	// the agent is killed in the middle of a write
+	files := segmentFiles(t, dir)
+	f, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 42, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = Open(dir, 1000, 0)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 2, s.Len())
+
+	require.NoError(t, s.Append(record(2)))
+	assert.Equal(t, []string{"record-000", "record-001", "record-002"}, drain(t, s))
+}
+
+func TestSpoolCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 900, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	for i := 0; i < 7; i++ {
+		require.NoError(t, s.Append(record(i)))
+	}
+
+	// the payload of the first record is damaged on the disk
+	files := segmentFiles(t, dir)
+	f, err := os.OpenFile(files[0], os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("X"), headerSize)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assert.Equal(t, []string{"record-005", "record-006"}, drain(t, s))
+	assert.Equal(t, 5, s.Dropped())
+}